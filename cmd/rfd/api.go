@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	localConfig "github.com/redazzo/rfd/cmd/rfd/internal/config"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+
+A read-only JSON API over the RFDs, served by 'rfd serve --api' from the same live view of the repository
+as the web UI:
+
+    GET /rfds                  the metadata of every RFD, optionally filtered and sorted
+    GET /rfds/{id}             the metadata and content of an RFD
+    GET /rfds/{id}/history     the commits that changed an RFD
+    GET /states                the states an RFD can be in
+    GET /openapi.json          the OpenAPI description of the above
+
+Each response carries an ETag derived from the hashes of the commits and readmes it was built from, so
+clients can poll cheaply with If-None-Match.
+
+*/
+
+//go:embed api/openapi.json
+var openAPIDescription []byte
+
+type apiRFD struct {
+	ID       string                 `json:"id"`
+	Title    string                 `json:"title"`
+	State    string                 `json:"state"`
+	Authors  []string               `json:"authors"`
+	Branch   string                 `json:"branch"`
+	Merged   bool                   `json:"merged"`
+	Commit   string                 `json:"commit"`
+	Metadata map[string]interface{} `json:"metadata"`
+	Content  string                 `json:"content,omitempty"`
+}
+
+type apiCommit struct {
+	Hash    string    `json:"hash"`
+	Author  string    `json:"author"`
+	Email   string    `json:"email"`
+	Date    time.Time `json:"date"`
+	Subject string    `json:"subject"`
+	Message string    `json:"message"`
+}
+
+type apiState struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Count       int    `json:"count"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+func (s *rfdServer) serveAPI(w http.ResponseWriter, req *http.Request, requestPath string) {
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	segments := strings.Split(strings.Trim(requestPath, "/"), "/")
+
+	switch {
+	case requestPath == "/openapi.json":
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(openAPIDescription)
+
+	case requestPath == "/states":
+		s.serveAPIStates(w, req)
+
+	case segments[0] == "rfds" && len(segments) == 1:
+		s.serveAPIRFDs(w, req)
+
+	case segments[0] == "rfds" && len(segments) == 2:
+		s.serveAPIRFD(w, req, segments[1], false)
+
+	case segments[0] == "rfds" && len(segments) == 3 && segments[2] == "history":
+		s.serveAPIRFD(w, req, segments[1], true)
+
+	default:
+		writeAPIError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// serveAPIRFDs lists the RFDs matching the filter parameter, which takes the same expressions as
+// 'rfd list', sorted by the sort parameter.
+func (s *rfdServer) serveAPIRFDs(w http.ResponseWriter, req *http.Request) {
+
+	matches, err := parseFilter(req.URL.Query().Get("filter"))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	sortKeys := req.URL.Query().Get("sort")
+	if sortKeys == "" {
+		sortKeys = "id"
+	}
+
+	s.mutex.RLock()
+	rfds := s.rfds
+	s.mutex.RUnlock()
+
+	var etag []string
+	var records []map[string]interface{}
+	byID := make(map[string]*siteRFD)
+
+	for _, page := range rfds {
+		etag = append(etag, page.Source.Commit.Hash.String()+page.Source.BlobHash.String())
+		record := getRFDFields(page.Source)
+		if matches.Matches(record) {
+			records = append(records, record)
+			byID[page.ID] = page
+		}
+	}
+
+	sortByFields(records, sortKeys)
+
+	result := []apiRFD{}
+	for _, record := range records {
+		result = append(result, getAPIRFD(byID[record["id"].(string)], false))
+	}
+
+	writeAPIResponse(w, req, getETag(etag...), result)
+}
+
+// serveAPIRFD serves an RFD, or its history.
+func (s *rfdServer) serveAPIRFD(w http.ResponseWriter, req *http.Request, id string, history bool) {
+
+	s.mutex.RLock()
+	page := s.byID[id]
+	s.mutex.RUnlock()
+
+	if page == nil {
+		writeAPIError(w, http.StatusNotFound, "RFD "+id+" not found")
+		return
+	}
+
+	if !history {
+		writeAPIResponse(w, req, getETag(page.Source.Commit.Hash.String(), page.Source.BlobHash.String()), getAPIRFD(page, true))
+		return
+	}
+
+	etag := getETag(page.Source.Commit.Hash.String())
+	if etagMatches(req, etag) {
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	commits, err := s.getHistory(page.Source)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeAPIResponse(w, req, etag, getAPICommits(commits))
+}
+
+func (s *rfdServer) serveAPIStates(w http.ResponseWriter, req *http.Request) {
+
+	s.mutex.RLock()
+	rfds := s.rfds
+	s.mutex.RUnlock()
+
+	counts := make(map[string]int)
+	var etag []string
+	for _, page := range rfds {
+		counts[page.State]++
+		etag = append(etag, page.ID+page.State)
+	}
+
+	result := []apiState{}
+	for _, state := range localConfig.APP_STATES.RFDStates {
+		for _, m := range state {
+			id, _ := strconv.Atoi(m["id"])
+			result = append(result, apiState{
+				ID:          id,
+				Name:        m["name"],
+				Description: m["description"],
+				Count:       counts[m["name"]],
+			})
+			etag = append(etag, m["id"]+m["name"]+m["description"])
+		}
+	}
+
+	writeAPIResponse(w, req, getETag(etag...), result)
+}
+
+func getAPIRFD(page *siteRFD, content bool) apiRFD {
+
+	result := apiRFD{
+		ID:       page.ID,
+		Title:    page.Title,
+		State:    page.State,
+		Authors:  getAuthorNames(page.Metadata),
+		Branch:   page.Branch,
+		Merged:   page.Merged,
+		Commit:   page.Source.Commit.Hash.String(),
+		Metadata: map[string]interface{}{},
+	}
+	if page.Metadata != nil {
+		result.Metadata = toJSONValue(page.Metadata).(map[string]interface{})
+	}
+	if result.Authors == nil {
+		result.Authors = []string{}
+	}
+	if content {
+		result.Content = string(page.Source.Content)
+	}
+
+	return result
+}
+
+func getAPICommits(commits []*object.Commit) []apiCommit {
+
+	result := []apiCommit{}
+	for _, commit := range commits {
+		result = append(result, apiCommit{
+			Hash:    commit.Hash.String(),
+			Author:  commit.Author.Name,
+			Email:   commit.Author.Email,
+			Date:    commit.Author.When,
+			Subject: getCommitSubject(commit),
+			Message: commit.Message,
+		})
+	}
+
+	return result
+}
+
+// getETag combines the hashes a response was built from into a strong ETag.
+func getETag(hashes ...string) string {
+	sum := sha1.Sum([]byte(strings.Join(hashes, "\n")))
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// etagMatches reports whether the client sent If-None-Match with the given ETag.
+func etagMatches(req *http.Request, etag string) bool {
+	for _, candidate := range strings.Split(req.Header.Get("If-None-Match"), ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// writeAPIResponse writes a value as JSON, or Not Modified if the client already has it.
+func writeAPIResponse(w http.ResponseWriter, req *http.Request, etag string, value interface{}) {
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+
+	if etagMatches(req, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetIndent("", "  ")
+	err := encoder.Encode(value)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(buf.Bytes())
+}
+
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(apiError{Error: message})
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	localConfig "github.com/redazzo/rfd/cmd/rfd/internal/config"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/*
+
+Approvals, and the quorum of them an RFD needs before it can be accepted.
+
+Who may approve, and how many approvals are needed, is set in the approvals section of config.yml.
+'rfd approve' records an approval in the approvals: field of the RFD's metadata as "Name <email>" of the
+git user, and commits it to the RFD's branch as that user.
+
+An approval counts towards the quorum if:
+
+1. The commit that added it was made by the approver. With a keyring of the approvers' OpenPGP public keys
+   set in config.yml, that means it's signed by a key in the keyring with the approver's email, which
+   'rfd approve' does when git is set to sign commits. Without one, approvals are taken on trust: the
+   commit need only give the approver as its author, which anyone making the commit can set.
+2. The approver is one of the configured approvers, or there aren't any configured.
+3. The approver isn't one of the RFD's authors.
+
+Only the approver's email is verified, so approvers and authors are matched by email alone, and an
+approver counts once however many names they approve under.
+
+Moving an RFD to accepted, whether by 'rfd state', 'rfd merge', 'rfd pr-sync --apply' or the web UI, is
+refused until enough approvals count.
+
+*/
+
+const APPROVALS_FIELD = "approvals"
+
+var approvalPattern = regexp.MustCompile(`^(.*?)\s*<([^>]*)>$`)
+
+// approveRFD records the git user's approval of an RFD, or withdraws it, as 'rfd approve' does.
+func approveRFD(rfdId string, withdraw bool) error {
+
+	r := openRepositoryToChange(rfdId)
+
+	signature, err := getSignature(r)
+	if err != nil {
+		return err
+	}
+	approver := signature.Name + " <" + signature.Email + ">"
+
+	if localConfig.APP_CONFIG.Approvals.Keyring != "" && !isSigningCommits(r) {
+		return fmt.Errorf("approvals need to be signed, set git's commit.gpgsign to true, and user.signingkey if your key isn't found by your email")
+	}
+
+	message := rfdId + ": Approve"
+	if withdraw {
+		message = rfdId + ": Withdraw approval"
+	}
+
+	err = updateRFD(r, rfdId, func(content []byte) ([]byte, error) {
+
+		var approvals []string
+		approved := false
+		for _, existing := range getApprovals(parseMetadata(content)) {
+			_, email := parseApproval(existing)
+			if strings.EqualFold(email, signature.Email) {
+				approved = true
+				continue
+			}
+			approvals = append(approvals, existing)
+		}
+
+		if approved && !withdraw {
+			return nil, fmt.Errorf("you have already approved RFD %s", rfdId)
+		}
+		if !approved && withdraw {
+			return nil, fmt.Errorf("you haven't approved RFD %s", rfdId)
+		}
+		if !withdraw {
+			approvals = append(approvals, approver)
+		}
+		if approvals == nil {
+			approvals = []string{}
+		}
+
+		value, err := formatFrontMatterValue(approvals)
+		if err != nil {
+			return nil, err
+		}
+		return setFrontMatterField(content, APPROVALS_FIELD, value), nil
+	}, message, nil)
+	if err != nil {
+		return err
+	}
+
+	if withdraw {
+		fmt.Println("Withdrew your approval of RFD " + rfdId)
+	} else {
+		fmt.Println("Approved RFD " + rfdId)
+	}
+	return nil
+}
+
+// checkQuorum returns an error if an RFD hasn't enough approvals to be accepted. The RFD is read from
+// its branch, or the trunk if it has no branch.
+func checkQuorum(r *git.Repository, rfdId string) error {
+
+	required := localConfig.APP_CONFIG.Approvals.Required
+	if required <= 0 {
+		return nil
+	}
+
+	head := getRFDBranch(r, rfdId)
+	if head == nil {
+		start, err := getRFDBranchStart(r, rfdId)
+		if err != nil {
+			return err
+		}
+		head = start
+	}
+	found := readRFDFromCommit(head, rfdId, rfdId)
+	if found == nil {
+		return fmt.Errorf("RFD %s has no readme on its branch", rfdId)
+	}
+
+	counted, err := getCountedApprovals(r, head, found)
+	if err != nil {
+		return err
+	}
+	if len(counted) >= required {
+		return nil
+	}
+
+	approvedBy := "no one"
+	if len(counted) > 0 {
+		approvedBy = strings.Join(counted, ", ")
+	}
+	return fmt.Errorf("RFD %s has %d of the %d approvals it needs to be accepted, it has been approved by %s. Approvals are made with 'rfd approve %s'",
+		rfdId, len(counted), required, approvedBy, rfdId)
+}
+
+// getCountedApprovals returns the names of those whose approval of an RFD counts towards its quorum.
+func getCountedApprovals(r *git.Repository, head *object.Commit, found *rfd) ([]string, error) {
+
+	verified, err := getVerifiedApprovers(r, head, found.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the email is verified, so each approver counts once however many names they approve under
+	var counted []string
+	seen := make(map[string]bool)
+	for _, approval := range getApprovals(found.Metadata) {
+		name, email := parseApproval(approval)
+		email = strings.ToLower(email)
+		if verified[email] && !seen[email] && isApprover(email) && !isAuthor(found.Metadata, email) {
+			seen[email] = true
+			counted = append(counted, name)
+		}
+	}
+
+	return counted, nil
+}
+
+// getVerifiedApprovers returns the emails, lower cased, of those who added their own approval to the
+// readme at path, in the history of head.
+func getVerifiedApprovers(r *git.Repository, head *object.Commit, path string) (map[string]bool, error) {
+
+	keyring := ""
+	if localConfig.APP_CONFIG.Approvals.Keyring != "" {
+		content, err := os.ReadFile(localConfig.APP_CONFIG.Approvals.Keyring)
+		if err != nil {
+			return nil, err
+		}
+		keyring = string(content)
+	}
+
+	commits, err := r.Log(&git.LogOptions{
+		From:       head.Hash,
+		PathFilter: func(file string) bool { return file == path },
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	verified := make(map[string]bool)
+	err = commits.ForEach(func(commit *object.Commit) error {
+
+		before := make(map[string]bool)
+		if commit.NumParents() > 0 {
+			parent, err := commit.Parent(0)
+			if err != nil {
+				return err
+			}
+			for _, approval := range getApprovals(parseMetadata(readFileFromCommit(parent, path))) {
+				before[approval] = true
+			}
+		}
+
+		for _, approval := range getApprovals(parseMetadata(readFileFromCommit(commit, path))) {
+			_, email := parseApproval(approval)
+			if !before[approval] && isMadeBy(commit, email, keyring) {
+				verified[strings.ToLower(email)] = true
+			}
+		}
+		return nil
+	})
+
+	return verified, err
+}
+
+// isMadeBy reports whether a commit was made by the owner of an email: signed by one of their keys in the
+// keyring, or if there's no keyring, authored as them.
+func isMadeBy(commit *object.Commit, email string, keyring string) bool {
+
+	if keyring == "" {
+		return strings.EqualFold(email, commit.Author.Email)
+	}
+
+	if commit.PGPSignature == "" {
+		return false
+	}
+	signer, err := commit.Verify(keyring)
+	if err != nil {
+		return false
+	}
+	for _, identity := range signer.Identities {
+		if identity.UserId != nil && strings.EqualFold(identity.UserId.Email, email) {
+			return true
+		}
+	}
+	return false
+}
+
+// getApprovals returns the approvals recorded in an RFD's metadata.
+func getApprovals(metadata map[string]interface{}) []string {
+
+	var approvals []string
+	if items, ok := metadata[APPROVALS_FIELD].([]interface{}); ok {
+		for _, item := range items {
+			approvals = append(approvals, fmt.Sprintf("%v", item))
+		}
+	}
+	return approvals
+}
+
+// parseApproval splits an approval into the approver's name and email.
+func parseApproval(approval string) (string, string) {
+	if match := approvalPattern.FindStringSubmatch(strings.TrimSpace(approval)); match != nil {
+		return match[1], match[2]
+	}
+	return strings.TrimSpace(approval), ""
+}
+
+// isApprover reports whether the owner of an email is one of the configured approvers, either listed or
+// a member of a listed group. Anyone is an approver if there are none configured.
+func isApprover(email string) bool {
+
+	config := localConfig.APP_CONFIG.Approvals
+	if len(config.Approvers) == 0 {
+		return true
+	}
+
+	for _, approver := range config.Approvers {
+		members := []string{approver}
+		if strings.HasPrefix(approver, "@") {
+			members = config.Groups[approver[1:]]
+		}
+		for _, member := range members {
+			if email != "" && strings.EqualFold(member, email) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// isAuthor reports whether the owner of an email is one of an RFD's authors, by the email given with them.
+func isAuthor(metadata map[string]interface{}, email string) bool {
+
+	authors := strings.ToLower(fmt.Sprintf("%v", metadata["authors"]))
+	return email != "" && strings.Contains(authors, "<"+strings.ToLower(email)+">")
+}
+
+// describeApprovals summarises an RFD's approvals for 'rfd status'.
+func describeApprovals(r *git.Repository, found *rfd) string {
+
+	approvals := getApprovals(found.Metadata)
+	required := localConfig.APP_CONFIG.Approvals.Required
+	if len(approvals) == 0 && required <= 0 {
+		return ""
+	}
+
+	var names []string
+	for _, approval := range approvals {
+		name, _ := parseApproval(approval)
+		names = append(names, name)
+	}
+	description := strings.Join(names, ", ")
+	if description == "" {
+		description = "none"
+	}
+
+	if required > 0 && found.Commit != nil {
+		counted, err := getCountedApprovals(r, found.Commit, found)
+		if err == nil {
+			description += " (" + strconv.Itoa(len(counted)) + " of " + strconv.Itoa(required) + " needed count)"
+		}
+	}
+
+	return description
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+	localConfig "github.com/redazzo/rfd/cmd/rfd/internal/config"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestKey(t *testing.T, name string, email string) *openpgp.Entity {
+	entity, err := openpgp.NewEntity(name, "", email, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entity
+}
+
+// newTestKeyring returns the armored public keys of entities.
+func newTestKeyring(t *testing.T, entities ...*openpgp.Entity) string {
+
+	var keyring bytes.Buffer
+	writer, err := armor.Encode(&keyring, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entity := range entities {
+		if err := entity.Serialize(writer); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writer.Close()
+
+	return keyring.String()
+}
+
+func signTestCommit(t *testing.T, commit *object.Commit, signer *openpgp.Entity) {
+
+	payload := &plumbing.MemoryObject{}
+	if err := commit.EncodeWithoutSignature(payload); err != nil {
+		t.Fatal(err)
+	}
+	reader, _ := payload.Reader()
+	content, _ := io.ReadAll(reader)
+	var signature bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&signature, signer, bytes.NewReader(content), nil); err != nil {
+		t.Fatal(err)
+	}
+	commit.PGPSignature = signature.String()
+}
+
+func TestIsMadeBy(t *testing.T) {
+
+	bob := newTestKey(t, "Bob", "bob@example.com")
+	mallory := newTestKey(t, "Mallory", "mallory@example.com")
+	keyring := newTestKeyring(t, bob, mallory)
+
+	newCommit := func(author string, signer *openpgp.Entity) *object.Commit {
+		commit := &object.Commit{
+			Author:    object.Signature{Name: "Someone", Email: author, When: time.Unix(0, 0)},
+			Committer: object.Signature{Name: "Someone", Email: author, When: time.Unix(0, 0)},
+			Message:   "0002: Approve",
+		}
+		if signer != nil {
+			signTestCommit(t, commit, signer)
+		}
+		return commit
+	}
+
+	tests := []struct {
+		name     string
+		commit   *object.Commit
+		keyring  string
+		expected bool
+	}{
+		{"authored, on trust", newCommit("bob@example.com", nil), "", true},
+		{"authored as someone else, on trust", newCommit("mallory@example.com", nil), "", false},
+		{"signed by the approver", newCommit("bob@example.com", bob), keyring, true},
+		{"authored as the approver, unsigned", newCommit("bob@example.com", nil), keyring, false},
+		{"authored as the approver, signed by someone else", newCommit("bob@example.com", mallory), keyring, false},
+	}
+
+	for _, test := range tests {
+		if madeBy := isMadeBy(test.commit, "bob@example.com", test.keyring); madeBy != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, madeBy)
+		}
+	}
+}
+
+func TestGetCountedApprovals(t *testing.T) {
+
+	bob := newTestKey(t, "Bob", "bob@example.com")
+	mallory := newTestKey(t, "Mallory", "mallory@example.com")
+
+	keyring := filepath.Join(t.TempDir(), "approvers.asc")
+	err := os.WriteFile(keyring, []byte(newTestKeyring(t, bob, mallory)), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	localConfig.APP_CONFIG = &localConfig.Configuration{
+		Approvals: localConfig.Approvals{
+			Required:  2,
+			Approvers: []string{"bob@example.com", "carol@example.com"},
+			Keyring:   keyring,
+		},
+	}
+
+	r, err := git.Init(memory.NewStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each commit adds an approval to the readme, signed by signer
+	var head *object.Commit
+	var approvals []string
+	approve := func(approval string, signer *openpgp.Entity) {
+
+		if approval != "" {
+			approvals = append(approvals, `"`+approval+`"`)
+		}
+		readme := "---\nid: 0002\ntitle: Approved\nauthors: Alice <alice@example.com>\nstate: discussion\n" +
+			"approvals: [" + strings.Join(approvals, ", ") + "]\n---\n"
+
+		var tree *object.Tree
+		var parents []plumbing.Hash
+		if head != nil {
+			tree, _ = head.Tree()
+			parents = []plumbing.Hash{head.Hash}
+		}
+		treeHash, err := buildTree(r, tree, map[string][]byte{"0002/readme.md": []byte(readme)})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		commit := &object.Commit{
+			Author:       object.Signature{Name: "Someone", Email: "someone@example.com", When: time.Unix(int64(len(approvals)), 0)},
+			Committer:    object.Signature{Name: "Someone", Email: "someone@example.com", When: time.Unix(int64(len(approvals)), 0)},
+			Message:      "0002: Approve",
+			TreeHash:     treeHash,
+			ParentHashes: parents,
+		}
+		if signer != nil {
+			signTestCommit(t, commit, signer)
+		}
+
+		encoded := r.Storer.NewEncodedObject()
+		if err := commit.Encode(encoded); err != nil {
+			t.Fatal(err)
+		}
+		hash, err := r.Storer.SetEncodedObject(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		head, err = r.CommitObject(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		approval string
+		signer   *openpgp.Entity
+		expected []string
+	}{
+		{"no approvals", "", nil, nil},
+		{"an approver's signed approval", "Bob <bob@example.com>", bob, []string{"Bob"}},
+		{"the same approver under another name", "Robert <bob@example.com>", bob, []string{"Bob"}},
+		{"an approver's name with a different key", "Carol <mallory@example.com>", mallory, []string{"Bob"}},
+		{"an approver's email as the name, with a different key", "carol@example.com <mallory@example.com>", mallory, []string{"Bob"}},
+	}
+
+	for _, test := range tests {
+
+		approve(test.approval, test.signer)
+		found := readRFDFromCommit(head, "0002", "0002")
+
+		counted, err := getCountedApprovals(r, head, found)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(counted, test.expected) {
+			t.Errorf("%s: expected %v to count, got %v", test.name, test.expected, counted)
+		}
+	}
+}
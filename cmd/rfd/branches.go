@@ -0,0 +1,136 @@
+package main
+
+import (
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	localConfig "github.com/redazzo/rfd/cmd/rfd/internal/config"
+)
+
+// getTrunkBranchName returns the name of the trunk branch, preferring the remote's default branch,
+// then main, then master.
+func getTrunkBranchName(r *git.Repository) string {
+
+	remoteHead, err := r.Reference(plumbing.NewRemoteHEADReferenceName("origin"), false)
+	if err == nil && remoteHead.Type() == plumbing.SymbolicReference {
+		return remoteHead.Target().Short()[len("origin/"):]
+	}
+
+	for _, candidate := range []string{"main", "master"} {
+		_, err := r.Reference(plumbing.NewBranchReferenceName(candidate), false)
+		if err == nil {
+			return candidate
+		}
+		_, err = r.Reference(plumbing.NewRemoteReferenceName("origin", candidate), false)
+		if err == nil {
+			return candidate
+		}
+	}
+
+	return "master"
+}
+
+// getCommit returns the commit a reference points to, or nil if the reference doesn't exist.
+func getCommit(r *git.Repository, name plumbing.ReferenceName) *object.Commit {
+
+	commit, err := findCommit(r, name)
+	localConfig.CheckFatal(err)
+
+	return commit
+}
+
+// findCommit returns the commit a reference points to, or nil if the reference doesn't exist, returning
+// an error rather than exiting if the commit can't be read.
+func findCommit(r *git.Repository, name plumbing.ReferenceName) (*object.Commit, error) {
+
+	ref, err := r.Reference(name, true)
+	if err != nil {
+		return nil, nil
+	}
+
+	return r.CommitObject(ref.Hash())
+}
+
+// getAddedRFDs returns the ids of the RFDs with a readme in commit that previous, which may be nil, doesn't
+// have a directory for.
+func getAddedRFDs(commit *object.Commit, previous *object.Commit) []string {
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil
+	}
+
+	var added []string
+	for _, entry := range tree.Entries {
+		isRFDDirectory, _ := localConfig.IsRFDIDFormat(entry.Name)
+		if !isRFDDirectory || entry.Mode.IsFile() || commitHasDirectory(previous, entry.Name) {
+			continue
+		}
+		if readRFDFromCommit(commit, entry.Name, "") != nil {
+			added = append(added, entry.Name)
+		}
+	}
+
+	return added
+}
+
+// commitHasDirectory reports whether the tree of a commit contains the given top-level directory.
+func commitHasDirectory(commit *object.Commit, directory string) bool {
+
+	if commit == nil {
+		return false
+	}
+
+	tree, err := commit.Tree()
+	localConfig.CheckFatal(err)
+
+	entry, err := tree.FindEntry(directory)
+	return err == nil && !entry.Mode.IsFile()
+}
+
+// hasDirectoryOf reports whether commit has every file in a directory of other, as other has it. The
+// trunk has the RFD of a branch that was squash-merged this way, plus anything added to it since such as
+// an archived discussion.
+func hasDirectoryOf(commit *object.Commit, other *object.Commit, directory string) bool {
+
+	if commit == nil || other == nil {
+		return false
+	}
+
+	tree, err := commit.Tree()
+	localConfig.CheckFatal(err)
+	otherTree, err := other.Tree()
+	localConfig.CheckFatal(err)
+
+	otherDirectory, err := otherTree.Tree(directory)
+	if err != nil {
+		return false
+	}
+
+	same := true
+	err = otherDirectory.Files().ForEach(func(file *object.File) error {
+		found, err := tree.File(directory + "/" + file.Name)
+		if err != nil || found.Hash != file.Hash {
+			same = false
+			return storer.ErrStop
+		}
+		return nil
+	})
+	localConfig.CheckFatal(err)
+
+	return same
+}
+
+// containsCommit reports whether other is the same commit as commit, or one of its ancestors.
+func containsCommit(commit *object.Commit, other *object.Commit) bool {
+
+	if commit.Hash == other.Hash {
+		return true
+	}
+
+	isAncestor, err := other.IsAncestor(commit)
+	localConfig.CheckFatal(err)
+
+	return isAncestor
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	localConfig "github.com/redazzo/rfd/cmd/rfd/internal/config"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+/*
+
+Checking out an RFD:
+
+1. If there's a local nnnn branch, switch to it.
+2. Otherwise fetch, and if there's a remote nnnn branch create a local branch from it that tracks the
+   remote branch, and switch to it.
+3. Otherwise, if the RFD has been merged into the trunk, create a local nnnn branch from the trunk so
+   that follow-up changes can be made, with upstream tracking set up for when it's pushed.
+
+Editing an RFD checks it out, opens its readme.md in $EDITOR, and when the editor exits offers to
+commit and push any changes.
+
+*/
+
+func checkoutRFD(rfdId string) error {
+
+	r, err := git.PlainOpen(".")
+	localConfig.CheckFatal(err)
+
+	w, err := r.Worktree()
+	localConfig.CheckFatal(err)
+
+	branchName := plumbing.NewBranchReferenceName(rfdId)
+
+	if getCommit(r, branchName) != nil {
+		localConfig.Logger.TraceLog("Found local branch " + rfdId)
+		return w.Checkout(&git.CheckoutOptions{
+			Branch: branchName,
+		})
+	}
+
+	fmt.Println("Fetching from origin ...")
+	err = localConfig.FetchFromOrigin(r)
+	if err != nil {
+		fmt.Println("Unable to fetch from origin, using the last fetched remote branches: " + err.Error())
+	}
+
+	start, err := getRFDBranchStart(r, rfdId)
+	if err != nil {
+		return err
+	}
+
+	err = w.Checkout(&git.CheckoutOptions{
+		Branch: branchName,
+		Hash:   start.Hash,
+		Create: true,
+	})
+	if err != nil {
+		return err
+	}
+
+	return setUpstream(r, rfdId)
+}
+
+// getRFDBranchStart returns the commit a new local branch for an RFD should start from: the remote
+// branch if there is one, otherwise the trunk if the RFD has been merged into it.
+func getRFDBranchStart(r *git.Repository, rfdId string) (*object.Commit, error) {
+
+	start := getCommit(r, plumbing.NewRemoteReferenceName("origin", rfdId))
+	if start != nil {
+		localConfig.Logger.TraceLog("Found remote branch " + rfdId)
+		return start, nil
+	}
+
+	trunkName := getTrunkBranchName(r)
+	start = getCommit(r, plumbing.NewRemoteReferenceName("origin", trunkName))
+	if start == nil {
+		start = getCommit(r, plumbing.NewBranchReferenceName(trunkName))
+	}
+	if !commitHasDirectory(start, rfdId) {
+		return nil, fmt.Errorf("RFD %s wasn't found locally, on the remote, or on the trunk", rfdId)
+	}
+	localConfig.Logger.TraceLog("Found RFD " + rfdId + " on " + trunkName)
+
+	return start, nil
+}
+
+func editRFD(rfdId string) error {
+
+	err := checkoutRFD(rfdId)
+	if err != nil {
+		return err
+	}
+
+	readmeFile := localConfig.GetRFDDirectory(rfdId) + localConfig.PATH_SEPARATOR + "readme.md"
+	before, err := os.ReadFile(readmeFile)
+	if err != nil {
+		return err
+	}
+
+	err = runEditor(readmeFile)
+	if err != nil {
+		return err
+	}
+
+	after, err := os.ReadFile(readmeFile)
+	if err != nil {
+		return err
+	}
+
+	if string(before) == string(after) {
+		fmt.Println("No changes made to RFD " + rfdId)
+		return nil
+	}
+
+	title := fmt.Sprintf("%v", parseMetadata(after)["title"])
+	message := rfdId + ": Update " + title
+
+	if !localConfig.GetUserConfirmation("Commit and push changes as '" + message + "'") {
+		return nil
+	}
+
+	return commitAndPushRFD(rfdId, message)
+}
+
+// commitAndPushRFD commits all changes in an RFD's directory, along with the regenerated index, to the
+// checked out RFD branch and pushes them. If the push fails it's queued for "rfd sync".
+func commitAndPushRFD(rfdId string, message string) error {
+
+	r, err := git.PlainOpen(".")
+	localConfig.CheckFatal(err)
+
+	w, err := r.Worktree()
+	localConfig.CheckFatal(err)
+
+	Index()
+
+	_, err = w.Add(rfdId + localConfig.PATH_SEPARATOR)
+	if err != nil {
+		return err
+	}
+	_, err = w.Add("index.md")
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Commit(message, &git.CommitOptions{})
+	if err != nil {
+		return err
+	}
+
+	return pushOrQueue(r, rfdId, !localConfig.APP_CONFIG.Offline, false)
+}
+
+func runEditor(file string) error {
+
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+		if runtime.GOOS == "windows" {
+			editor = "notepad"
+		}
+	}
+
+	// Allow for editors configured with arguments, e.g. "code --wait"
+	args := strings.Fields(editor)
+
+	cmd := exec.Command(args[0], append(args[1:], file)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
+}
+
+// parseRFDId turns a user supplied id such as "42" or "0042" into the nnnn format.
+func parseRFDId(arg string) (string, error) {
+
+	rfdNumber, err := strconv.Atoi(arg)
+	if err != nil || rfdNumber < 0 {
+		return "", fmt.Errorf("%q isn't a valid RFD id", arg)
+	}
+
+	return formatToNNNN(rfdNumber), nil
+}
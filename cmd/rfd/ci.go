@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	localConfig "github.com/redazzo/rfd/cmd/rfd/internal/config"
+	"os"
+	"path"
+	"strings"
+)
+
+/*
+
+Verifying an RFD's pull request, as a required check in CI:
+
+1. Work out the branch being verified, from --branch, the CI's environment, or what's checked out. Its name
+   must be an RFD id, and the branch must have that RFD, which must pass 'rfd lint'.
+2. Compare the branch with where it left the trunk. It may only change its RFD's directory, the index, and
+   the paths allowed in the ci section of config.yml.
+3. Check that no other RFD with the same id has been added to the trunk since the branch left it.
+4. Check that the trunk's copy of the RFD, if there is one, can get to the branch's state by the moves
+   states.yml allows.
+5. Check that the branch's index.md is as 'rfd index' would write it.
+
+Problems are written as for 'rfd lint', by default as annotations when run by GitHub Actions.
+
+*/
+
+var ciRules = []lintRule{
+	{"branch-name", "The branch is named for the RFD it holds."},
+	{"changed-files", "The branch only changes its RFD's directory, the index and the allowed paths."},
+	{"id-collision", "No other RFD with the same id has been added to the trunk."},
+	{"transition", "The RFD's state can be reached from its state on the trunk."},
+	{"index", "The index is up to date."},
+}
+
+func verifyPullRequest(base string, head string, branch string, allowed []string, format string) (int, error) {
+
+	if format == "" {
+		format = "text"
+		if os.Getenv("GITHUB_ACTIONS") == "true" {
+			format = "github"
+		}
+	}
+	err := checkProblemFormat(format)
+	if err != nil {
+		return 0, err
+	}
+
+	r, err := git.PlainOpen(".")
+	localConfig.CheckFatal(err)
+
+	if base == "" {
+		base = getTrunkBranchName(r)
+	}
+	baseCommit, err := resolveCommit(r, base, true)
+	if err != nil {
+		return 0, err
+	}
+	headCommit, err := resolveCommit(r, head, false)
+	if err != nil {
+		return 0, err
+	}
+
+	if branch == "" {
+		branch = getPullRequestBranch(r)
+	}
+	if branch == "" {
+		return 0, fmt.Errorf("unable to tell which branch is being verified, give it with --branch")
+	}
+
+	problems, err := verifyBranch(r, branch, baseCommit, headCommit, append(localConfig.APP_CONFIG.CI.AllowedPaths, allowed...))
+	if err != nil {
+		return 0, err
+	}
+
+	errors := countErrors(problems)
+	err = writeProblems(problems, append(append([]lintRule{}, lintRules...), ciRules...), format)
+	if format == "text" {
+		fmt.Printf("%d errors, %d warnings verifying %s against %s\n", errors, len(problems)-errors, branch, base)
+	}
+
+	return errors, err
+}
+
+// verifyBranch returns the problems with merging the RFD branch at head into the trunk at base.
+func verifyBranch(r *git.Repository, branch string, base *object.Commit, head *object.Commit, allowed []string) ([]lintProblem, error) {
+
+	isRFDBranch, err := localConfig.IsRFDIDFormat(branch)
+	localConfig.CheckFatal(err)
+	if !isRFDBranch {
+		return []lintProblem{{
+			Rule:     "branch-name",
+			Severity: LINT_ERROR,
+			Path:     branch,
+			Message:  fmt.Sprintf("the branch %s isn't named for an RFD, RFD branches are named by their id e.g. 0042", branch),
+		}}, nil
+	}
+
+	rfdId := branch
+	report := func(rule string, file string, line int, message string, args ...interface{}) lintProblem {
+		return lintProblem{Rule: rule, Severity: LINT_ERROR, ID: rfdId, Path: file, Line: line, Message: fmt.Sprintf(message, args...)}
+	}
+
+	found := readRFDFromCommit(head, rfdId, branch)
+	if found == nil {
+		return []lintProblem{report("branch-name", rfdId, 0, "the branch %s has no RFD %s, there's no readme.md in %s/", branch, rfdId, rfdId)}, nil
+	}
+
+	knownIds := make(map[string]bool)
+	for _, other := range collectRFDs(r) {
+		knownIds[other.ID] = true
+	}
+	problems := lintReadme(rfdId, found.Path, found.Content, newTreeLinkChecker(head, knownIds))
+
+	bases, err := head.MergeBase(base)
+	if err != nil {
+		return nil, err
+	}
+	if len(bases) == 0 {
+		return nil, fmt.Errorf("branch %s has no history in common with the trunk", branch)
+	}
+	mergeBase := bases[0]
+
+	// What the branch has changed since it left the trunk
+	mergeBaseTree, err := mergeBase.Tree()
+	if err != nil {
+		return nil, err
+	}
+	headTree, err := head.Tree()
+	if err != nil {
+		return nil, err
+	}
+	changes, err := mergeBaseTree.Diff(headTree)
+	if err != nil {
+		return nil, err
+	}
+	for _, change := range changes {
+		name := change.To.Name
+		if name == "" {
+			name = change.From.Name
+		}
+		if name == "index.md" || strings.HasPrefix(name, rfdId+"/") || isAllowedPath(name, allowed) {
+			continue
+		}
+		directory := strings.SplitN(name, "/", 2)[0]
+		if isOtherRFD, _ := localConfig.IsRFDIDFormat(directory); isOtherRFD && strings.Contains(name, "/") {
+			problems = append(problems, report("changed-files", name, 0, "the branch changes RFD %s as well as RFD %s, change each RFD on its own branch", directory, rfdId))
+		} else {
+			problems = append(problems, report("changed-files", name, 0, "the branch changes %s, which is outside %s/ and isn't an allowed path", name, rfdId))
+		}
+	}
+
+	// Another RFD given the same id on the trunk since the branch left it. An RFD already on the trunk
+	// when the branch left it is this RFD, merged earlier and being changed again.
+	trunkCopy := readRFDFromCommit(base, rfdId, "")
+	if trunkCopy != nil && !commitHasDirectory(mergeBase, rfdId) {
+		problems = append(problems, report("id-collision", found.Path, 1, "RFD %s, %q, has been added to the trunk since this branch left it, give this RFD another id", rfdId, trunkCopy.Title()))
+	} else {
+		problems = append(problems, checkStateReachable(found, trunkCopy)...)
+	}
+
+	if string(readFileFromTree(headTree, "index.md")) != string(IndexFromTree(headTree)) {
+		problems = append(problems, report("index", "index.md", 0, "index.md is out of date, run 'rfd index' and commit it"))
+	}
+
+	return problems, nil
+}
+
+// checkStateReachable returns a problem if an RFD can't get to its state from its state in before, nil if
+// it's new, by the moves states.yml allows.
+func checkStateReachable(found *rfd, before *rfd) []lintProblem {
+
+	if before == nil || isReachableState(before.State(), found.State()) {
+		return nil
+	}
+
+	return []lintProblem{{
+		Rule:     "transition",
+		Severity: LINT_ERROR,
+		ID:       found.ID,
+		Path:     found.Path,
+		Line:     getFrontMatterLine(found.Content, "state"),
+		Message:  fmt.Sprintf("RFD %s can't move from %s to %s, states.yml only allows %s to move to: %s", found.ID, before.State(), found.State(), before.State(), strings.Join(getNextStates(before.State()), ", ")),
+	}}
+}
+
+// resolveCommit returns the commit a revision names. If orRemote is set, a branch name is also looked for
+// on the remote, as CI often has the remote's copy of the trunk but no local branch.
+func resolveCommit(r *git.Repository, revision string, orRemote bool) (*object.Commit, error) {
+
+	candidates := []string{revision}
+	if orRemote {
+		candidates = append(candidates, "refs/remotes/origin/"+revision)
+	}
+
+	for _, candidate := range candidates {
+		hash, err := r.ResolveRevision(plumbing.Revision(candidate))
+		if err == nil {
+			return r.CommitObject(*hash)
+		}
+	}
+	return nil, fmt.Errorf("unable to find %s in the repository", revision)
+}
+
+// getPullRequestBranch returns the name of the branch being verified, from the environment of GitHub
+// Actions or GitLab CI, or what's checked out, or "" if that can't be told.
+func getPullRequestBranch(r *git.Repository) string {
+
+	for _, variable := range []string{"GITHUB_HEAD_REF", "CI_MERGE_REQUEST_SOURCE_BRANCH_NAME"} {
+		if branch := os.Getenv(variable); branch != "" {
+			return branch
+		}
+	}
+
+	head, err := r.Head()
+	if err != nil || !head.Name().IsBranch() {
+		return ""
+	}
+	return head.Name().Short()
+}
+
+// isAllowedPath reports whether a path from the root of the repository is one of the allowed paths or
+// globs, or under one ending in /.
+func isAllowedPath(name string, allowed []string) bool {
+
+	for _, pattern := range allowed {
+		pattern = strings.TrimPrefix(pattern, "/")
+		if strings.HasSuffix(pattern, "/") && strings.HasPrefix(name, pattern) {
+			return true
+		}
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// getFrontMatterLine returns the line of a field in the metadata header, or 1 if it isn't there.
+func getFrontMatterLine(content []byte, field string) int {
+
+	lines := strings.Split(string(content), "\n")
+	for i := 1; i < len(lines) && strings.TrimSpace(lines[i]) != "---"; i++ {
+		if strings.HasPrefix(strings.ToLower(lines[i]), field+":") {
+			return i + 1
+		}
+	}
+	return 1
+}
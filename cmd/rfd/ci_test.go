@@ -0,0 +1,61 @@
+package main
+
+import (
+	localConfig "github.com/redazzo/rfd/cmd/rfd/internal/config"
+	"testing"
+)
+
+func TestIsReachableState(t *testing.T) {
+
+	localConfig.APP_STATES = &localConfig.States{RFDStates: []map[string]map[string]string{
+		{"state": {"name": "draft", "next": "discussion, abandoned"}},
+		{"state": {"name": "discussion", "next": "draft, accepted"}},
+		{"state": {"name": "accepted", "next": "committed"}},
+		{"state": {"name": "committed", "next": "abandoned"}},
+		{"state": {"name": "abandoned", "next": "abandoned"}},
+	}}
+
+	tests := []struct {
+		from     string
+		to       string
+		allowed  bool
+		expected bool
+	}{
+		{"draft", "discussion", true, true},
+		{"draft", "committed", false, true},
+		{"accepted", "draft", false, false},
+		{"abandoned", "draft", false, false},
+		{"committed", "draft", false, false},
+		{"unconfigured", "draft", true, true},
+		{"discussion", "discussion", true, true},
+	}
+
+	for _, test := range tests {
+		if allowed := isAllowedTransition(test.from, test.to); allowed != test.allowed {
+			t.Errorf("%s to %s: expected allowed %v, got %v", test.from, test.to, test.allowed, allowed)
+		}
+		if reachable := isReachableState(test.from, test.to); reachable != test.expected {
+			t.Errorf("%s to %s: expected reachable %v, got %v", test.from, test.to, test.expected, reachable)
+		}
+	}
+}
+
+func TestIsAllowedPath(t *testing.T) {
+
+	allowed := []string{"/docs/", "*.txt", "assets/*.png"}
+
+	tests := map[string]bool{
+		"docs/guide.md":     true,
+		"notes.txt":         true,
+		"assets/a.png":      true,
+		"assets/deep/a.png": false,
+		"0003/readme.md":    false,
+		"readme.md":         false,
+	}
+
+	for name, expected := range tests {
+		if isAllowedPath(name, allowed) != expected {
+			t.Errorf("%s: expected %v", name, expected)
+		}
+	}
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	localConfig "github.com/redazzo/rfd/cmd/rfd/internal/config"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+
+Review comments kept in the repository, for RFD repositories on git servers without pull requests to
+discuss them in.
+
+Comments are kept in nnnn/comments.yml next to the readme, as threads anchored to a line of the readme:
+
+    threads:
+      - id: 1
+        line: 12
+        section: Proposal
+        quote: Sessions will be kept in Redis.
+        resolved: false
+        comments:
+          - author: Bob
+            email: bob@example.com
+            date: 2026-10-19T09:30:00Z
+            text: Why not SQLite?
+
+The quote is the text of the line commented on, so that a thread can follow its line as the readme is
+edited. Commenting, replying and resolving each commit to the RFD's branch and push it, like any other
+change to the RFD.
+
+*/
+
+const COMMENTS_FILE = "comments.yml"
+
+type commentThreads struct {
+	Threads []*commentThread `yaml:"threads"`
+}
+
+type commentThread struct {
+	ID       int             `yaml:"id"`
+	Line     int             `yaml:"line"`
+	Section  string          `yaml:"section,omitempty"`
+	Quote    string          `yaml:"quote,omitempty"`
+	Resolved bool            `yaml:"resolved"`
+	Comments []reviewComment `yaml:"comments"`
+}
+
+type reviewComment struct {
+	Author string    `yaml:"author"`
+	Email  string    `yaml:"email,omitempty"`
+	Date   time.Time `yaml:"date"`
+	Text   string    `yaml:"text"`
+}
+
+// addComment starts a new thread on a line of an RFD's readme, or on a section given by its heading.
+func addComment(rfdId string, line int, section string, text string) error {
+
+	message := rfdId + ": Comment on line " + strconv.Itoa(line)
+	if section != "" {
+		message = rfdId + ": Comment on " + section
+	}
+
+	var threadId int
+	err := changeComments(rfdId, func(found *rfd, threads *commentThreads, comment reviewComment) error {
+
+		lines := strings.Split(string(found.Content), "\n")
+		if section != "" {
+			line = findSection(lines, section)
+			if line == 0 {
+				return fmt.Errorf("RFD %s has no section headed %q", rfdId, section)
+			}
+		}
+		if line < 1 || line > len(lines) {
+			return fmt.Errorf("line %d is outside the readme of RFD %s, which has %d lines", line, rfdId, len(lines))
+		}
+
+		threadId = 1
+		for _, thread := range threads.Threads {
+			if thread.ID >= threadId {
+				threadId = thread.ID + 1
+			}
+		}
+
+		threads.Threads = append(threads.Threads, &commentThread{
+			ID:       threadId,
+			Line:     line,
+			Section:  getSection(lines, line),
+			Quote:    strings.TrimSpace(lines[line-1]),
+			Comments: []reviewComment{comment},
+		})
+		return nil
+	}, text, message)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Started thread #" + strconv.Itoa(threadId) + " on RFD " + rfdId)
+	return nil
+}
+
+// replyToThread adds a comment to a thread, optionally resolving or unresolving it at the same time.
+// The text may be empty if the thread's only being resolved or unresolved.
+func replyToThread(rfdId string, threadId int, text string, resolve bool, unresolve bool) error {
+
+	message := rfdId + ": Reply to thread #" + strconv.Itoa(threadId)
+	if resolve {
+		message = rfdId + ": Resolve thread #" + strconv.Itoa(threadId)
+	} else if unresolve {
+		message = rfdId + ": Unresolve thread #" + strconv.Itoa(threadId)
+	}
+
+	err := changeComments(rfdId, func(found *rfd, threads *commentThreads, comment reviewComment) error {
+
+		thread := threads.find(threadId)
+		if thread == nil {
+			return fmt.Errorf("RFD %s has no thread #%d", rfdId, threadId)
+		}
+
+		if resolve {
+			if thread.Resolved {
+				return fmt.Errorf("thread #%d is already resolved", threadId)
+			}
+			thread.Resolved = true
+		}
+		if unresolve {
+			if !thread.Resolved {
+				return fmt.Errorf("thread #%d isn't resolved", threadId)
+			}
+			thread.Resolved = false
+		}
+
+		if comment.Text != "" {
+			thread.Comments = append(thread.Comments, comment)
+		}
+		return nil
+	}, text, message)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(message[len(rfdId)+2:] + " of RFD " + rfdId)
+	return nil
+}
+
+// changeComments makes a change to the comment threads of an RFD, committing it to the RFD's branch as
+// the configured git user. The change is given the comment to add, if it adds one.
+func changeComments(rfdId string, change func(found *rfd, threads *commentThreads, comment reviewComment) error, text string, message string) error {
+
+	r := openRepositoryToChange(rfdId)
+
+	signature, err := getSignature(r)
+	if err != nil {
+		return err
+	}
+	comment := reviewComment{
+		Author: signature.Name,
+		Email:  signature.Email,
+		Date:   signature.When.UTC().Truncate(time.Second),
+		Text:   strings.TrimSpace(text),
+	}
+
+	return updateRFDFiles(r, rfdId, func(found *rfd) (map[string][]byte, error) {
+
+		threads, err := readCommentThreads(found)
+		if err != nil {
+			return nil, err
+		}
+
+		err = change(found, threads, comment)
+		if err != nil {
+			return nil, err
+		}
+
+		var content bytes.Buffer
+		encoder := yaml.NewEncoder(&content)
+		encoder.SetIndent(2)
+		err = encoder.Encode(threads)
+		if err != nil {
+			return nil, err
+		}
+
+		return map[string][]byte{path.Dir(found.Path) + "/" + COMMENTS_FILE: content.Bytes()}, nil
+	}, message, nil)
+}
+
+// listComments prints the comment threads of an RFD, as 'rfd comments' does.
+func listComments(rfdId string, unresolvedOnly bool) error {
+
+	r, err := git.PlainOpen(".")
+	localConfig.CheckFatal(err)
+
+	found := findRFD(r, rfdId)
+	if found == nil {
+		return fmt.Errorf("RFD %s wasn't found locally, on the remote, or on the trunk", rfdId)
+	}
+
+	threads, err := readCommentThreads(found)
+	if err != nil {
+		return err
+	}
+
+	lines := strings.Split(string(found.Content), "\n")
+	shown := 0
+	for _, thread := range threads.Threads {
+
+		if unresolvedOnly && thread.Resolved {
+			continue
+		}
+		shown++
+
+		fmt.Println("#" + strconv.Itoa(thread.ID) + "  " + describeThreadAnchor(thread, lines) + ", " + describeThreadState(thread))
+		if thread.Quote != "" {
+			fmt.Println("    > " + thread.Quote)
+		}
+		for _, comment := range thread.Comments {
+			fmt.Println("    " + comment.Author + ", " + comment.Date.Local().Format("2006-01-02 15:04") + ":")
+			for _, line := range strings.Split(comment.Text, "\n") {
+				fmt.Println("      " + line)
+			}
+		}
+		fmt.Println()
+	}
+
+	if shown == 0 {
+		if unresolvedOnly {
+			fmt.Println("RFD " + rfdId + " has no unresolved comments")
+		} else {
+			fmt.Println("RFD " + rfdId + " has no comments")
+		}
+	}
+
+	return nil
+}
+
+// findRFD returns the most current copy of an RFD, from its branch or the trunk, or nil if there isn't one.
+func findRFD(r *git.Repository, rfdId string) *rfd {
+	for _, found := range collectRFDs(r) {
+		if found.ID == rfdId {
+			return found
+		}
+	}
+	return nil
+}
+
+// readCommentThreads reads the comment threads of an RFD, from wherever the RFD itself was read.
+func readCommentThreads(found *rfd) (*commentThreads, error) {
+
+	var content []byte
+	commentsPath := path.Dir(found.Path) + "/" + COMMENTS_FILE
+
+	if found.WorkingTree {
+		var err error
+		content, err = os.ReadFile(filepath.Join(localConfig.APP_CONFIG.RootDirectory, filepath.FromSlash(commentsPath)))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	} else if found.Commit != nil {
+		content = readFileFromCommit(found.Commit, commentsPath)
+	}
+
+	threads := &commentThreads{}
+	err := yaml.Unmarshal(content, threads)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %v", commentsPath, err)
+	}
+
+	return threads, nil
+}
+
+func (threads *commentThreads) find(threadId int) *commentThread {
+	for _, thread := range threads.Threads {
+		if thread.ID == threadId {
+			return thread
+		}
+	}
+	return nil
+}
+
+func (threads *commentThreads) unresolved() []*commentThread {
+	var unresolved []*commentThread
+	for _, thread := range threads.Threads {
+		if !thread.Resolved {
+			unresolved = append(unresolved, thread)
+		}
+	}
+	return unresolved
+}
+
+func describeThreadAnchor(thread *commentThread, lines []string) string {
+
+	anchor := "line " + strconv.Itoa(thread.Line)
+	if line := locateLine(lines, thread); line == 0 {
+		anchor += " (no longer in the readme)"
+	} else if line != thread.Line {
+		anchor = "line " + strconv.Itoa(line) + " (was " + strconv.Itoa(thread.Line) + ")"
+	}
+
+	if thread.Section != "" {
+		anchor += " in " + thread.Section
+	}
+	return anchor
+}
+
+func describeThreadState(thread *commentThread) string {
+	if thread.Resolved {
+		return "resolved"
+	}
+	return "unresolved"
+}
+
+// locateLine returns where the line a thread was started on is now, by finding the line quoted nearest
+// to where it was. It returns 0 if the line has gone.
+func locateLine(lines []string, thread *commentThread) int {
+
+	matches := func(line int) bool {
+		return line >= 1 && line <= len(lines) && strings.TrimSpace(lines[line-1]) == thread.Quote
+	}
+
+	if thread.Quote == "" {
+		if thread.Line <= len(lines) {
+			return thread.Line
+		}
+		return 0
+	}
+
+	for distance := 0; distance < len(lines); distance++ {
+		if matches(thread.Line - distance) {
+			return thread.Line - distance
+		}
+		if matches(thread.Line + distance) {
+			return thread.Line + distance
+		}
+	}
+
+	return 0
+}
+
+// getSection returns the text of the heading a line falls under, or "" if it comes before any heading.
+func getSection(lines []string, line int) string {
+
+	section := ""
+	forEachHeading(lines, func(headingLine int, heading string) {
+		if headingLine <= line {
+			section = heading
+		}
+	})
+	return section
+}
+
+// findSection returns the line of the heading with the given text, ignoring case, or 0 if there isn't one.
+func findSection(lines []string, section string) int {
+
+	found := 0
+	forEachHeading(lines, func(headingLine int, heading string) {
+		if found == 0 && strings.EqualFold(heading, strings.TrimSpace(strings.TrimLeft(section, "#"))) {
+			found = headingLine
+		}
+	})
+	return found
+}
+
+// forEachHeading calls visit with the line number and text of each markdown heading, skipping the
+// metadata header and code blocks.
+func forEachHeading(lines []string, visit func(line int, heading string)) {
+
+	start := 0
+	if len(lines) > 0 && strings.TrimSpace(lines[0]) == "---" {
+		for i := 1; i < len(lines); i++ {
+			if strings.TrimSpace(lines[i]) == "---" {
+				start = i + 1
+				break
+			}
+		}
+	}
+
+	inCode := false
+	for i := start; i < len(lines); i++ {
+
+		trimmed := strings.TrimSpace(lines[i])
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			inCode = !inCode
+			continue
+		}
+		if inCode || !strings.HasPrefix(lines[i], "#") {
+			continue
+		}
+
+		heading := strings.TrimSpace(strings.TrimLeft(lines[i], "#"))
+		if heading != "" {
+			visit(i+1, heading)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const commentedReadme = `---
+id: 0042
+title: Sessions
+---
+
+# Purpose
+
+Sessions will be kept in Redis.
+
+` + "```" + `
+# not a heading
+` + "```" + `
+
+## Alternatives
+
+Sessions will be kept in Redis.`
+
+func TestGetSection(t *testing.T) {
+
+	lines := strings.Split(commentedReadme, "\n")
+
+	tests := []struct {
+		line     int
+		expected string
+	}{
+		{2, ""},
+		{6, "Purpose"},
+		{8, "Purpose"},
+		{11, "Purpose"},
+		{14, "Alternatives"},
+	}
+
+	for _, test := range tests {
+		if section := getSection(lines, test.line); section != test.expected {
+			t.Errorf("Expected line %d to be in %q, found %q", test.line, test.expected, section)
+		}
+	}
+
+	if line := findSection(lines, "## alternatives"); line != 14 {
+		t.Errorf("Expected the Alternatives section at line 14, found %d", line)
+	}
+	if line := findSection(lines, "not a heading"); line != 0 {
+		t.Errorf("Expected no section in a code block, found line %d", line)
+	}
+}
+
+func TestLocateLine(t *testing.T) {
+
+	lines := strings.Split(commentedReadme, "\n")
+	quote := "Sessions will be kept in Redis."
+
+	tests := []struct {
+		line     int
+		quote    string
+		expected int
+	}{
+		{8, quote, 8},
+		{6, quote, 8},
+		{15, quote, 16},
+		{8, "Sessions will be kept in memory.", 0},
+		{14, "", 14},
+		{99, "", 0},
+	}
+
+	for _, test := range tests {
+		thread := &commentThread{Line: test.line, Quote: test.quote}
+		if line := locateLine(lines, thread); line != test.expected {
+			t.Errorf("Expected a thread on line %d quoting %q to be at line %d, found %d", test.line, test.quote, test.expected, line)
+		}
+	}
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"io"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+
+Commits are normally made through the worktree, which means the branch being committed to has to be
+checked out. The functions here build trees and commits directly from git objects instead, so that an
+RFD branch can be created or updated without touching the current checkout.
+
+If git is set to sign commits, with commit.gpgsign, they're signed with gpg as git would sign them: with
+user.signingkey, or the key for the committer's email if that isn't set, and gpg.program if it's set.
+
+*/
+
+// commitFilesToBranch commits a set of changes on top of parent, and points branch at the new commit.
+// Files are given by their slash separated path from the root of the repository; a nil content removes
+// the file. The parent may be nil to start a new history. The author may be nil, in which case the
+// configured git user is both author and committer.
+func commitFilesToBranch(r *git.Repository, branch string, parent *object.Commit, files map[string][]byte, message string, author *object.Signature) (*object.Commit, error) {
+
+	var parentTree *object.Tree
+	var parents []*object.Commit
+	if parent != nil {
+		tree, err := parent.Tree()
+		if err != nil {
+			return nil, err
+		}
+		parentTree = tree
+		parents = append(parents, parent)
+	}
+
+	treeHash, err := buildTree(r, parentTree, files)
+	if err != nil {
+		return nil, err
+	}
+
+	return commitTree(r, branch, treeHash, parents, message, author)
+}
+
+// commitTree creates a commit of the given tree with the given parents, and points branch at it.
+func commitTree(r *git.Repository, branch string, treeHash plumbing.Hash, parents []*object.Commit, message string, author *object.Signature) (*object.Commit, error) {
+
+	signature, err := getSignature(r)
+	if err != nil {
+		return nil, err
+	}
+	if author == nil {
+		author = signature
+	}
+
+	commit := &object.Commit{
+		Author:    *author,
+		Committer: *signature,
+		Message:   message,
+		TreeHash:  treeHash,
+	}
+	for _, parent := range parents {
+		commit.ParentHashes = append(commit.ParentHashes, parent.Hash)
+	}
+
+	if isSigningCommits(r) {
+		err = signCommit(r, commit)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	obj := r.Storer.NewEncodedObject()
+	err = commit.Encode(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	commitHash, err := r.Storer.SetEncodedObject(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName(branch), commitHash))
+	if err != nil {
+		return nil, err
+	}
+
+	return r.CommitObject(commitHash)
+}
+
+// isSigningCommits reports whether git is set to sign commits.
+func isSigningCommits(r *git.Repository) bool {
+	return strings.EqualFold(getGitOption(r, "commit", "gpgsign"), "true")
+}
+
+// getGitOption returns an option from the repository's git config, falling back to the user's and then
+// the system's as git does, or "" if it isn't set.
+func getGitOption(r *git.Repository, section string, key string) string {
+
+	if local, err := r.Config(); err == nil {
+		if value := local.Raw.Section(section).Option(key); value != "" {
+			return value
+		}
+	}
+	for _, scope := range []config.Scope{config.GlobalScope, config.SystemScope} {
+		if scoped, err := config.LoadConfig(scope); err == nil {
+			if value := scoped.Raw.Section(section).Option(key); value != "" {
+				return value
+			}
+		}
+	}
+	return ""
+}
+
+// signCommit adds a gpg signature to a commit.
+func signCommit(r *git.Repository, commit *object.Commit) error {
+
+	payload := &plumbing.MemoryObject{}
+	err := commit.EncodeWithoutSignature(payload)
+	if err != nil {
+		return err
+	}
+	reader, err := payload.Reader()
+	if err != nil {
+		return err
+	}
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+
+	key := getGitOption(r, "user", "signingkey")
+	if key == "" {
+		key = commit.Committer.Email
+	}
+	program := getGitOption(r, "gpg", "program")
+	if program == "" {
+		program = "gpg"
+	}
+
+	var signature, errors bytes.Buffer
+	cmd := exec.Command(program, "--status-fd=2", "-bsau", key)
+	cmd.Stdin = bytes.NewReader(content)
+	cmd.Stdout = &signature
+	cmd.Stderr = &errors
+	err = cmd.Run()
+	if err != nil {
+		return fmt.Errorf("unable to sign the commit with %s: %v\n%s", program, err, errors.String())
+	}
+
+	commit.PGPSignature = signature.String()
+	return nil
+}
+
+// buildTree writes a new tree made from an existing tree (which may be nil) with the given files added,
+// replaced or, where their content is nil, removed. Directories left empty are dropped.
+func buildTree(r *git.Repository, tree *object.Tree, files map[string][]byte) (plumbing.Hash, error) {
+
+	entries := make(map[string]object.TreeEntry)
+	if tree != nil {
+		for _, entry := range tree.Entries {
+			entries[entry.Name] = entry
+		}
+	}
+
+	// Split the changes into those for this directory and those for sub-directories
+	subdirectories := make(map[string]map[string][]byte)
+	for path, content := range files {
+
+		slash := strings.Index(path, "/")
+		if slash >= 0 {
+			name := path[:slash]
+			if subdirectories[name] == nil {
+				subdirectories[name] = make(map[string][]byte)
+			}
+			subdirectories[name][path[slash+1:]] = content
+			continue
+		}
+
+		if content == nil {
+			delete(entries, path)
+			continue
+		}
+
+		blobHash, err := writeBlob(r, content)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		entries[path] = object.TreeEntry{Name: path, Mode: filemode.Regular, Hash: blobHash}
+	}
+
+	for name, subFiles := range subdirectories {
+
+		var subtree *object.Tree
+		if entry, ok := entries[name]; ok && entry.Mode == filemode.Dir {
+			existing, err := r.TreeObject(entry.Hash)
+			if err != nil {
+				return plumbing.ZeroHash, err
+			}
+			subtree = existing
+		}
+
+		subtreeHash, err := buildTree(r, subtree, subFiles)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+
+		if subtreeHash == emptyTreeHash {
+			delete(entries, name)
+			continue
+		}
+		entries[name] = object.TreeEntry{Name: name, Mode: filemode.Dir, Hash: subtreeHash}
+	}
+
+	newTree := &object.Tree{}
+	for _, entry := range entries {
+		newTree.Entries = append(newTree.Entries, entry)
+	}
+
+	// Git orders entries by name, comparing directories as though they end in a slash
+	sort.Slice(newTree.Entries, func(i, j int) bool {
+		return treeSortName(newTree.Entries[i]) < treeSortName(newTree.Entries[j])
+	})
+
+	obj := r.Storer.NewEncodedObject()
+	err := newTree.Encode(obj)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return r.Storer.SetEncodedObject(obj)
+}
+
+var emptyTreeHash = plumbing.NewHash("4b825dc642cb6eb9a060e54bf8d69288fbee4904")
+
+func treeSortName(entry object.TreeEntry) string {
+	if entry.Mode == filemode.Dir {
+		return entry.Name + "/"
+	}
+	return entry.Name
+}
+
+func writeBlob(r *git.Repository, content []byte) (plumbing.Hash, error) {
+
+	obj := r.Storer.NewEncodedObject()
+	obj.SetType(plumbing.BlobObject)
+
+	writer, err := obj.Writer()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	_, err = writer.Write(content)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	err = writer.Close()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return r.Storer.SetEncodedObject(obj)
+}
+
+// getSignature returns the signature of the configured git user, as the worktree would use for commits.
+func getSignature(r *git.Repository) (*object.Signature, error) {
+
+	cfg, err := r.ConfigScoped(config.SystemScope)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.User.Name == "" || cfg.User.Email == "" {
+		return nil, fmt.Errorf("git user.name and user.email must be configured")
+	}
+
+	return &object.Signature{
+		Name:  cfg.User.Name,
+		Email: cfg.User.Email,
+		When:  time.Now(),
+	}, nil
+}
+
+// readFileFromCommit returns the content of a file in a commit, or nil if it doesn't exist.
+func readFileFromCommit(commit *object.Commit, path string) []byte {
+
+	if commit == nil {
+		return nil
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil
+	}
+
+	return readFileFromTree(tree, path)
+}
+
+// readFileFromTree returns the content of a file in a tree, or nil if it doesn't exist.
+func readFileFromTree(tree *object.Tree, path string) []byte {
+
+	file, err := tree.File(path)
+	if err != nil {
+		return nil
+	}
+
+	content, err := file.Contents()
+	if err != nil {
+		return nil
+	}
+
+	return []byte(content)
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	localConfig "github.com/redazzo/rfd/cmd/rfd/internal/config"
+	"github.com/redazzo/rfd/cmd/rfd/internal/notify"
+	"github.com/yuin/goldmark"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+
+A digest of what's happened to RFDs over a period, such as the last week, to be run from cron:
+
+1. Fetch, and collect every RFD, merged or not.
+2. Work out from each RFD's history which were created in the period, and what changed state in it.
+3. List the RFDs waiting on review, which are those in discussion, and those that have been in their state
+   for longer than its max-age in states.yml, or in draft or discussion for longer than --stale-after.
+4. Write the digest as markdown, HTML or plain text, to stdout or, with --send, to the notification
+   channels that want digests.
+
+*/
+
+const DEFAULT_DIGEST_PERIOD = "7d"
+const DEFAULT_STALE_THRESHOLD = "30d"
+
+// digest is what has happened to RFDs between Since and Until, and where they're waiting.
+type digest struct {
+	Since   time.Time
+	Until   time.Time
+	Created []digestEntry
+	Changes []digestEntry
+	Waiting []digestEntry
+	Stale   []digestEntry
+}
+
+// digestEntry is an RFD in a digest, with the change or state it's listed for.
+type digestEntry struct {
+	ID      string
+	Title   string
+	Authors string
+	State   string
+	From    string
+	Time    time.Time
+	By      string
+	Detail  string
+}
+
+func writeDigest(period string, staleAfter string, format string, send bool) error {
+
+	age, err := parseAge(period)
+	if err != nil {
+		return err
+	}
+	staleAge, err := parseAge(staleAfter)
+	if err != nil {
+		return err
+	}
+	if format != "markdown" && format != "html" && format != "text" {
+		return fmt.Errorf("unknown format %q, expected markdown, html or text", format)
+	}
+
+	var notifier *notify.Notifier
+	if send {
+		notifier, err = getNotifier()
+		if err != nil {
+			return err
+		}
+		if notifier == nil {
+			return fmt.Errorf("no notification channels are configured, see the notifications section of config.yml")
+		}
+	}
+
+	r, err := git.PlainOpen(".")
+	localConfig.CheckFatal(err)
+
+	if !localConfig.APP_CONFIG.Offline {
+		err = localConfig.FetchFromOrigin(r)
+		if err != nil {
+			localConfig.Logger.TraceLog("Unable to fetch from origin, using the last fetched remote branches: " + err.Error())
+		}
+	}
+
+	now := time.Now()
+	d, err := collectDigest(r, now.Add(-age), now, staleAge)
+	if err != nil {
+		return err
+	}
+
+	var content string
+	switch format {
+	case "markdown":
+		content = formatDigestMarkdown(d)
+	case "html":
+		var buf bytes.Buffer
+		err = goldmark.Convert([]byte(formatDigestMarkdown(d)), &buf)
+		if err != nil {
+			return err
+		}
+		content = buf.String()
+	case "text":
+		content = formatDigestText(d)
+	}
+
+	if !send {
+		fmt.Print(content)
+		return nil
+	}
+
+	err = notifier.Send(notify.DIGEST, getDigestTitle(d), content, format == "html")
+	if err != nil {
+		return err
+	}
+	fmt.Println("Sent the digest of " + describeDigestPeriod(d))
+	return nil
+}
+
+// collectDigest works out what happened to the RFDs between since and until.
+func collectDigest(r *git.Repository, since time.Time, until time.Time, staleAge time.Duration) (*digest, error) {
+
+	d := &digest{Since: since, Until: until}
+
+	for _, found := range collectRFDs(r) {
+
+		changes, err := getStateChanges(r, found)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, change := range changes {
+			if change.Time.Before(since) || change.Time.After(until) {
+				continue
+			}
+			entry := newDigestEntry(found, change.Time)
+			entry.By = change.Author
+			if change.From == "" {
+				d.Created = append(d.Created, entry)
+			} else {
+				entry.From = change.From
+				entry.State = change.To
+				d.Changes = append(d.Changes, entry)
+			}
+		}
+
+		entered := getStateEntered(found, changes)
+		if entered.IsZero() {
+			continue
+		}
+
+		if found.State() == DISCUSSION_STATE {
+			entry := newDigestEntry(found, entered)
+			entry.Detail = describeApprovals(r, found)
+			d.Waiting = append(d.Waiting, entry)
+		}
+
+		threshold, err := getStaleThreshold(found.State(), staleAge)
+		if err != nil {
+			return nil, err
+		}
+		if threshold > 0 && until.Sub(entered) > threshold {
+			entry := newDigestEntry(found, entered)
+			entry.Detail = formatAge(until.Sub(entered))
+			d.Stale = append(d.Stale, entry)
+		}
+	}
+
+	for _, entries := range [][]digestEntry{d.Created, d.Changes, d.Waiting, d.Stale} {
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].Time.Before(entries[j].Time)
+		})
+	}
+
+	return d, nil
+}
+
+func newDigestEntry(found *rfd, at time.Time) digestEntry {
+	return digestEntry{
+		ID:      found.ID,
+		Title:   found.Title(),
+		Authors: found.Authors(),
+		State:   found.State(),
+		Time:    at,
+	}
+}
+
+// getStaleThreshold returns how long an RFD can be in a state before it's stale, or 0 if it can be there
+// for as long as it likes. That's the state's max-age in states.yml, or staleAge for drafts and RFDs in
+// discussion if they haven't one.
+func getStaleThreshold(state string, staleAge time.Duration) (time.Duration, error) {
+
+	maxAge, err := getStateAge(state, MAX_AGE_SETTING)
+	if err != nil || maxAge > 0 {
+		return maxAge, err
+	}
+	if state == getDefaultStatus() || state == DISCUSSION_STATE {
+		return staleAge, nil
+	}
+	return 0, nil
+}
+
+func getDigestTitle(d *digest) string {
+	return "RFD digest, " + describeDigestPeriod(d)
+}
+
+func describeDigestPeriod(d *digest) string {
+	return d.Since.Format("2 January 2006") + " to " + d.Until.Format("2 January 2006")
+}
+
+func formatDigestMarkdown(d *digest) string {
+
+	var builder strings.Builder
+	builder.WriteString("# " + getDigestTitle(d) + "\n")
+
+	writeSection := func(heading string, entries []digestEntry, describe func(entry digestEntry) string) {
+		builder.WriteString("\n## " + heading + "\n\n")
+		if len(entries) == 0 {
+			builder.WriteString("None.\n")
+			return
+		}
+		for _, entry := range entries {
+			builder.WriteString("* **RFD " + entry.ID + ": " + entry.Title + "** " + describe(entry) + "\n")
+		}
+	}
+
+	writeDigestSections(d, writeSection)
+	return builder.String()
+}
+
+func formatDigestText(d *digest) string {
+
+	var builder strings.Builder
+	title := getDigestTitle(d)
+	builder.WriteString(title + "\n" + strings.Repeat("=", len(title)) + "\n")
+
+	writeSection := func(heading string, entries []digestEntry, describe func(entry digestEntry) string) {
+		builder.WriteString("\n" + heading + "\n" + strings.Repeat("-", len(heading)) + "\n")
+		if len(entries) == 0 {
+			builder.WriteString("None.\n")
+			return
+		}
+		for _, entry := range entries {
+			builder.WriteString("  " + entry.ID + "  " + entry.Title + " " + describe(entry) + "\n")
+		}
+	}
+
+	writeDigestSections(d, writeSection)
+	return builder.String()
+}
+
+// writeDigestSections writes each section of a digest, describing each entry in the same words whatever
+// the format.
+func writeDigestSections(d *digest, writeSection func(heading string, entries []digestEntry, describe func(entry digestEntry) string)) {
+
+	writeSection("New RFDs", d.Created, func(entry digestEntry) string {
+		return "by " + entry.Authors + ", created " + formatDigestDate(entry.Time)
+	})
+	writeSection("State changes", d.Changes, func(entry digestEntry) string {
+		return "moved from " + entry.From + " to " + entry.State + " by " + entry.By + ", " + formatDigestDate(entry.Time)
+	})
+	writeSection("Waiting on review", d.Waiting, func(entry digestEntry) string {
+		description := "by " + entry.Authors + ", in discussion since " + formatDigestDate(entry.Time)
+		if entry.Detail != "" {
+			description += ", approvals: " + entry.Detail
+		}
+		return description
+	})
+	writeSection("Stale", d.Stale, func(entry digestEntry) string {
+		return "by " + entry.Authors + ", in " + entry.State + " for " + entry.Detail
+	})
+}
+
+func formatDigestDate(at time.Time) string {
+	return at.Format("2 January")
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	localConfig "github.com/redazzo/rfd/cmd/rfd/internal/config"
+	"github.com/redazzo/rfd/cmd/rfd/internal/forge"
+	"os"
+)
+
+/*
+
+Opening an RFD for discussion. When a forge is configured, moving an RFD to the discussion state:
+
+1. Opens a pull request to merge the RFD's branch into the trunk, or finds the one already open.
+2. Writes the pull request's URL into the RFD's discussion: field, and commits and pushes that change.
+
+RFDs that already link to a discussion are left alone.
+
+*/
+
+const DISCUSSION_STATE = "discussion"
+
+// getForge returns the configured forge, or nil if there isn't one.
+func getForge() (forge.Forge, error) {
+
+	forgeConfig := localConfig.APP_CONFIG.Forge
+	if forgeConfig.Provider == "" {
+		return nil, nil
+	}
+
+	tokenEnv := forgeConfig.TokenEnv
+	if tokenEnv == "" {
+		tokenEnv = localConfig.DEFAULT_FORGE_TOKEN_ENV
+	}
+	token := os.Getenv(tokenEnv)
+	if token == "" {
+		return nil, fmt.Errorf("no access token for the forge, set %s", tokenEnv)
+	}
+
+	return forge.New(forgeConfig.Provider, forgeConfig.URL, forgeConfig.Repository, token)
+}
+
+// openDiscussion opens a pull request for an RFD, and links the RFD to it.
+func openDiscussion(r *git.Repository, rfdId string, author *object.Signature) error {
+
+	f, err := getForge()
+	if err != nil || f == nil {
+		return err
+	}
+
+	queued, err := readPushQueue()
+	if err != nil {
+		return err
+	}
+	if containsString(queued, rfdId) {
+		return fmt.Errorf("branch %s hasn't been pushed yet. Run 'rfd sync', then open the pull request by hand and add it to the discussion: field", rfdId)
+	}
+
+	commit := getCommit(r, plumbing.NewBranchReferenceName(rfdId))
+	if commit == nil {
+		return fmt.Errorf("branch %s wasn't found", rfdId)
+	}
+	found := readRFDFromCommit(commit, rfdId, rfdId)
+	if found == nil {
+		return fmt.Errorf("RFD %s has no readme on its branch", rfdId)
+	}
+	if metadataString(found.Metadata, "discussion") != "" {
+		localConfig.Logger.TraceLog("RFD " + rfdId + " already links to " + metadataString(found.Metadata, "discussion"))
+		return nil
+	}
+
+	pull, err := f.OpenPullRequest(rfdId, getTrunkBranchName(r), "RFD "+rfdId+": "+found.Title(),
+		"This pull request is for the discussion of RFD "+rfdId+", "+found.Title()+".")
+	if err != nil {
+		return err
+	}
+	fmt.Println("Discussion of RFD " + rfdId + " is at " + pull.URL)
+
+	return updateRFD(r, rfdId, func(content []byte) ([]byte, error) {
+		return setFrontMatterField(content, "discussion", pull.URL), nil
+	}, rfdId+": Link discussion", author)
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	localConfig "github.com/redazzo/rfd/cmd/rfd/internal/config"
+	"github.com/urfave/cli/v2"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+/*
+
+Git hooks that check RFDs before they leave this repository:
+
+1. 'rfd hooks install' writes pre-commit and pre-push hooks to the repository's hooks directory, each of
+   which runs 'rfd hook' with its name. Hooks that rfd didn't write are left alone unless --force is given.
+   'rfd hooks uninstall' removes those it wrote.
+2. The pre-commit hook refuses commits to the trunk, and lints the staged copy of every RFD readme in the
+   commit. rfd makes its own commits, including those of 'rfd merge', without running git hooks, so they
+   aren't refused.
+3. The pre-push hook refuses to push an RFD branch without a readme in the directory named after it, or
+   whose readme's id isn't the branch's name.
+
+Either can be skipped, as any git hook can, with --no-verify.
+
+*/
+
+const PRE_COMMIT_GIT_HOOK = "pre-commit"
+const PRE_PUSH_GIT_HOOK = "pre-push"
+const PRE_RECEIVE_GIT_HOOK = "pre-receive"
+
+// GIT_HOOK_MARKER marks the git hooks written by 'rfd hooks install'.
+const GIT_HOOK_MARKER = "# Installed by 'rfd hooks install', remove with 'rfd hooks uninstall'."
+
+var gitHooks = []string{PRE_COMMIT_GIT_HOOK, PRE_PUSH_GIT_HOOK}
+
+// getGitHooksDirectory returns the directory git runs hooks from, honouring core.hooksPath.
+func getGitHooksDirectory(r *git.Repository) (string, error) {
+
+	config, err := r.Config()
+	if err != nil {
+		return "", err
+	}
+
+	hooksPath := config.Raw.Section("core").Option("hooksPath")
+	if hooksPath == "" {
+		return filepath.Join(localConfig.GetGitCommonDirectory(), "hooks"), nil
+	}
+	if strings.HasPrefix(hooksPath, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		hooksPath = filepath.Join(home, hooksPath[2:])
+	}
+	return hooksPath, nil
+}
+
+func installGitHooks(force bool) error {
+
+	r, err := git.PlainOpen(".")
+	localConfig.CheckFatal(err)
+
+	directory, err := getGitHooksDirectory(r)
+	if err != nil {
+		return err
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(directory, 0755)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range gitHooks {
+
+		hookPath := filepath.Join(directory, name)
+		existing, err := os.ReadFile(hookPath)
+		if err == nil && !strings.Contains(string(existing), GIT_HOOK_MARKER) && !force {
+			return fmt.Errorf("there's already a %s hook in %s, use --force to replace it", name, directory)
+		}
+
+		script := "#!/bin/sh\n" + GIT_HOOK_MARKER + "\nexec '" + strings.ReplaceAll(executable, "'", `'\''`) + "' hook " + name + " \"$@\"\n"
+		err = os.WriteFile(hookPath, []byte(script), 0755)
+		if err != nil {
+			return err
+		}
+		fmt.Println("Installed the " + name + " hook in " + directory)
+	}
+
+	return nil
+}
+
+func uninstallGitHooks() error {
+
+	r, err := git.PlainOpen(".")
+	localConfig.CheckFatal(err)
+
+	directory, err := getGitHooksDirectory(r)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range gitHooks {
+
+		hookPath := filepath.Join(directory, name)
+		existing, err := os.ReadFile(hookPath)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !strings.Contains(string(existing), GIT_HOOK_MARKER) {
+			fmt.Println("Leaving the " + name + " hook in " + directory + ", it wasn't installed by rfd")
+			continue
+		}
+
+		err = os.Remove(hookPath)
+		if err != nil {
+			return err
+		}
+		fmt.Println("Removed the " + name + " hook from " + directory)
+	}
+
+	return nil
+}
+
+// openGitHookRepository opens the repository a git hook is run in. That may be a linked worktree, such as
+// those 'rfd new --worktree' checks out, whose objects and refs are kept in the main repository.
+func openGitHookRepository() *git.Repository {
+
+	r, err := git.PlainOpenWithOptions(".", &git.PlainOpenOptions{
+		DetectDotGit:          true,
+		EnableDotGitCommonDir: true,
+	})
+	localConfig.CheckFatal(err)
+	return r
+}
+
+// runPreCommitHook checks a commit about to be made, returning whether it should be refused.
+func runPreCommitHook() (bool, error) {
+
+	r := openGitHookRepository()
+
+	head, err := r.Reference(plumbing.HEAD, false)
+	if err != nil {
+		return false, err
+	}
+	trunkName := getTrunkBranchName(r)
+	if head.Type() == plumbing.SymbolicReference && head.Target().Short() == trunkName {
+		fmt.Println("Refusing to commit to " + trunkName + ". Change RFDs on their branches, and merge them with 'rfd merge'.")
+		return true, nil
+	}
+
+	staging, err := readStagedIndex(r)
+	if err != nil {
+		return false, err
+	}
+	staged := make(map[string]plumbing.Hash)
+	for _, entry := range staging.Entries {
+		staged[entry.Name] = entry.Hash
+	}
+
+	// The files of the commit being built on, so that only the readmes changed since are linted
+	committed := make(map[string]plumbing.Hash)
+	if headCommit := getCommit(r, plumbing.HEAD); headCommit != nil {
+		tree, err := headCommit.Tree()
+		if err != nil {
+			return false, err
+		}
+		err = tree.Files().ForEach(func(file *object.File) error {
+			committed[file.Name] = file.Hash
+			return nil
+		})
+		if err != nil {
+			return false, err
+		}
+	}
+
+	knownIds := make(map[string]bool)
+	for _, found := range collectRFDs(r) {
+		knownIds[found.ID] = true
+	}
+	resolves := newLinkChecker(func(target string) bool {
+		if _, ok := staged[target]; ok {
+			return true
+		}
+		// A directory is staged if anything under it is
+		for name := range staged {
+			if strings.HasPrefix(name, target+"/") {
+				return true
+			}
+		}
+		return false
+	}, knownIds)
+
+	var problems []lintProblem
+	for _, entry := range staging.Entries {
+
+		if hash, ok := committed[entry.Name]; ok && hash == entry.Hash {
+			continue
+		}
+		rfdId, isReadme := getReadmeRFDId(entry.Name)
+		if !isReadme {
+			continue
+		}
+
+		blob, err := r.BlobObject(entry.Hash)
+		if err != nil {
+			return false, err
+		}
+		reader, err := blob.Reader()
+		if err != nil {
+			return false, err
+		}
+		content, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			return false, err
+		}
+
+		problems = append(problems, lintReadme(rfdId, entry.Name, content, resolves)...)
+	}
+
+	if countErrors(problems) == 0 {
+		return false, writeProblems(problems, lintRules, "text")
+	}
+
+	fmt.Println("Refusing to commit, the staged RFDs have problems:")
+	err = writeProblems(problems, lintRules, "text")
+	fmt.Println("Fix them, or commit with --no-verify to skip these checks.")
+	return true, err
+}
+
+// readStagedIndex reads the index a commit is being made from. That's usually .git/index, but 'git commit'
+// with paths or -a stages to a temporary index, which it passes to hooks in GIT_INDEX_FILE.
+func readStagedIndex(r *git.Repository) (*index.Index, error) {
+
+	location := os.Getenv("GIT_INDEX_FILE")
+	if location == "" {
+		return r.Storer.Index()
+	}
+
+	file, err := os.Open(location)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	staged := &index.Index{}
+	err = index.NewDecoder(bufio.NewReader(file)).Decode(staged)
+	return staged, err
+}
+
+// runPrePushHook checks the refs about to be pushed, read from git as lines of
+// <local ref> <local hash> <remote ref> <remote hash>, returning whether the push should be refused.
+func runPrePushHook(input io.Reader) (bool, error) {
+
+	r := openGitHookRepository()
+
+	refused := false
+	scanner := bufio.NewScanner(input)
+	for scanner.Scan() {
+
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 4 || fields[1] == plumbing.ZeroHash.String() {
+			continue
+		}
+
+		remoteRef := plumbing.ReferenceName(fields[2])
+		if !remoteRef.IsBranch() {
+			continue
+		}
+		branch := remoteRef.Short()
+		isRFDBranch, err := localConfig.IsRFDIDFormat(branch)
+		localConfig.CheckFatal(err)
+		if !isRFDBranch {
+			continue
+		}
+
+		commit, err := r.CommitObject(plumbing.NewHash(fields[1]))
+		if err != nil {
+			return false, err
+		}
+		found := readRFDFromCommit(commit, branch, branch)
+		if found == nil {
+			fmt.Print("Refusing to push " + branch + ", it has no " + branch + "/readme.md")
+			if added := getAddedRFDs(commit, getPushedOnto(r, fields[3])); len(added) > 0 {
+				fmt.Print(", but adds " + strings.Join(added, ", ") + "/readme.md. An RFD's branch is named after its id")
+			}
+			fmt.Println()
+			refused = true
+			continue
+		}
+
+		id := readFrontMatterField(found.Content, "id")
+		if id != branch {
+			fmt.Printf("Refusing to push %s, the id in %s is %q rather than %s\n", branch, found.Path, id, branch)
+			refused = true
+		}
+	}
+
+	return refused, scanner.Err()
+}
+
+// getPushedOnto returns the commit a push is made on top of: the remote's commit if it's known, or the
+// trunk if the branch is new.
+func getPushedOnto(r *git.Repository, remoteHash string) *object.Commit {
+
+	if remoteHash != plumbing.ZeroHash.String() {
+		commit, err := r.CommitObject(plumbing.NewHash(remoteHash))
+		if err == nil {
+			return commit
+		}
+	}
+
+	trunkName := getTrunkBranchName(r)
+	trunk := getCommit(r, plumbing.NewBranchReferenceName(trunkName))
+	if trunk == nil {
+		trunk = getCommit(r, plumbing.NewRemoteReferenceName("origin", trunkName))
+	}
+	return trunk
+}
+
+// getReadmeRFDId returns the id of the RFD whose readme is at a path from the root of the repository, and
+// whether it's an RFD readme at all.
+func getReadmeRFDId(name string) (string, bool) {
+
+	directory, file := path.Split(name)
+	directory = strings.TrimSuffix(directory, "/")
+	if directory == "" || strings.Contains(directory, "/") || !readmePattern.MatchString(file) {
+		return "", false
+	}
+
+	isRFDDirectory, err := localConfig.IsRFDIDFormat(directory)
+	localConfig.CheckFatal(err)
+	return directory, isRFDDirectory
+}
+
+// getGitHookExit returns how 'rfd hook' should exit, so that git refuses the commit or push if it was
+// refused, or couldn't be checked.
+func getGitHookExit(refused bool, err error) error {
+	if err != nil {
+		return cli.Exit(err.Error(), 2)
+	}
+	if refused {
+		return cli.Exit("", 1)
+	}
+	return nil
+}
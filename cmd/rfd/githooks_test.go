@@ -0,0 +1,83 @@
+package main
+
+import (
+	localConfig "github.com/redazzo/rfd/cmd/rfd/internal/config"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+const hookedReadme = "---\nid: 0002\ntitle: Hooked\nauthors: Alice\nstate: draft\n---\n\n# RFD-0002\n"
+
+func TestPreCommitHookInLinkedWorktree(t *testing.T) {
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git isn't installed")
+	}
+
+	localConfig.APP_CONFIG = &localConfig.Configuration{
+		Lint: localConfig.Lint{Headings: map[string][]string{DEFAULT_LINT_KIND: {"Purpose"}}},
+	}
+	localConfig.APP_STATES = &localConfig.States{RFDStates: []map[string]map[string]string{
+		{"state": {"id": "1", "name": "draft"}},
+	}}
+
+	// As if run by 'git commit' without paths, which uses the worktree's own index
+	os.Unsetenv("GIT_INDEX_FILE")
+
+	repository := t.TempDir()
+	git := func(directory string, args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = directory
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=Alice", "GIT_AUTHOR_EMAIL=alice@example.com",
+			"GIT_COMMITTER_NAME=Alice", "GIT_COMMITTER_EMAIL=alice@example.com")
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, output)
+		}
+	}
+	writeReadme := func(directory string, content string) {
+		err := os.MkdirAll(filepath.Join(directory, "0002"), 0755)
+		if err == nil {
+			err = os.WriteFile(filepath.Join(directory, "0002", "readme.md"), []byte(content), 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	git(repository, "init", "-q")
+	git(repository, "checkout", "-q", "-b", "main")
+	writeReadme(repository, hookedReadme+"\n## Purpose\n\nTo be hooked.\n")
+	git(repository, "add", "-A")
+	git(repository, "commit", "-q", "-m", "RFD 0002")
+	git(repository, "branch", "0002")
+	git(repository, "worktree", "add", "-q", filepath.Join(".rfd-worktrees", "0002"), "0002")
+
+	worktree := filepath.Join(repository, ".rfd-worktrees", "0002")
+	directory, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(worktree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(directory)
+
+	// The staged readme has lost its Purpose, so the commit is refused
+	writeReadme(worktree, hookedReadme)
+	git(worktree, "add", "-A")
+	refused, err := runPreCommitHook()
+	if err != nil || !refused {
+		t.Fatalf("expected the commit to be refused, got refused %v, error %v", refused, err)
+	}
+
+	writeReadme(worktree, hookedReadme+"\n## Purpose\n\nTo be hooked from a worktree.\n")
+	git(worktree, "add", "-A")
+	refused, err = runPreCommitHook()
+	if err != nil || refused {
+		t.Fatalf("expected the commit to be allowed, got refused %v, error %v", refused, err)
+	}
+	git(worktree, "commit", "-q", "--no-verify", "-m", "Edit RFD 0002")
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	localConfig "github.com/redazzo/rfd/cmd/rfd/internal/config"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+/*
+
+The graph of the relations between RFDs, as 'rfd graph' draws it:
+
+1. Collect every RFD, merged or not, and an edge for each relation in their metadata. superseded-by is
+   drawn as the supersedes edge it's the other side of, and related RFDs are joined once.
+2. Look for cycles in depends-on across all the RFDs, which are drawn in red and make 'rfd graph' exit
+   with 1.
+3. Keep the RFDs matching a filter, as 'rfd list' takes, and with --subtree only the given RFD and those
+   under it: its children, the RFDs it depends on, and theirs in turn.
+4. Write the graph as Graphviz dot, Mermaid or JSON, with each RFD coloured by its state. A state's colour
+   can be set in states.yml with colour:.
+
+A Mermaid graph can be embedded in a markdown file with --embed, between <!-- rfd graph --> and
+<!-- /rfd graph --> markers, replacing the graph already there. 'rfd index' keeps a graph embedded in
+index.md as it is, so it's updated by running 'rfd graph --embed index.md' again.
+
+*/
+
+const COLOUR_SETTING = "colour"
+
+const GRAPH_SECTION_START = "<!-- rfd graph -->"
+const GRAPH_SECTION_END = "<!-- /rfd graph -->"
+
+const CYCLE_COLOUR = "#cf222e"
+
+// stateColours are the colours of the states that don't set one in states.yml, in the order they're
+// configured.
+var stateColours = []string{"#eaeef2", "#ddf4ff", "#dafbe1", "#fff8c5", "#ffebe9", "#fbefff", "#fff1e5", "#d8f3f0"}
+
+var graphIdentifierPattern = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+type graphNode struct {
+	ID     string `json:"id"`
+	Title  string `json:"title"`
+	State  string `json:"state"`
+	Colour string `json:"colour"`
+	Branch string `json:"branch"`
+	Merged bool   `json:"merged"`
+}
+
+type graphEdge struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Type  string `json:"type"`
+	Cycle bool   `json:"cycle,omitempty"`
+}
+
+type rfdGraph struct {
+	Nodes  []graphNode `json:"nodes"`
+	Edges  []graphEdge `json:"edges"`
+	Cycles [][]string  `json:"cycles"`
+}
+
+// graphRFDs writes the graph of the RFDs matching a filter expression, and under subtree if it's given, to
+// stdout or embeds it in a markdown file. It returns the number of cycles found in depends-on.
+func graphRFDs(expression string, subtree string, format string, embed string) (int, error) {
+
+	if format != "dot" && format != "mermaid" && format != "json" {
+		return 0, fmt.Errorf("unknown format %q, expected dot, mermaid or json", format)
+	}
+	if embed != "" && format != "mermaid" {
+		return 0, fmt.Errorf("only mermaid graphs can be embedded in markdown")
+	}
+
+	matches, err := parseFilter(expression)
+	if err != nil {
+		return 0, err
+	}
+
+	r, err := git.PlainOpen(".")
+	localConfig.CheckFatal(err)
+
+	graph, err := buildGraph(collectRFDs(r), matches, subtree)
+	if err != nil {
+		return 0, err
+	}
+
+	var output bytes.Buffer
+	switch format {
+	case "dot":
+		writeDotGraph(&output, graph)
+	case "mermaid":
+		writeMermaidGraph(&output, graph)
+	case "json":
+		encoder := json.NewEncoder(&output)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(graph)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if embed == "" {
+		_, err = os.Stdout.Write(output.Bytes())
+	} else {
+		err = embedGraph(embed, output.String())
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	for _, cycle := range graph.Cycles {
+		fmt.Fprintln(os.Stderr, "depends-on cycle: "+strings.Join(cycle, " -> "))
+	}
+	return len(graph.Cycles), nil
+}
+
+// buildGraph returns the graph of the RFDs matching a filter, limited to those under subtree if it isn't "".
+func buildGraph(rfds []*rfd, matches filter, subtree string) (*rfdGraph, error) {
+
+	byId := make(map[string]*rfd)
+	for _, found := range rfds {
+		byId[found.ID] = found
+	}
+	if subtree != "" && byId[subtree] == nil {
+		return nil, fmt.Errorf("RFD %s wasn't found locally, on the remote, or on the trunk", subtree)
+	}
+
+	var edges []graphEdge
+	seen := make(map[graphEdge]bool)
+	for _, found := range rfds {
+		for _, related := range getRelations(found.Content) {
+
+			edge := graphEdge{From: found.ID, To: related.ID, Type: related.Type}
+			switch related.Type {
+			case SUPERSEDED_BY_RELATION:
+				edge = graphEdge{From: related.ID, To: found.ID, Type: SUPERSEDES_RELATION}
+			case RELATED_RELATION:
+				if edge.From > edge.To {
+					edge.From, edge.To = edge.To, edge.From
+				}
+			}
+
+			if byId[edge.To] == nil || edge.From == edge.To || seen[edge] {
+				continue
+			}
+			seen[edge] = true
+			edges = append(edges, edge)
+		}
+	}
+
+	graph := &rfdGraph{Nodes: []graphNode{}, Edges: []graphEdge{}, Cycles: findDependencyCycles(edges)}
+
+	// Every edge around a cycle is marked as part of it
+	onCycle := make(map[graphEdge]bool)
+	for _, cycle := range graph.Cycles {
+		for i := 0; i < len(cycle)-1; i++ {
+			onCycle[graphEdge{From: cycle[i], To: cycle[i+1], Type: DEPENDS_ON_RELATION}] = true
+		}
+	}
+
+	var under map[string]bool
+	if subtree != "" {
+		under = getSubtree(subtree, edges)
+	}
+
+	kept := make(map[string]bool)
+	for _, found := range rfds {
+		if (under != nil && !under[found.ID]) || !matches.Matches(getRFDFields(found)) {
+			continue
+		}
+		kept[found.ID] = true
+		graph.Nodes = append(graph.Nodes, graphNode{
+			ID:     found.ID,
+			Title:  found.Title(),
+			State:  found.State(),
+			Colour: getStateColour(found.State()),
+			Branch: found.Branch,
+			Merged: found.Merged,
+		})
+	}
+
+	for _, edge := range edges {
+		if kept[edge.From] && kept[edge.To] {
+			edge.Cycle = onCycle[edge]
+			graph.Edges = append(graph.Edges, edge)
+		}
+	}
+
+	return graph, nil
+}
+
+// getSubtree returns the ids of an RFD and those under it, following its children and what it depends on.
+func getSubtree(root string, edges []graphEdge) map[string]bool {
+
+	below := make(map[string][]string)
+	for _, edge := range edges {
+		switch edge.Type {
+		case PARENT_RELATION:
+			below[edge.To] = append(below[edge.To], edge.From)
+		case DEPENDS_ON_RELATION:
+			below[edge.From] = append(below[edge.From], edge.To)
+		}
+	}
+
+	under := map[string]bool{root: true}
+	queue := []string{root}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, next := range below[id] {
+			if !under[next] {
+				under[next] = true
+				queue = append(queue, next)
+			}
+		}
+	}
+	return under
+}
+
+// findDependencyCycles returns the cycles in the depends-on edges, each as the ids around it starting and
+// ending with the same RFD.
+func findDependencyCycles(edges []graphEdge) [][]string {
+
+	dependencies := make(map[string][]string)
+	for _, edge := range edges {
+		if edge.Type == DEPENDS_ON_RELATION {
+			dependencies[edge.From] = append(dependencies[edge.From], edge.To)
+		}
+	}
+	var ids []string
+	for id := range dependencies {
+		ids = append(ids, id)
+		sort.Strings(dependencies[id])
+	}
+	sort.Strings(ids)
+
+	const visiting, visited = 1, 2
+	marks := make(map[string]int)
+	var path []string
+	var cycles [][]string
+
+	var visit func(id string)
+	visit = func(id string) {
+		marks[id] = visiting
+		path = append(path, id)
+		for _, next := range dependencies[id] {
+			switch marks[next] {
+			case visiting:
+				// Back to an RFD still being followed, so everything on the path since it is a cycle
+				for i := range path {
+					if path[i] == next {
+						cycle := append([]string{}, path[i:]...)
+						cycles = append(cycles, append(cycle, next))
+						break
+					}
+				}
+			case 0:
+				visit(next)
+			}
+		}
+		path = path[:len(path)-1]
+		marks[id] = visited
+	}
+
+	for _, id := range ids {
+		if marks[id] == 0 {
+			visit(id)
+		}
+	}
+	return cycles
+}
+
+// getStateColour returns the colour set for a state in states.yml, or a default by the state's position.
+func getStateColour(state string) string {
+
+	if colour := getStateSetting(state, COLOUR_SETTING); colour != "" {
+		return colour
+	}
+	for i, name := range getStateNames() {
+		if name == state {
+			return stateColours[i%len(stateColours)]
+		}
+	}
+	return "#ffffff"
+}
+
+func writeDotGraph(output *bytes.Buffer, graph *rfdGraph) {
+
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", " ").Replace
+	quote := func(value string) string {
+		return `"` + escape(value) + `"`
+	}
+
+	output.WriteString("digraph rfds {\n")
+	output.WriteString("  rankdir=LR;\n")
+	output.WriteString("  node [shape=box, style=\"rounded,filled\"];\n")
+	for _, node := range graph.Nodes {
+		label := `"` + escape(node.ID+": "+node.Title) + `\n` + escape(node.State) + `"`
+		fmt.Fprintf(output, "  %s [label=%s, fillcolor=%s];\n", quote(node.ID), label, quote(node.Colour))
+	}
+	for _, edge := range graph.Edges {
+		attributes := []string{"label=" + quote(edge.Type)}
+		if edge.Type == RELATED_RELATION {
+			attributes = append(attributes, "dir=none", "style=dashed")
+		}
+		if edge.Cycle {
+			attributes = append(attributes, "color="+quote(CYCLE_COLOUR), "fontcolor="+quote(CYCLE_COLOUR), "penwidth=2")
+		}
+		fmt.Fprintf(output, "  %s -> %s [%s];\n", quote(edge.From), quote(edge.To), strings.Join(attributes, ", "))
+	}
+	output.WriteString("}\n")
+}
+
+// writeMermaidGraph writes the graph as a fenced Mermaid block, which renders as a diagram in markdown.
+func writeMermaidGraph(output *bytes.Buffer, graph *rfdGraph) {
+
+	label := func(value string) string {
+		return strings.NewReplacer(`"`, "#quot;", "\n", " ").Replace(value)
+	}
+	class := func(state string) string {
+		return "state_" + graphIdentifierPattern.ReplaceAllString(state, "_")
+	}
+
+	output.WriteString("```mermaid\n")
+	output.WriteString("graph LR\n")
+
+	states := make(map[string]string)
+	var stateNames []string
+	for _, node := range graph.Nodes {
+		fmt.Fprintf(output, "  rfd%s[\"%s: %s<br/>%s\"]\n", node.ID, node.ID, label(node.Title), label(node.State))
+		if _, ok := states[node.State]; !ok {
+			states[node.State] = node.Colour
+			stateNames = append(stateNames, node.State)
+		}
+	}
+
+	var cycleLinks []string
+	for i, edge := range graph.Edges {
+		arrow := "-->"
+		if edge.Type == RELATED_RELATION {
+			arrow = "-.-"
+		}
+		fmt.Fprintf(output, "  rfd%s %s|%s| rfd%s\n", edge.From, arrow, edge.Type, edge.To)
+		if edge.Cycle {
+			cycleLinks = append(cycleLinks, fmt.Sprint(i))
+		}
+	}
+
+	sort.Strings(stateNames)
+	for _, state := range stateNames {
+		fmt.Fprintf(output, "  classDef %s fill:%s,stroke:#57606a,color:#24292f\n", class(state), states[state])
+		var ids []string
+		for _, node := range graph.Nodes {
+			if node.State == state {
+				ids = append(ids, "rfd"+node.ID)
+			}
+		}
+		fmt.Fprintf(output, "  class %s %s\n", strings.Join(ids, ","), class(state))
+	}
+	if len(cycleLinks) > 0 {
+		fmt.Fprintf(output, "  linkStyle %s stroke:%s,stroke-width:2px\n", strings.Join(cycleLinks, ","), CYCLE_COLOUR)
+	}
+
+	output.WriteString("```\n")
+}
+
+// embedGraph writes a graph into a markdown file between the graph markers, adding them to the end of the
+// file if they aren't there yet.
+func embedGraph(location string, graph string) error {
+
+	content, err := os.ReadFile(location)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	section := GRAPH_SECTION_START + "\n" + graph + GRAPH_SECTION_END
+	existing := getEmbeddedGraph(content)
+	if existing != "" {
+		content = []byte(strings.Replace(string(content), existing, section, 1))
+	} else {
+		if len(content) > 0 && !bytes.HasSuffix(content, []byte("\n")) {
+			content = append(content, '\n')
+		}
+		if len(content) > 0 {
+			content = append(content, '\n')
+		}
+		content = append(content, []byte(section+"\n")...)
+	}
+
+	err = os.WriteFile(location, content, 0644)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Embedded the graph in " + location)
+	return nil
+}
+
+// getEmbeddedGraph returns the graph embedded in markdown, including its markers, or "" if there isn't one.
+func getEmbeddedGraph(content []byte) string {
+
+	start := bytes.Index(content, []byte(GRAPH_SECTION_START))
+	if start < 0 {
+		return ""
+	}
+	end := bytes.Index(content[start:], []byte(GRAPH_SECTION_END))
+	if end < 0 {
+		return ""
+	}
+	return string(content[start : start+end+len(GRAPH_SECTION_END)])
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindDependencyCycles(t *testing.T) {
+
+	edges := []graphEdge{
+		{From: "0001", To: "0002", Type: DEPENDS_ON_RELATION},
+		{From: "0002", To: "0003", Type: DEPENDS_ON_RELATION},
+		{From: "0003", To: "0001", Type: DEPENDS_ON_RELATION},
+		{From: "0003", To: "0004", Type: DEPENDS_ON_RELATION},
+		{From: "0004", To: "0002", Type: PARENT_RELATION},
+		{From: "0005", To: "0006", Type: DEPENDS_ON_RELATION},
+	}
+
+	expected := [][]string{{"0001", "0002", "0003", "0001"}}
+	if cycles := findDependencyCycles(edges); !reflect.DeepEqual(cycles, expected) {
+		t.Errorf("expected %v, got %v", expected, cycles)
+	}
+
+	if cycles := findDependencyCycles(edges[3:]); cycles != nil {
+		t.Errorf("expected no cycles, got %v", cycles)
+	}
+}
+
+func TestGetSubtree(t *testing.T) {
+
+	edges := []graphEdge{
+		{From: "0002", To: "0001", Type: PARENT_RELATION},
+		{From: "0002", To: "0003", Type: DEPENDS_ON_RELATION},
+		{From: "0004", To: "0002", Type: PARENT_RELATION},
+		{From: "0005", To: "0001", Type: DEPENDS_ON_RELATION},
+		{From: "0001", To: "0006", Type: RELATED_RELATION},
+	}
+
+	expected := map[string]bool{"0001": true, "0002": true, "0003": true, "0004": true}
+	if under := getSubtree("0001", edges); !reflect.DeepEqual(under, expected) {
+		t.Errorf("expected %v, got %v", expected, under)
+	}
+}
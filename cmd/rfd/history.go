@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+
+Working out from git history when RFDs were created and changed state, for digests of what's happened and
+to find RFDs that have been in a state for too long:
+
+1. Walk the commits that changed the RFD's readme, from the commit it was read from.
+2. Read the state from the readme in each commit, and in each of its parents. A commit whose parents don't
+   have the readme created the RFD. A commit whose state isn't that of any of its parents changed it.
+   Merges of the RFD's branch leave its state as it was, and so don't count as changes.
+
+*/
+
+// stateChange is an RFD entering a state. From is "" for the RFD's creation.
+type stateChange struct {
+	From   string
+	To     string
+	Time   time.Time
+	Author string
+}
+
+// getStateChanges returns the changes of state of an RFD, oldest first.
+func getStateChanges(r *git.Repository, found *rfd) ([]stateChange, error) {
+
+	if found.Commit == nil {
+		return nil, nil
+	}
+
+	// Path filtering in go-git passes over the root commit, so the readme is compared with its parents'
+	// copies here instead
+	commits, err := r.Log(&git.LogOptions{From: found.Commit.Hash})
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []stateChange
+	err = commits.ForEach(func(commit *object.Commit) error {
+
+		hash, ok := getFileHash(commit, found.Path)
+		if !ok {
+			return nil
+		}
+
+		var parentStates []string
+		unchanged := false
+		err := commit.Parents().ForEach(func(parent *object.Commit) error {
+			parentHash, ok := getFileHash(parent, found.Path)
+			if ok {
+				unchanged = unchanged || parentHash == hash
+				parentStates = append(parentStates, metadataString(parseMetadata(readFileFromCommit(parent, found.Path)), "state"))
+			}
+			return nil
+		})
+		if err != nil || unchanged {
+			return err
+		}
+
+		state := metadataString(parseMetadata(readFileFromCommit(commit, found.Path)), "state")
+		if containsString(parentStates, state) {
+			return nil
+		}
+
+		change := stateChange{
+			To:     state,
+			Time:   commit.Committer.When,
+			Author: commit.Author.Name,
+		}
+		if len(parentStates) > 0 {
+			change.From = parentStates[0]
+		}
+		changes = append(changes, change)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The log is newest first
+	for i, j := 0, len(changes)-1; i < j; i, j = i+1, j-1 {
+		changes[i], changes[j] = changes[j], changes[i]
+	}
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].Time.Before(changes[j].Time)
+	})
+	return changes, nil
+}
+
+// getFileHash returns the hash of a file in a commit, and whether the file is there.
+func getFileHash(commit *object.Commit, path string) (plumbing.Hash, bool) {
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return plumbing.ZeroHash, false
+	}
+	entry, err := tree.FindEntry(path)
+	if err != nil {
+		return plumbing.ZeroHash, false
+	}
+	return entry.Hash, true
+}
+
+// getStateEntered returns when an RFD entered the state it's in, or the zero time if that can't be told
+// from its history.
+func getStateEntered(found *rfd, changes []stateChange) time.Time {
+
+	for i := len(changes) - 1; i >= 0; i-- {
+		if changes[i].To == found.State() {
+			return changes[i].Time
+		}
+	}
+	return time.Time{}
+}
+
+// parseAge parses a length of time such as 7d, 2w or 36h.
+func parseAge(age string) (time.Duration, error) {
+
+	age = strings.TrimSpace(age)
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if strings.HasSuffix(age, suffix) {
+			count, err := strconv.Atoi(strings.TrimSuffix(age, suffix))
+			if err != nil || count < 0 {
+				return 0, fmt.Errorf("%q isn't a length of time, expected e.g. 7d, 2w or 36h", age)
+			}
+			return time.Duration(count) * unit, nil
+		}
+	}
+
+	duration, err := time.ParseDuration(age)
+	if err != nil || duration < 0 {
+		return 0, fmt.Errorf("%q isn't a length of time, expected e.g. 7d, 2w or 36h", age)
+	}
+	return duration, nil
+}
+
+// formatAge describes a length of time in days, or hours or minutes if it's less than a day.
+func formatAge(age time.Duration) string {
+
+	days := int(age.Hours() / 24)
+	if days == 1 {
+		return "1 day"
+	}
+	if days > 1 {
+		return strconv.Itoa(days) + " days"
+	}
+	if age >= time.Hour {
+		return strconv.Itoa(int(age.Hours())) + " hours"
+	}
+	return strconv.Itoa(int(age.Minutes())) + " minutes"
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	localConfig "github.com/redazzo/rfd/cmd/rfd/internal/config"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+/*
+
+Lifecycle hooks, run around the commands that change an RFD:
+
+1. Look for an executable named after the hook in .rfd/hooks, at the root of the repository. There's
+   nothing to do if there isn't one.
+2. Run it from the root of the repository, with the RFD's metadata as a JSON object on its stdin, and the
+   RFD's id, title, state and branch, and the user making the change, as RFD_ environment variables.
+   Transitions also set RFD_FROM_STATE and RFD_TO_STATE.
+3. A pre- hook that exits with a non-zero status aborts the change. A post- hook runs once the change has
+   been committed, so its failure is only reported.
+
+*/
+
+const HOOKS_DIRECTORY = ".rfd" + localConfig.PATH_SEPARATOR + "hooks"
+
+const PRE_NEW_HOOK = "pre-new"
+const POST_NEW_HOOK = "post-new"
+const PRE_TRANSITION_HOOK = "pre-transition"
+const POST_TRANSITION_HOOK = "post-transition"
+const POST_MERGE_HOOK = "post-merge"
+
+// runHook runs a hook for an RFD, described by fields as getRFDFields returns them. author is the user
+// making the change, or nil for the configured git user. env adds to the environment variables set.
+func runHook(r *git.Repository, name string, fields map[string]interface{}, author *object.Signature, env map[string]string) error {
+
+	path := filepath.Join(HOOKS_DIRECTORY, name)
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() {
+		return nil
+	}
+	if info.Mode()&0111 == 0 {
+		localConfig.Logger.TraceLog("Skipping the " + name + " hook, it isn't executable")
+		return nil
+	}
+
+	input, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+
+	environment := map[string]string{
+		"RFD_HOOK":    name,
+		"RFD_ID":      metadataString(fields, "id"),
+		"RFD_TITLE":   metadataString(fields, "title"),
+		"RFD_STATE":   metadataString(fields, "state"),
+		"RFD_AUTHORS": metadataString(fields, "authors"),
+		"RFD_BRANCH":  metadataString(fields, "branch"),
+	}
+	if author == nil {
+		author, _ = getSignature(r)
+	}
+	if author != nil {
+		environment["RFD_USER_NAME"] = author.Name
+		environment["RFD_USER_EMAIL"] = author.Email
+	}
+	for key, value := range env {
+		environment[key] = value
+	}
+
+	localConfig.Logger.TraceLog("Running the " + name + " hook ...")
+
+	cmd := exec.Command(path)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = os.Environ()
+	for key, value := range environment {
+		cmd.Env = append(cmd.Env, key+"="+value)
+	}
+
+	err = cmd.Run()
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("the %s hook failed: %v", name, err)
+}
+
+// runPostHook runs a post- hook, reporting rather than returning its failure.
+func runPostHook(r *git.Repository, name string, fields map[string]interface{}, author *object.Signature, env map[string]string) {
+
+	if fields == nil {
+		return
+	}
+
+	err := runHook(r, name, fields, author, env)
+	if err != nil {
+		fmt.Println(err.Error())
+	}
+}
+
+// getNewRFDFields returns the fields of an RFD that's about to be created, for the pre-new hook.
+func getNewRFDFields(rfdId string, title string, authors string, state string) map[string]interface{} {
+	return map[string]interface{}{
+		"id":      rfdId,
+		"title":   title,
+		"authors": authors,
+		"state":   state,
+		"branch":  rfdId,
+		"merged":  false,
+	}
+}
+
+// getBranchRFDFields returns the fields of an RFD as it is on its branch, or nil if it can't be found.
+func getBranchRFDFields(r *git.Repository, rfdId string) map[string]interface{} {
+
+	commit, err := findCommit(r, plumbing.NewBranchReferenceName(rfdId))
+	if err != nil || commit == nil {
+		return nil
+	}
+	found := readRFDFromCommit(commit, rfdId, rfdId)
+	if found == nil {
+		return nil
+	}
+	return getRFDFields(found)
+}
+
+// getTransitionEnvironment returns the environment variables set for the transition hooks.
+func getTransitionEnvironment(from string, to string) map[string]string {
+	return map[string]string{
+		"RFD_FROM_STATE": from,
+		"RFD_TO_STATE":   to,
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRunHook(t *testing.T) {
+
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("there's no shell to run hooks with")
+	}
+
+	directory, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := t.TempDir()
+	err = os.Chdir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(directory)
+
+	err = os.MkdirAll(HOOKS_DIRECTORY, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeHook := func(name string, script string, mode os.FileMode) {
+		err := os.WriteFile(filepath.Join(HOOKS_DIRECTORY, name), []byte("#!/bin/sh\n"+script), mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Hooks run from the root of the repository, so record what they're given there
+	writeHook(PRE_TRANSITION_HOOK, "cat > input.json\nenv | grep '^RFD_' | sort > environment\n", 0755)
+	writeHook(PRE_NEW_HOOK, "echo \"RFD $RFD_ID needs a discussion link\"\nexit 1\n", 0755)
+	writeHook(POST_MERGE_HOOK, "touch merged\nexit 1\n", 0644)
+
+	fields := map[string]interface{}{
+		"id":      "0002",
+		"title":   "Hooked",
+		"authors": "Alice <alice@example.com>",
+		"state":   "discussion",
+		"branch":  "0002",
+		"merged":  false,
+	}
+	author := &object.Signature{Name: "Bob", Email: "bob@example.com"}
+
+	tests := []struct {
+		name    string
+		hook    string
+		env     map[string]string
+		failing bool
+	}{
+		{"runs an executable hook", PRE_TRANSITION_HOOK, getTransitionEnvironment("discussion", "accepted"), false},
+		{"aborts on a failing pre- hook", PRE_NEW_HOOK, nil, true},
+		{"skips a hook that isn't executable", POST_MERGE_HOOK, nil, false},
+		{"does nothing without a hook", POST_NEW_HOOK, nil, false},
+	}
+
+	for _, test := range tests {
+		err := runHook(nil, test.hook, fields, author, test.env)
+		if (err != nil) != test.failing {
+			t.Errorf("%s: expected failing to be %v, got %v", test.name, test.failing, err)
+		}
+	}
+
+	input, err := os.ReadFile("input.json")
+	if err != nil {
+		t.Fatalf("Expected the %s hook to have run: %v", PRE_TRANSITION_HOOK, err)
+	}
+	var received map[string]interface{}
+	err = json.Unmarshal(input, &received)
+	if err != nil {
+		t.Fatalf("Expected the metadata as JSON on stdin, got %q: %v", input, err)
+	}
+	if !reflect.DeepEqual(received, fields) {
+		t.Errorf("Expected %v on stdin, got %v", fields, received)
+	}
+
+	environment, err := os.ReadFile("environment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"RFD_AUTHORS=Alice <alice@example.com>",
+		"RFD_BRANCH=0002",
+		"RFD_FROM_STATE=discussion",
+		"RFD_HOOK=" + PRE_TRANSITION_HOOK,
+		"RFD_ID=0002",
+		"RFD_STATE=discussion",
+		"RFD_TITLE=Hooked",
+		"RFD_TO_STATE=accepted",
+		"RFD_USER_EMAIL=bob@example.com",
+		"RFD_USER_NAME=Bob",
+	}
+	if lines := strings.Split(strings.TrimSpace(string(environment)), "\n"); !reflect.DeepEqual(lines, expected) {
+		t.Errorf("Expected the environment %v, got %v", expected, lines)
+	}
+
+	if _, err := os.Stat("merged"); err == nil {
+		t.Errorf("Expected the %s hook not to run, as it isn't executable", POST_MERGE_HOOK)
+	}
+}
@@ -3,10 +3,14 @@ package main
 import (
 	"bytes"
 	"fmt"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/redazzo/rfd/cmd/rfd/internal/config"
+	"io"
 	"io/ioutil"
 	"os"
 	"regexp"
+	"strings"
 
 	"github.com/yuin/goldmark"
 	meta "github.com/yuin/goldmark-meta"
@@ -20,6 +24,9 @@ func Index() error {
 	// The entries to be read and written to the
 	// Markdown table file.
 
+	// A graph embedded by 'rfd graph --embed' is kept
+	existing, _ := os.ReadFile(config.APP_CONFIG.RootDirectory + "/index.md")
+
 	// The file that will be updated with the
 	// rfd entries and their status.
 	mdTableFile := openMetadataTableFile()
@@ -52,9 +59,9 @@ func Index() error {
 
 						if isReadmeFile {
 
-							metaData := readMetadataFromReadmeFile(subEntry, entry)
+							content := readReadmeFile(subEntry, entry)
 
-							writeMetadataToTableFile(metaData, mdTableFile, branchID)
+							writeMetadataToTableFile(parseMetadata(content), getRelations(content), mdTableFile, branchID)
 
 						}
 
@@ -67,6 +74,8 @@ func Index() error {
 
 	}
 
+	writeEmbeddedGraph(existing, mdTableFile)
+
 	return nil
 }
 
@@ -76,42 +85,117 @@ func getDirectories() []os.FileInfo {
 	return entries
 }
 
+// IndexFromTree renders the index of the RFDs in the root of a tree, as Index() would for the same
+// RFDs in the working tree.
+func IndexFromTree(tree *object.Tree) []byte {
+
+	index, err := indexFromTree(tree)
+	config.CheckFatal(err)
+
+	return index
+}
+
+// indexFromTree renders the index as IndexFromTree does, returning an error rather than exiting if the
+// tree can't be read.
+func indexFromTree(tree *object.Tree) ([]byte, error) {
+
+	var mdTable bytes.Buffer
+	writeMetadataTableHeader(&mdTable)
+
+	for _, entry := range tree.Entries {
+
+		entryIsBranchID, err := config.IsRFDIDFormat(entry.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		if !entryIsBranchID || entry.Mode != filemode.Dir {
+			continue
+		}
+
+		subTree, err := tree.Tree(entry.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, subEntry := range subTree.Entries {
+
+			isReadmeFile, err := regexp.MatchString(`(?i)^readme.md`, subEntry.Name)
+			if err != nil {
+				return nil, err
+			}
+
+			if isReadmeFile && subEntry.Mode.IsFile() {
+				content := readFileFromTree(tree, entry.Name+"/"+subEntry.Name)
+				writeMetadataToTableFile(parseMetadata(content), getRelations(content), &mdTable, entry.Name)
+			}
+		}
+	}
+
+	writeEmbeddedGraph(readFileFromTree(tree, "index.md"), &mdTable)
+
+	return mdTable.Bytes(), nil
+}
+
 func openMetadataTableFile() *os.File {
 	mdTableFile, err := os.Create(config.APP_CONFIG.RootDirectory + "/index.md")
 	config.CheckFatal(err)
 
-	_, err = mdTableFile.WriteString("**Index of Requests for Discussion**\n\n")
+	writeMetadataTableHeader(mdTableFile)
+	return mdTableFile
+}
+
+func writeMetadataTableHeader(mdTableFile io.Writer) {
+	_, err := io.WriteString(mdTableFile, "**Index of Requests for Discussion**\n\n")
 	config.CheckFatal(err)
-	_, err = mdTableFile.WriteString("| **RFD Id** | **Title** | **State** | **Author(s)** |\n")
+	_, err = io.WriteString(mdTableFile, "| **RFD Id** | **Title** | **State** | **Author(s)** | **Relations** |\n")
 	config.CheckFatal(err)
-	_, err = mdTableFile.WriteString("|------------|-----------|-----------|------------------------|\n")
+	_, err = io.WriteString(mdTableFile, "|------------|-----------|-----------|------------------------|---------------|\n")
 	config.CheckFatal(err)
-	return mdTableFile
 }
 
-func writeMetadataToTableFile(metaData map[string]interface{}, mdTableFile *os.File, branchID string) {
+func writeMetadataToTableFile(metaData map[string]interface{}, relations []relation, mdTableFile io.Writer, branchID string) {
 	title := fmt.Sprintf("%v", metaData["title"])
 	authors := fmt.Sprintf("%v", metaData["authors"])
 	state := fmt.Sprintf("%v", metaData["state"])
 
 	config.Logger.TraceLog(title + ":" + authors + ":" + state)
 
-	_, err := mdTableFile.WriteString("|[" + branchID + "](./" + branchID + "/readme.md)|" + title + "|" + state + "|" + authors + "|\n")
+	var related []string
+	for _, rel := range relations {
+		related = append(related, strings.ReplaceAll(rel.Type, "-", " ")+" ["+rel.ID+"](./"+rel.ID+"/readme.md)")
+	}
+
+	_, err := io.WriteString(mdTableFile, "|["+branchID+"](./"+branchID+"/readme.md)|"+title+"|"+state+"|"+authors+"|"+strings.Join(related, ", ")+"|\n")
 	config.CheckFatal(err)
 
 	config.Logger.TraceLog("recorded: " + branchID)
 	config.Logger.TraceLog("----------------------------------------------")
 }
 
-func readMetadataFromReadmeFile(subEntry os.FileInfo, entry os.FileInfo) map[string]interface{} {
+// writeEmbeddedGraph writes the graph embedded in the previous index, if there was one, after the table.
+func writeEmbeddedGraph(previous []byte, mdTableFile io.Writer) {
+	if graph := getEmbeddedGraph(previous); graph != "" {
+		_, err := io.WriteString(mdTableFile, "\n"+graph+"\n")
+		config.CheckFatal(err)
+	}
+}
+
+func readReadmeFile(subEntry os.FileInfo, entry os.FileInfo) []byte {
 	config.Logger.TraceLog("Found " + config.APP_CONFIG.RootDirectory + "/" + entry.Name() + "/" + subEntry.Name())
+	file, err := os.ReadFile(config.APP_CONFIG.RootDirectory + "/" + entry.Name() + "/" + subEntry.Name())
+	config.CheckFatal(err)
+
+	return file
+}
+
+// parseMetadata returns the metadata header of the content of an RFD readme.
+func parseMetadata(file []byte) map[string]interface{} {
 	markdown := goldmark.New(
 		goldmark.WithExtensions(
 			meta.Meta,
 		),
 	)
-	file, err := os.ReadFile(config.APP_CONFIG.RootDirectory + "/" + entry.Name() + "/" + subEntry.Name())
-	config.CheckFatal(err)
 
 	var buf bytes.Buffer
 	context := parser.NewContext()
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"bytes"
 	"fmt"
 	"github.com/go-git/go-git/v5"
 	"gopkg.in/yaml.v3"
@@ -98,7 +99,7 @@ func CheckAndReportOnRepositoryState() bool {
 	CheckFatal(err)
 
 	w, err := r.Worktree()
-	status, err := w.Status()
+	status, err := GetWorktreeStatus(w)
 
 	fmt.Println()
 	if status.IsClean() {
@@ -378,15 +379,11 @@ func CreateReadme(metadata *RFDMetadata, tmplate string) (error, *os.File) {
 
 	Logger.TraceLog("Template:" + tmplate)
 
-	bTemplate, err := os.ReadFile(tmplate)
-	CheckFatal(err)
-	sTemplate := string(bTemplate)
-	tmpl, err := template.New("test").Parse(sTemplate)
-	CheckFatal(err)
+	tmpl := parseReadmeTemplate(tmplate)
 
 	// Create local directory
 
-	err = os.Mkdir(GetRFDDirectory(metadata.RFDID), 0755)
+	err := os.Mkdir(GetRFDDirectory(metadata.RFDID), 0755)
 	CheckFatal(err)
 
 	// Write out new readme.md to nnnn/readme.md
@@ -399,6 +396,37 @@ func CreateReadme(metadata *RFDMetadata, tmplate string) (error, *os.File) {
 	return err, fReadme
 }
 
+// RenderReadme renders an RFD's readme.md from a template, without writing it to the RFD directory.
+func RenderReadme(metadata *RFDMetadata, tmplate string) ([]byte, error) {
+
+	tmpl, err := readReadmeTemplate(tmplate)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, metadata)
+	return buf.Bytes(), err
+}
+
+func parseReadmeTemplate(tmplate string) *template.Template {
+
+	tmpl, err := readReadmeTemplate(tmplate)
+	CheckFatal(err)
+
+	return tmpl
+}
+
+func readReadmeTemplate(tmplate string) (*template.Template, error) {
+
+	bTemplate, err := os.ReadFile(tmplate)
+	if err != nil {
+		return nil, err
+	}
+
+	return template.New("test").Parse(string(bTemplate))
+}
+
 func printCancelled() {
 	println("Operation cancelled.")
 }
@@ -15,14 +15,85 @@ func Configure() {
 }
 
 type Configuration struct {
-	RootDirectory      string `yaml:"root-directory"`
-	TemplatesDirectory string `yaml:"templates-directory"`
-	PrivateKeyFileName string `yaml:"private-key-file-name"`
-	InitialAuthor      string `yaml:"initial-author"`
-	Organisation       string `yaml:"organisation"`
-	InstigationDate    string `yaml:"instigation-date"`
+	RootDirectory      string        `yaml:"root-directory"`
+	TemplatesDirectory string        `yaml:"templates-directory"`
+	PrivateKeyFileName string        `yaml:"private-key-file-name"`
+	InitialAuthor      string        `yaml:"initial-author"`
+	Organisation       string        `yaml:"organisation"`
+	InstigationDate    string        `yaml:"instigation-date"`
+	Offline            bool          `yaml:"offline"`
+	WebIdentityHeader  string        `yaml:"web-identity-header"`
+	WebEmailHeader     string        `yaml:"web-email-header"`
+	WebUsersFile       string        `yaml:"web-users-file"`
+	SupersededState    string        `yaml:"superseded-state"`
+	Forge              Forge         `yaml:"forge"`
+	Approvals          Approvals     `yaml:"approvals"`
+	Notifications      Notifications `yaml:"notifications"`
+	Lint               Lint          `yaml:"lint"`
+	CI                 CI            `yaml:"ci"`
 }
 
+// Forge is the forge hosting the repository, on which pull requests are opened for discussion.
+type Forge struct {
+	Provider   string `yaml:"provider"`
+	URL        string `yaml:"url"`
+	Repository string `yaml:"repository"`
+	TokenEnv   string `yaml:"token-env"`
+}
+
+const DEFAULT_FORGE_TOKEN_ENV = "RFD_FORGE_TOKEN"
+
+// Approvals says who may approve RFDs, and how many approvals an RFD needs before it can be accepted.
+// Approvers are given by name or email, or as @group for each member of one of the groups. With a keyring
+// of the approvers' armored OpenPGP public keys, approvals only count if they're signed by the approver.
+type Approvals struct {
+	Required  int                 `yaml:"required"`
+	Approvers []string            `yaml:"approvers"`
+	Groups    map[string][]string `yaml:"groups"`
+	Keyring   string              `yaml:"keyring"`
+}
+
+// Notifications are the channels told when RFDs are created, change state, are merged or go stale, and how
+// many times a failed delivery is retried.
+type Notifications struct {
+	Retries  int                   `yaml:"retries"`
+	Channels []NotificationChannel `yaml:"channels"`
+}
+
+// NotificationChannel is a webhook, Slack or Mattermost incoming webhook, or email address to notify. The
+// SMTP password is read from the environment variable named by password-env, and to-authors also emails
+// the authors of the RFD.
+type NotificationChannel struct {
+	Name        string            `yaml:"name"`
+	Type        string            `yaml:"type"`
+	URL         string            `yaml:"url"`
+	Events      []string          `yaml:"events"`
+	Templates   map[string]string `yaml:"templates"`
+	Subject     string            `yaml:"subject"`
+	SMTPHost    string            `yaml:"smtp-host"`
+	SMTPPort    int               `yaml:"smtp-port"`
+	Username    string            `yaml:"username"`
+	PasswordEnv string            `yaml:"password-env"`
+	From        string            `yaml:"from"`
+	To          []string          `yaml:"to"`
+	ToAuthors   bool              `yaml:"to-authors"`
+}
+
+// Lint is what 'rfd lint' expects of an RFD: the metadata fields it must have, and the headings it must
+// have by its kind: field, with those under default for RFDs without one.
+type Lint struct {
+	RequiredFields []string            `yaml:"required-fields"`
+	Headings       map[string][]string `yaml:"headings"`
+}
+
+// CI is what 'rfd ci verify' allows an RFD's pull request to change besides the RFD's directory and the
+// index, as paths or globs from the root of the repository. A path ending in / allows everything under it.
+type CI struct {
+	AllowedPaths []string `yaml:"allowed-paths"`
+}
+
+var DEFAULT_REQUIRED_FIELDS = []string{"id", "title", "authors", "state"}
+
 func (c *Configuration) Get001ReadmeFileLocation() string {
 	return c.TemplatesDirectory + PATH_SEPARATOR + "0001" + PATH_SEPARATOR + "readme.md"
 }
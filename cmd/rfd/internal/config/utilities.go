@@ -4,6 +4,9 @@ import (
 	"bufio"
 	"fmt"
 	"github.com/go-git/go-git/v5"
+	gitConfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
 	"io"
 	"net/http"
 	"path/filepath"
@@ -80,6 +83,13 @@ func GetUserInput(txt string) string {
 	return responseTxt
 }
 
+// GetUserConfirmation asks a yes/no question, defaulting to no.
+func GetUserConfirmation(txt string) bool {
+
+	response := strings.ToUpper(strings.TrimSpace(GetUserInput(txt + " (y/N)?")))
+	return response == "Y" || response == "YES"
+}
+
 func CopyToRoot(source string, target string, force bool) {
 
 	bytesRead, err := ioutil.ReadFile(source)
@@ -117,6 +127,98 @@ func PushToOrigin(r *git.Repository) error {
 	return err
 }
 
+// PushBranchToOrigin pushes a single branch to origin. Unlike PushToOrigin, the push isn't forced,
+// and failures are returned rather than being fatal so that the push can be retried later.
+func PushBranchToOrigin(r *git.Repository, branch string) error {
+
+	publicKey, err := GetPublicKey()
+
+	refSpec := gitConfig.RefSpec("refs/heads/" + branch + ":refs/heads/" + branch)
+
+	err = r.Push(&git.PushOptions{
+		RemoteName: "origin",
+		RefSpecs:   []gitConfig.RefSpec{refSpec},
+		Auth:       publicKey,
+	})
+	if err == git.NoErrAlreadyUpToDate {
+		return nil
+	}
+
+	return err
+}
+
+// FetchFromOrigin updates the remote-tracking branches from origin.
+func FetchFromOrigin(r *git.Repository) error {
+
+	publicKey, err := GetPublicKey()
+
+	// go-git fails to update remote-tracking branches that only exist in packed-refs (as they do after a
+	// clone), reporting that they have changed concurrently. Writing them out as loose references first
+	// avoids this.
+	refs, err := r.References()
+	if err != nil {
+		return err
+	}
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Name().IsRemote() && ref.Type() == plumbing.HashReference {
+			return r.Storer.SetReference(ref)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = r.Fetch(&git.FetchOptions{
+		RemoteName: "origin",
+		Auth:       publicKey,
+	})
+	if err == git.NoErrAlreadyUpToDate {
+		return nil
+	}
+
+	return err
+}
+
+// WORKTREES_DIRECTORY is where RFD branches are checked out into linked worktrees.
+const WORKTREES_DIRECTORY string = ".rfd-worktrees"
+
+// GetWorktreeStatus returns the status of a worktree, leaving out the linked worktrees checked out
+// under WORKTREES_DIRECTORY. They're excluded in .git/info/exclude, which go-git doesn't read.
+func GetWorktreeStatus(w *git.Worktree) (git.Status, error) {
+	w.Excludes = append(w.Excludes, gitignore.ParsePattern("/"+WORKTREES_DIRECTORY+"/", nil))
+	return w.Status()
+}
+
+// GetRFDStateDirectory returns the directory where rfd keeps state that is local to this clone,
+// such as pushes queued while offline. It's shared by the clone's linked worktrees.
+func GetRFDStateDirectory() string {
+	return GetGitCommonDirectory() + PATH_SEPARATOR + "rfd"
+}
+
+// GetGitCommonDirectory returns the git directory shared by the repository's worktrees. That's .git,
+// unless this is a linked worktree, where .git is a file pointing to the worktree's own git directory,
+// whose commondir file points to the shared one.
+func GetGitCommonDirectory() string {
+
+	content, err := os.ReadFile(".git")
+	if err != nil || !strings.HasPrefix(string(content), "gitdir:") {
+		return ".git"
+	}
+
+	gitDirectory := strings.TrimSpace(strings.TrimPrefix(string(content), "gitdir:"))
+	commonDirectory, err := os.ReadFile(filepath.Join(gitDirectory, "commondir"))
+	if err != nil {
+		return filepath.Clean(gitDirectory)
+	}
+
+	common := strings.TrimSpace(string(commonDirectory))
+	if !filepath.IsAbs(common) {
+		common = filepath.Join(gitDirectory, common)
+	}
+	return filepath.Clean(common)
+}
+
 func GetRFDDirectory(sRfdNumber string) string {
 	return APP_CONFIG.RootDirectory + "/" + sRfdNumber
 }
@@ -12,7 +12,7 @@ func TestWriteTemplates(t *testing.T) {
 
 	APP_CONFIG.RootDirectory = "/tmp"
 
-	err := WriteTemplates()
+	_, err := WriteTemplates()
 	if err != nil {
 		t.Errorf("Error writing templates: %s", err)
 	}
@@ -0,0 +1,268 @@
+package forge
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+
+Forges host the pull requests in which RFDs are discussed. Each forge's API is wrapped by a Forge, so the
+rest of the tool can open pull requests without knowing which forge the repository lives on.
+
+*/
+
+const GITHUB = "github"
+const GITLAB = "gitlab"
+const GITEA = "gitea"
+
+// Forge is the API of a forge hosting the RFD repository.
+type Forge interface {
+
+	// OpenPullRequest opens a pull request to merge head into base, or returns the pull request already
+	// open for head.
+	OpenPullRequest(head string, base string, title string, body string) (*PullRequest, error)
+
+	// GetPullRequestStatus returns the state and reviews of the pull request at url.
+	GetPullRequestStatus(url string) (*PullRequestStatus, error)
+
+	// GetDiscussion returns the conversation on the pull request at url, its reviews and the comments made
+	// on lines of its files, oldest first.
+	GetDiscussion(url string) ([]Comment, error)
+}
+
+// PullRequest is a pull request, or merge request, on a forge.
+type PullRequest struct {
+	Number int
+	URL    string
+	Head   string
+	Base   string
+}
+
+const OPEN = "open"
+const CLOSED = "closed"
+const MERGED = "merged"
+
+// PullRequestStatus is where a pull request has got to: whether it's open, closed or merged, who has
+// approved it or asked for changes, and how much it's been commented on.
+type PullRequestStatus struct {
+	PullRequest
+	State            string
+	ApprovedBy       []string
+	ChangesRequested []string
+	Comments         int
+}
+
+const APPROVED = "approved"
+const CHANGES_REQUESTED = "changes requested"
+
+// Comment is something said on a pull request: a comment on the conversation, a review, or a comment on
+// a line of a file.
+type Comment struct {
+	Author  string
+	Body    string
+	Created time.Time
+
+	// Verdict is the APPROVED or CHANGES_REQUESTED of a review, if it gave one
+	Verdict string
+
+	// Path and Line are the file and line commented on, if the comment was made on a line
+	Path string
+	Line int
+}
+
+// New returns the Forge for a provider, talking to the API at baseURL on behalf of the owner of token.
+// The repository is given as owner/name, or for GitLab as the project's full path.
+func New(provider string, baseURL string, repository string, token string) (Forge, error) {
+
+	if repository == "" {
+		return nil, fmt.Errorf("the forge repository isn't configured")
+	}
+
+	switch strings.ToLower(provider) {
+	case GITHUB:
+		if baseURL == "" {
+			baseURL = "https://api.github.com"
+		}
+		return &gitHub{client: newClient(baseURL, "Authorization", "Bearer "+token), repository: repository}, nil
+	case GITLAB:
+		if baseURL == "" {
+			baseURL = "https://gitlab.com"
+		}
+		return &gitLab{client: newClient(baseURL+"/api/v4", "PRIVATE-TOKEN", token), repository: repository}, nil
+	case GITEA:
+		if baseURL == "" {
+			return nil, fmt.Errorf("the URL of the Gitea server isn't configured")
+		}
+		return &gitea{client: newClient(baseURL+"/api/v1", "Authorization", "token "+token), repository: repository}, nil
+	}
+
+	return nil, fmt.Errorf("unknown forge provider %q, expected %s, %s or %s", provider, GITHUB, GITLAB, GITEA)
+}
+
+// client makes JSON requests of a forge's API.
+type client struct {
+	baseURL    string
+	authHeader string
+	authValue  string
+	httpClient *http.Client
+}
+
+func newClient(baseURL string, authHeader string, authValue string) *client {
+	return &client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		authHeader: authHeader,
+		authValue:  authValue,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// do sends a request with an optional JSON body, decoding the JSON response into result if it isn't nil.
+func (c *client) do(method string, path string, body interface{}, result interface{}) error {
+
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequest(method, c.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.authValue != "" {
+		req.Header.Set(c.authHeader, c.authValue)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(content)))
+	}
+
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(content, result)
+}
+
+// getAll gets every page of a list, pageSize items at a time, decoding the items into result, which must
+// point to a slice. limitParameter names the query parameter giving the page size.
+func (c *client) getAll(path string, limitParameter string, pageSize int, result interface{}) error {
+
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+
+	var items []json.RawMessage
+	for page := 1; ; page++ {
+
+		var pageItems []json.RawMessage
+		err := c.do("GET", path+separator+limitParameter+"="+strconv.Itoa(pageSize)+"&page="+strconv.Itoa(page), nil, &pageItems)
+		if err != nil {
+			return err
+		}
+		items = append(items, pageItems...)
+
+		if len(pageItems) < pageSize {
+			break
+		}
+	}
+
+	encoded, err := json.Marshal(items)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(encoded, result)
+}
+
+// sortComments puts comments in the order they were made.
+func sortComments(comments []Comment) {
+	sort.SliceStable(comments, func(i, j int) bool {
+		return comments[i].Created.Before(comments[j].Created)
+	})
+}
+
+// getPullRequestNumber returns the number of the pull request at url, checking that it belongs to the
+// repository.
+func getPullRequestNumber(url string, repository string) (int, error) {
+
+	path := url
+	if schemeEnd := strings.Index(path, "://"); schemeEnd >= 0 {
+		path = path[schemeEnd+3:]
+	}
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+
+	if !strings.Contains("/"+path+"/", "/"+repository+"/") || len(segments) < 2 {
+		return 0, fmt.Errorf("%s isn't a pull request of %s", url, repository)
+	}
+
+	number, err := strconv.Atoi(segments[len(segments)-1])
+	if err != nil || number <= 0 {
+		return 0, fmt.Errorf("%s isn't a pull request of %s", url, repository)
+	}
+
+	return number, nil
+}
+
+// latestReviews reduces reviews, oldest first, to the latest verdict of each reviewer, returning those
+// who approve and those who've asked for changes.
+func latestReviews(reviewers []string, verdicts []string, approved string, changesRequested string) ([]string, []string) {
+
+	latest := make(map[string]string)
+	var order []string
+	for i, reviewer := range reviewers {
+		if _, seen := latest[reviewer]; !seen {
+			order = append(order, reviewer)
+		}
+		switch verdicts[i] {
+		case approved, changesRequested, "DISMISSED":
+			latest[reviewer] = verdicts[i]
+		}
+	}
+
+	var approvedBy, changesRequestedBy []string
+	for _, reviewer := range order {
+		switch latest[reviewer] {
+		case approved:
+			approvedBy = append(approvedBy, reviewer)
+		case changesRequested:
+			changesRequestedBy = append(changesRequestedBy, reviewer)
+		}
+	}
+
+	return approvedBy, changesRequestedBy
+}
+
+// splitRepository splits owner/name.
+func splitRepository(repository string) (string, string, error) {
+	parts := strings.Split(repository, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("expected the repository as owner/name, found %q", repository)
+	}
+	return parts[0], parts[1], nil
+}
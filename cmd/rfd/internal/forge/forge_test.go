@@ -0,0 +1,326 @@
+package forge
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeForge stands in for a forge's API, serving canned responses by method and path, and recording the
+// requests made of it.
+type fakeForge struct {
+	t          *testing.T
+	authHeader string
+	authValue  string
+	responses  map[string]string
+	requests   []string
+	bodies     []map[string]string
+}
+
+func (f *fakeForge) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+
+	if req.Header.Get(f.authHeader) != f.authValue {
+		f.t.Errorf("Expected %s: %s, found %q", f.authHeader, f.authValue, req.Header.Get(f.authHeader))
+	}
+
+	key := req.Method + " " + req.URL.RequestURI()
+	f.requests = append(f.requests, key)
+
+	if req.Method == "POST" {
+		body := make(map[string]string)
+		err := json.NewDecoder(req.Body).Decode(&body)
+		if err != nil {
+			f.t.Errorf("Error decoding request body: %s", err)
+		}
+		f.bodies = append(f.bodies, body)
+	}
+
+	response, ok := f.responses[key]
+	if !ok {
+		http.Error(w, `{"message": "Not Found"}`, http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if req.Method == "POST" {
+		w.WriteHeader(http.StatusCreated)
+	}
+	w.Write([]byte(response))
+}
+
+func TestGitHubOpenPullRequest(t *testing.T) {
+
+	fake := &fakeForge{t: t, authHeader: "Authorization", authValue: "Bearer secret", responses: map[string]string{
+		"GET /repos/acme/rfd/pulls?state=open&head=acme%3A0042": `[]`,
+		"POST /repos/acme/rfd/pulls":                            `{"number": 7, "html_url": "https://github.com/acme/rfd/pull/7", "head": {"ref": "0042"}, "base": {"ref": "main"}}`,
+	}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	forge, err := New(GITHUB, server.URL, "acme/rfd", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pull, err := forge.OpenPullRequest("0042", "main", "RFD 0042: Storage", "Discuss storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pull.Number != 7 || pull.URL != "https://github.com/acme/rfd/pull/7" {
+		t.Errorf("Unexpected pull request %+v", pull)
+	}
+	if len(fake.bodies) != 1 || fake.bodies[0]["head"] != "0042" || fake.bodies[0]["base"] != "main" || fake.bodies[0]["title"] != "RFD 0042: Storage" {
+		t.Errorf("Unexpected request bodies %v", fake.bodies)
+	}
+}
+
+func TestGitHubReturnsOpenPullRequest(t *testing.T) {
+
+	fake := &fakeForge{t: t, authHeader: "Authorization", authValue: "Bearer secret", responses: map[string]string{
+		"GET /repos/acme/rfd/pulls?state=open&head=acme%3A0042": `[{"number": 3, "html_url": "https://github.com/acme/rfd/pull/3", "head": {"ref": "0042"}, "base": {"ref": "main"}}]`,
+	}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	forge, _ := New(GITHUB, server.URL, "acme/rfd", "secret")
+
+	pull, err := forge.OpenPullRequest("0042", "main", "RFD 0042: Storage", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pull.Number != 3 || len(fake.bodies) != 0 {
+		t.Errorf("Expected the open pull request to be returned, found %+v after %v", pull, fake.requests)
+	}
+}
+
+func TestGitLabOpenPullRequest(t *testing.T) {
+
+	fake := &fakeForge{t: t, authHeader: "PRIVATE-TOKEN", authValue: "secret", responses: map[string]string{
+		"GET /api/v4/projects/acme%2Fteam%2Frfd/merge_requests?state=opened&source_branch=0042": `[]`,
+		"POST /api/v4/projects/acme%2Fteam%2Frfd/merge_requests":                                `{"iid": 12, "web_url": "https://gitlab.com/acme/team/rfd/-/merge_requests/12", "source_branch": "0042", "target_branch": "main"}`,
+	}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	forge, err := New(GITLAB, server.URL, "acme/team/rfd", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pull, err := forge.OpenPullRequest("0042", "main", "RFD 0042: Storage", "Discuss storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pull.Number != 12 || pull.URL != "https://gitlab.com/acme/team/rfd/-/merge_requests/12" {
+		t.Errorf("Unexpected merge request %+v", pull)
+	}
+	if len(fake.bodies) != 1 || fake.bodies[0]["source_branch"] != "0042" || fake.bodies[0]["target_branch"] != "main" || fake.bodies[0]["description"] != "Discuss storage" {
+		t.Errorf("Unexpected request bodies %v", fake.bodies)
+	}
+}
+
+func TestGiteaOpenPullRequest(t *testing.T) {
+
+	fake := &fakeForge{t: t, authHeader: "Authorization", authValue: "token secret", responses: map[string]string{
+		"GET /api/v1/repos/acme/rfd/pulls?state=open&limit=50&page=1": `[{"number": 1, "html_url": "https://gitea.example.com/acme/rfd/pulls/1", "head": {"ref": "0041"}, "base": {"ref": "main"}}]`,
+		"POST /api/v1/repos/acme/rfd/pulls":                           `{"number": 2, "html_url": "https://gitea.example.com/acme/rfd/pulls/2", "head": {"ref": "0042"}, "base": {"ref": "main"}}`,
+	}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	forge, err := New(GITEA, server.URL, "acme/rfd", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pull, err := forge.OpenPullRequest("0042", "main", "RFD 0042: Storage", "Discuss storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pull.Number != 2 || pull.URL != "https://gitea.example.com/acme/rfd/pulls/2" {
+		t.Errorf("Unexpected pull request %+v", pull)
+	}
+}
+
+func TestForgeErrors(t *testing.T) {
+
+	fake := &fakeForge{t: t, authHeader: "Authorization", authValue: "Bearer secret", responses: map[string]string{}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	forge, _ := New(GITHUB, server.URL, "acme/rfd", "secret")
+	if _, err := forge.OpenPullRequest("0042", "main", "RFD 0042: Storage", ""); err == nil {
+		t.Errorf("Expected an error from a failed request")
+	}
+
+	if _, err := New("sourceforge", server.URL, "acme/rfd", "secret"); err == nil {
+		t.Errorf("Expected an error for an unknown provider")
+	}
+	if _, err := New(GITEA, "", "acme/rfd", "secret"); err == nil {
+		t.Errorf("Expected an error for a Gitea forge without a URL")
+	}
+}
+
+func TestGitHubPullRequestStatus(t *testing.T) {
+
+	fake := &fakeForge{t: t, authHeader: "Authorization", authValue: "Bearer secret", responses: map[string]string{
+		"GET /repos/acme/rfd/pulls/7": `{"number": 7, "html_url": "https://github.com/acme/rfd/pull/7", "state": "closed", "merged": false, "comments": 3, "review_comments": 2}`,
+		"GET /repos/acme/rfd/pulls/7/reviews?per_page=100": `[
+			{"user": {"login": "alice"}, "state": "CHANGES_REQUESTED"},
+			{"user": {"login": "bob"}, "state": "APPROVED"},
+			{"user": {"login": "alice"}, "state": "APPROVED"},
+			{"user": {"login": "carol"}, "state": "CHANGES_REQUESTED"},
+			{"user": {"login": "carol"}, "state": "COMMENTED"}
+		]`,
+	}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	forge, _ := New(GITHUB, server.URL, "acme/rfd", "secret")
+
+	status, err := forge.GetPullRequestStatus("https://github.com/acme/rfd/pull/7")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status.State != CLOSED || status.Comments != 5 {
+		t.Errorf("Unexpected status %+v", status)
+	}
+	if strings.Join(status.ApprovedBy, ",") != "alice,bob" || strings.Join(status.ChangesRequested, ",") != "carol" {
+		t.Errorf("Unexpected reviews, approved by %v, changes requested by %v", status.ApprovedBy, status.ChangesRequested)
+	}
+}
+
+func TestGitLabPullRequestStatus(t *testing.T) {
+
+	fake := &fakeForge{t: t, authHeader: "PRIVATE-TOKEN", authValue: "secret", responses: map[string]string{
+		"GET /api/v4/projects/acme%2Frfd/merge_requests/12":           `{"iid": 12, "web_url": "https://gitlab.com/acme/rfd/-/merge_requests/12", "state": "merged", "user_notes_count": 4}`,
+		"GET /api/v4/projects/acme%2Frfd/merge_requests/12/approvals": `{"approved_by": [{"user": {"username": "alice"}}]}`,
+	}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	forge, _ := New(GITLAB, server.URL, "acme/rfd", "secret")
+
+	status, err := forge.GetPullRequestStatus("https://gitlab.com/acme/rfd/-/merge_requests/12")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status.State != MERGED || status.Comments != 4 || strings.Join(status.ApprovedBy, ",") != "alice" {
+		t.Errorf("Unexpected status %+v", status)
+	}
+}
+
+func TestGiteaPullRequestStatus(t *testing.T) {
+
+	fake := &fakeForge{t: t, authHeader: "Authorization", authValue: "token secret", responses: map[string]string{
+		"GET /api/v1/repos/acme/rfd/pulls/2":         `{"number": 2, "html_url": "https://gitea.example.com/acme/rfd/pulls/2", "state": "open", "merged": false, "comments": 1}`,
+		"GET /api/v1/repos/acme/rfd/pulls/2/reviews": `[{"user": {"login": "bob"}, "state": "REQUEST_CHANGES"}]`,
+	}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	forge, _ := New(GITEA, server.URL, "acme/rfd", "secret")
+
+	status, err := forge.GetPullRequestStatus("https://gitea.example.com/acme/rfd/pulls/2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status.State != OPEN || status.Comments != 1 || len(status.ApprovedBy) != 0 || strings.Join(status.ChangesRequested, ",") != "bob" {
+		t.Errorf("Unexpected status %+v", status)
+	}
+}
+
+func TestGetPullRequestNumber(t *testing.T) {
+
+	tests := []struct {
+		url      string
+		expected int
+	}{
+		{"https://github.com/acme/rfd/pull/7", 7},
+		{"https://gitlab.com/acme/team/rfd/-/merge_requests/12", 12},
+		{"https://github.com/acme/other/pull/7", 0},
+		{"https://github.com/acme/rfd/pull/", 0},
+		{"", 0},
+	}
+
+	for _, test := range tests {
+		repository := "acme/rfd"
+		if strings.Contains(test.url, "team") {
+			repository = "acme/team/rfd"
+		}
+		number, err := getPullRequestNumber(test.url, repository)
+		if test.expected == 0 && err == nil {
+			t.Errorf("Expected an error for %q", test.url)
+		}
+		if test.expected != 0 && number != test.expected {
+			t.Errorf("Expected %d for %q, found %d (%v)", test.expected, test.url, number, err)
+		}
+	}
+}
+
+func TestGitHubDiscussion(t *testing.T) {
+
+	fake := &fakeForge{t: t, authHeader: "Authorization", authValue: "Bearer secret", responses: map[string]string{
+		"GET /repos/acme/rfd/issues/7/comments?per_page=100&page=1": `[{"user": {"login": "alice"}, "body": "Ready for review", "created_at": "2026-10-01T09:00:00Z"}]`,
+		"GET /repos/acme/rfd/pulls/7/reviews?per_page=100&page=1": `[
+			{"id": 1, "user": {"login": "bob"}, "state": "COMMENTED", "body": "", "submitted_at": "2026-10-02T10:00:00Z"},
+			{"id": 2, "user": {"login": "bob"}, "state": "APPROVED", "body": "Looks good", "submitted_at": "2026-10-03T10:00:00Z"}
+		]`,
+		"GET /repos/acme/rfd/pulls/7/comments?per_page=100&page=1": `[{"user": {"login": "bob"}, "body": "Why not SQLite?", "created_at": "2026-10-02T10:00:00Z", "path": "0042/readme.md", "line": 12}]`,
+	}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	forge, _ := New(GITHUB, server.URL, "acme/rfd", "secret")
+
+	comments, err := forge.GetDiscussion("https://github.com/acme/rfd/pull/7")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(comments) != 3 {
+		t.Fatalf("Expected 3 comments, found %+v", comments)
+	}
+	if comments[0].Author != "alice" || comments[0].Body != "Ready for review" {
+		t.Errorf("Unexpected first comment %+v", comments[0])
+	}
+	if comments[1].Path != "0042/readme.md" || comments[1].Line != 12 {
+		t.Errorf("Unexpected comment on a line %+v", comments[1])
+	}
+	if comments[2].Verdict != APPROVED || comments[2].Body != "Looks good" {
+		t.Errorf("Unexpected review %+v", comments[2])
+	}
+}
+
+func TestGitLabDiscussion(t *testing.T) {
+
+	fake := &fakeForge{t: t, authHeader: "PRIVATE-TOKEN", authValue: "secret", responses: map[string]string{
+		"GET /api/v4/projects/acme%2Frfd/merge_requests/12/notes?sort=asc&order_by=created_at&per_page=100&page=1": `[
+			{"author": {"username": "alice"}, "body": "added 1 commit", "created_at": "2026-10-01T09:00:00Z", "system": true},
+			{"author": {"username": "bob"}, "body": "Why not SQLite?", "created_at": "2026-10-02T10:00:00Z", "system": false, "position": {"new_path": "0042/readme.md", "new_line": 12}},
+			{"author": {"username": "bob"}, "body": "approved this merge request", "created_at": "2026-10-03T10:00:00Z", "system": true}
+		]`,
+	}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	forge, _ := New(GITLAB, server.URL, "acme/rfd", "secret")
+
+	comments, err := forge.GetDiscussion("https://gitlab.com/acme/rfd/-/merge_requests/12")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(comments) != 2 || comments[0].Line != 12 || comments[1].Verdict != APPROVED || comments[1].Body != "" {
+		t.Errorf("Unexpected comments %+v", comments)
+	}
+}
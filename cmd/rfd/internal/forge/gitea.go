@@ -0,0 +1,114 @@
+package forge
+
+import (
+	"strconv"
+)
+
+const GITEA_PAGE_SIZE = 50
+
+type gitea struct {
+	client     *client
+	repository string
+}
+
+// Gitea's pull requests look like GitHub's
+type giteaPullRequest = gitHubPullRequest
+
+func (g *gitea) OpenPullRequest(head string, base string, title string, body string) (*PullRequest, error) {
+
+	_, _, err := splitRepository(g.repository)
+	if err != nil {
+		return nil, err
+	}
+
+	// Gitea can't filter pull requests by head branch, so look through each page of them
+	for page := 1; ; page++ {
+
+		var open []giteaPullRequest
+		err = g.client.do("GET", "/repos/"+g.repository+"/pulls?state=open&limit="+strconv.Itoa(GITEA_PAGE_SIZE)+"&page="+strconv.Itoa(page), nil, &open)
+		if err != nil {
+			return nil, err
+		}
+		for _, pull := range open {
+			if pull.Head.Ref == head {
+				return pull.toPullRequest(), nil
+			}
+		}
+		if len(open) < GITEA_PAGE_SIZE {
+			break
+		}
+	}
+
+	var created giteaPullRequest
+	err = g.client.do("POST", "/repos/"+g.repository+"/pulls", map[string]string{
+		"title": title,
+		"head":  head,
+		"base":  base,
+		"body":  body,
+	}, &created)
+	if err != nil {
+		return nil, err
+	}
+
+	return created.toPullRequest(), nil
+}
+
+func (g *gitea) GetPullRequestStatus(pullURL string) (*PullRequestStatus, error) {
+
+	number, err := getPullRequestNumber(pullURL, g.repository)
+	if err != nil {
+		return nil, err
+	}
+	path := "/repos/" + g.repository + "/pulls/" + strconv.Itoa(number)
+
+	var pull giteaPullRequest
+	err = g.client.do("GET", path, nil, &pull)
+	if err != nil {
+		return nil, err
+	}
+
+	var reviews []gitHubReview
+	err = g.client.do("GET", path+"/reviews", nil, &reviews)
+	if err != nil {
+		return nil, err
+	}
+
+	return pull.toStatus(reviews, "REQUEST_CHANGES"), nil
+}
+
+func (g *gitea) GetDiscussion(pullURL string) ([]Comment, error) {
+
+	number, err := getPullRequestNumber(pullURL, g.repository)
+	if err != nil {
+		return nil, err
+	}
+	path := "/repos/" + g.repository
+
+	var conversation []gitHubComment
+	err = g.client.do("GET", path+"/issues/"+strconv.Itoa(number)+"/comments", nil, &conversation)
+	if err != nil {
+		return nil, err
+	}
+
+	var reviews []gitHubReview
+	err = g.client.getAll(path+"/pulls/"+strconv.Itoa(number)+"/reviews", "limit", GITEA_PAGE_SIZE, &reviews)
+	if err != nil {
+		return nil, err
+	}
+
+	// Gitea only gives the comments on lines review by review
+	var lineComments []gitHubComment
+	for _, review := range reviews {
+		if review.CommentsCount == 0 {
+			continue
+		}
+		var reviewComments []gitHubComment
+		err = g.client.do("GET", path+"/pulls/"+strconv.Itoa(number)+"/reviews/"+strconv.FormatInt(review.ID, 10)+"/comments", nil, &reviewComments)
+		if err != nil {
+			return nil, err
+		}
+		lineComments = append(lineComments, reviewComments...)
+	}
+
+	return toComments(conversation, reviews, lineComments, "REQUEST_CHANGES"), nil
+}
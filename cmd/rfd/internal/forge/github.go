@@ -0,0 +1,208 @@
+package forge
+
+import (
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const GITHUB_PAGE_SIZE = 100
+
+type gitHub struct {
+	client     *client
+	repository string
+}
+
+type gitHubPullRequest struct {
+	Number         int    `json:"number"`
+	HTMLURL        string `json:"html_url"`
+	State          string `json:"state"`
+	Merged         bool   `json:"merged"`
+	Comments       int    `json:"comments"`
+	ReviewComments int    `json:"review_comments"`
+	Head           struct {
+		Ref string `json:"ref"`
+	} `json:"head"`
+	Base struct {
+		Ref string `json:"ref"`
+	} `json:"base"`
+}
+
+func (g *gitHub) OpenPullRequest(head string, base string, title string, body string) (*PullRequest, error) {
+
+	owner, _, err := splitRepository(g.repository)
+	if err != nil {
+		return nil, err
+	}
+
+	var open []gitHubPullRequest
+	err = g.client.do("GET", "/repos/"+g.repository+"/pulls?state=open&head="+url.QueryEscape(owner+":"+head), nil, &open)
+	if err != nil {
+		return nil, err
+	}
+	if len(open) > 0 {
+		return open[0].toPullRequest(), nil
+	}
+
+	var created gitHubPullRequest
+	err = g.client.do("POST", "/repos/"+g.repository+"/pulls", map[string]string{
+		"title": title,
+		"head":  head,
+		"base":  base,
+		"body":  body,
+	}, &created)
+	if err != nil {
+		return nil, err
+	}
+
+	return created.toPullRequest(), nil
+}
+
+func (p *gitHubPullRequest) toPullRequest() *PullRequest {
+	return &PullRequest{Number: p.Number, URL: p.HTMLURL, Head: p.Head.Ref, Base: p.Base.Ref}
+}
+
+type gitHubReview struct {
+	ID   int64 `json:"id"`
+	User struct {
+		Login string `json:"login"`
+	} `json:"user"`
+	State         string    `json:"state"`
+	Body          string    `json:"body"`
+	SubmittedAt   time.Time `json:"submitted_at"`
+	CommentsCount int       `json:"comments_count"`
+}
+
+// gitHubComment is a comment on the conversation or on a line of a file. Gitea's comments on lines give
+// the line as a position.
+type gitHubComment struct {
+	User struct {
+		Login string `json:"login"`
+	} `json:"user"`
+	Body             string    `json:"body"`
+	CreatedAt        time.Time `json:"created_at"`
+	Path             string    `json:"path"`
+	Line             int       `json:"line"`
+	OriginalLine     int       `json:"original_line"`
+	Position         int       `json:"position"`
+	OriginalPosition int       `json:"original_position"`
+}
+
+func (g *gitHub) GetPullRequestStatus(pullURL string) (*PullRequestStatus, error) {
+
+	number, err := getPullRequestNumber(pullURL, g.repository)
+	if err != nil {
+		return nil, err
+	}
+	path := "/repos/" + g.repository + "/pulls/" + strconv.Itoa(number)
+
+	var pull gitHubPullRequest
+	err = g.client.do("GET", path, nil, &pull)
+	if err != nil {
+		return nil, err
+	}
+
+	var reviews []gitHubReview
+	err = g.client.do("GET", path+"/reviews?per_page=100", nil, &reviews)
+	if err != nil {
+		return nil, err
+	}
+
+	return pull.toStatus(reviews, "CHANGES_REQUESTED"), nil
+}
+
+// toStatus is shared with Gitea, whose pull requests and reviews look the same apart from the verdict
+// given when changes are requested.
+func (p *gitHubPullRequest) toStatus(reviews []gitHubReview, changesRequested string) *PullRequestStatus {
+
+	status := &PullRequestStatus{
+		PullRequest: *p.toPullRequest(),
+		State:       OPEN,
+		Comments:    p.Comments + p.ReviewComments,
+	}
+	if p.Merged {
+		status.State = MERGED
+	} else if p.State == "closed" {
+		status.State = CLOSED
+	}
+
+	var reviewers, verdicts []string
+	for _, review := range reviews {
+		reviewers = append(reviewers, review.User.Login)
+		verdicts = append(verdicts, review.State)
+	}
+	status.ApprovedBy, status.ChangesRequested = latestReviews(reviewers, verdicts, "APPROVED", changesRequested)
+
+	return status
+}
+
+func (g *gitHub) GetDiscussion(pullURL string) ([]Comment, error) {
+
+	number, err := getPullRequestNumber(pullURL, g.repository)
+	if err != nil {
+		return nil, err
+	}
+	path := "/repos/" + g.repository
+
+	// The conversation on a pull request belongs to the issue GitHub makes for each one
+	var conversation []gitHubComment
+	err = g.client.getAll(path+"/issues/"+strconv.Itoa(number)+"/comments", "per_page", GITHUB_PAGE_SIZE, &conversation)
+	if err != nil {
+		return nil, err
+	}
+
+	var reviews []gitHubReview
+	err = g.client.getAll(path+"/pulls/"+strconv.Itoa(number)+"/reviews", "per_page", GITHUB_PAGE_SIZE, &reviews)
+	if err != nil {
+		return nil, err
+	}
+
+	var lineComments []gitHubComment
+	err = g.client.getAll(path+"/pulls/"+strconv.Itoa(number)+"/comments", "per_page", GITHUB_PAGE_SIZE, &lineComments)
+	if err != nil {
+		return nil, err
+	}
+
+	return toComments(conversation, reviews, lineComments, "CHANGES_REQUESTED"), nil
+}
+
+// toComments is shared with Gitea, merging the conversation, reviews and comments on lines into one
+// discussion.
+func toComments(conversation []gitHubComment, reviews []gitHubReview, lineComments []gitHubComment, changesRequested string) []Comment {
+
+	var comments []Comment
+
+	for _, comment := range conversation {
+		comments = append(comments, Comment{Author: comment.User.Login, Body: comment.Body, Created: comment.CreatedAt})
+	}
+
+	for _, review := range reviews {
+		verdict := ""
+		switch review.State {
+		case "APPROVED":
+			verdict = APPROVED
+		case changesRequested:
+			verdict = CHANGES_REQUESTED
+		case "PENDING":
+			continue
+		}
+		// Reviews that only gather comments on lines have nothing to say themselves
+		if verdict == "" && review.Body == "" {
+			continue
+		}
+		comments = append(comments, Comment{Author: review.User.Login, Body: review.Body, Created: review.SubmittedAt, Verdict: verdict})
+	}
+
+	for _, comment := range lineComments {
+		line := comment.Line
+		for _, alternative := range []int{comment.OriginalLine, comment.Position, comment.OriginalPosition} {
+			if line == 0 {
+				line = alternative
+			}
+		}
+		comments = append(comments, Comment{Author: comment.User.Login, Body: comment.Body, Created: comment.CreatedAt, Path: comment.Path, Line: line})
+	}
+
+	sortComments(comments)
+	return comments
+}
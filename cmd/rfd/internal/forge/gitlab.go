@@ -0,0 +1,164 @@
+package forge
+
+import (
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const GITLAB_PAGE_SIZE = 100
+
+type gitLab struct {
+	client     *client
+	repository string
+}
+
+type gitLabMergeRequest struct {
+	IID          int    `json:"iid"`
+	WebURL       string `json:"web_url"`
+	SourceBranch string `json:"source_branch"`
+	TargetBranch string `json:"target_branch"`
+	State        string `json:"state"`
+	NotesCount   int    `json:"user_notes_count"`
+}
+
+type gitLabApprovals struct {
+	ApprovedBy []struct {
+		User struct {
+			Username string `json:"username"`
+		} `json:"user"`
+	} `json:"approved_by"`
+}
+
+type gitLabNote struct {
+	Author struct {
+		Username string `json:"username"`
+	} `json:"author"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+	System    bool      `json:"system"`
+	Position  *struct {
+		NewPath string `json:"new_path"`
+		NewLine int    `json:"new_line"`
+		OldPath string `json:"old_path"`
+		OldLine int    `json:"old_line"`
+	} `json:"position"`
+}
+
+// projectPath returns the API path of the project; GitLab takes the project's full path URL encoded.
+func (g *gitLab) projectPath() string {
+	return "/projects/" + url.PathEscape(g.repository)
+}
+
+func (g *gitLab) OpenPullRequest(head string, base string, title string, body string) (*PullRequest, error) {
+
+	var open []gitLabMergeRequest
+	err := g.client.do("GET", g.projectPath()+"/merge_requests?state=opened&source_branch="+url.QueryEscape(head), nil, &open)
+	if err != nil {
+		return nil, err
+	}
+	if len(open) > 0 {
+		return open[0].toPullRequest(), nil
+	}
+
+	var created gitLabMergeRequest
+	err = g.client.do("POST", g.projectPath()+"/merge_requests", map[string]string{
+		"title":         title,
+		"source_branch": head,
+		"target_branch": base,
+		"description":   body,
+	}, &created)
+	if err != nil {
+		return nil, err
+	}
+
+	return created.toPullRequest(), nil
+}
+
+func (m *gitLabMergeRequest) toPullRequest() *PullRequest {
+	return &PullRequest{Number: m.IID, URL: m.WebURL, Head: m.SourceBranch, Base: m.TargetBranch}
+}
+
+func (g *gitLab) GetPullRequestStatus(mergeURL string) (*PullRequestStatus, error) {
+
+	number, err := getPullRequestNumber(mergeURL, g.repository)
+	if err != nil {
+		return nil, err
+	}
+	path := g.projectPath() + "/merge_requests/" + strconv.Itoa(number)
+
+	var merge gitLabMergeRequest
+	err = g.client.do("GET", path, nil, &merge)
+	if err != nil {
+		return nil, err
+	}
+
+	var approvals gitLabApprovals
+	err = g.client.do("GET", path+"/approvals", nil, &approvals)
+	if err != nil {
+		return nil, err
+	}
+
+	// GitLab has no way of asking for changes other than by commenting
+	status := &PullRequestStatus{
+		PullRequest: *merge.toPullRequest(),
+		State:       OPEN,
+		Comments:    merge.NotesCount,
+	}
+	switch merge.State {
+	case "merged":
+		status.State = MERGED
+	case "closed":
+		status.State = CLOSED
+	}
+	for _, approval := range approvals.ApprovedBy {
+		status.ApprovedBy = append(status.ApprovedBy, approval.User.Username)
+	}
+
+	return status, nil
+}
+
+func (g *gitLab) GetDiscussion(mergeURL string) ([]Comment, error) {
+
+	number, err := getPullRequestNumber(mergeURL, g.repository)
+	if err != nil {
+		return nil, err
+	}
+
+	var notes []gitLabNote
+	err = g.client.getAll(g.projectPath()+"/merge_requests/"+strconv.Itoa(number)+"/notes?sort=asc&order_by=created_at", "per_page", GITLAB_PAGE_SIZE, &notes)
+	if err != nil {
+		return nil, err
+	}
+
+	var comments []Comment
+	for _, note := range notes {
+
+		comment := Comment{Author: note.Author.Username, Body: note.Body, Created: note.CreatedAt}
+
+		// System notes record what happened to the merge request; only approvals belong in the discussion
+		if note.System {
+			switch note.Body {
+			case "approved this merge request":
+				comment.Verdict = APPROVED
+			case "requested changes":
+				comment.Verdict = CHANGES_REQUESTED
+			default:
+				continue
+			}
+			comment.Body = ""
+		}
+
+		if note.Position != nil {
+			comment.Path, comment.Line = note.Position.NewPath, note.Position.NewLine
+			if comment.Line == 0 {
+				comment.Path, comment.Line = note.Position.OldPath, note.Position.OldLine
+			}
+		}
+
+		comments = append(comments, comment)
+	}
+
+	sortComments(comments)
+	return comments, nil
+}
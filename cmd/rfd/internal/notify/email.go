@@ -0,0 +1,59 @@
+package notify
+
+import (
+	"errors"
+	"mime"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DEFAULT_SMTP_PORT = 587
+
+// sendEmail emails a message through the channel's SMTP server, authenticating if it has a username.
+// The server refusing the message outright can't be retried.
+func sendEmail(channel Channel, recipients []string, subject string, message string, html bool) error {
+
+	port := channel.SMTPPort
+	if port == 0 {
+		port = DEFAULT_SMTP_PORT
+	}
+
+	var auth smtp.Auth
+	if channel.Username != "" {
+		auth = smtp.PlainAuth("", channel.Username, channel.Password, channel.SMTPHost)
+	}
+
+	err := smtp.SendMail(net.JoinHostPort(channel.SMTPHost, strconv.Itoa(port)), auth, channel.From, recipients, formatEmail(channel, recipients, subject, message, html))
+
+	var protocolError *textproto.Error
+	if errors.As(err, &protocolError) && protocolError.Code >= 500 {
+		return &permanentError{err}
+	}
+	return err
+}
+
+// formatEmail formats a plain text or HTML email, with CRLF line endings as SMTP expects.
+func formatEmail(channel Channel, recipients []string, subject string, message string, html bool) []byte {
+
+	contentType := "text/plain"
+	if html {
+		contentType = "text/html"
+	}
+
+	headers := []string{
+		"From: " + channel.From,
+		"To: " + strings.Join(recipients, ", "),
+		"Subject: " + mime.QEncoding.Encode("utf-8", subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: " + contentType + "; charset=utf-8",
+		"Content-Transfer-Encoding: 8bit",
+	}
+
+	body := strings.ReplaceAll(strings.ReplaceAll(message, "\r\n", "\n"), "\n", "\r\n")
+	return []byte(strings.Join(headers, "\r\n") + "\r\n\r\n" + body + "\r\n")
+}
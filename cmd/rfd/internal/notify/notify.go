@@ -0,0 +1,385 @@
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+/*
+
+Notifications tell people about RFDs as they're created, change state, are merged or go stale, so that
+no one needs to watch the repository to find out:
+
+1. Each configured channel says which events it wants, and how to word them, as text/template templates
+   executed on the Event.
+2. The message is delivered to the channel: posted as JSON to a webhook, posted to a Slack or Mattermost
+   incoming webhook, or emailed over SMTP.
+3. Failed deliveries are retried, waiting twice as long each time.
+4. Every delivery, whether it succeeded or not, is appended to the delivery log as a line of JSON.
+
+Messages worded elsewhere, such as digests of the week's activity, are delivered the same way by Send.
+
+*/
+
+// The events notified
+const CREATED = "created"
+const STATE_CHANGED = "state-changed"
+const MERGED = "merged"
+const STALE = "stale"
+const DIGEST = "digest"
+
+// The types of channel
+const WEBHOOK = "webhook"
+const SLACK = "slack"
+const MATTERMOST = "mattermost"
+const EMAIL = "email"
+
+const DEFAULT_RETRIES = 3
+
+// Event is something that has happened to an RFD.
+type Event struct {
+	Type    string    `json:"event"`
+	Time    time.Time `json:"time"`
+	ID      string    `json:"id,omitempty"`
+	Title   string    `json:"title,omitempty"`
+	Authors string    `json:"authors,omitempty"`
+	State   string    `json:"state,omitempty"`
+	Branch  string    `json:"branch,omitempty"`
+
+	// URL links to the RFD's discussion, if it has one
+	URL string `json:"url,omitempty"`
+
+	// AuthorEmails are the emails given with the RFD's authors, for channels that email authors
+	AuthorEmails []string `json:"author-emails,omitempty"`
+
+	// User is who made the change, if anyone did
+	User string `json:"user,omitempty"`
+
+	// PreviousState is the state a STATE_CHANGED RFD moved from
+	PreviousState string `json:"previous-state,omitempty"`
+
+	// Since is when a STALE RFD entered its state
+	Since *time.Time `json:"since,omitempty"`
+
+	// Metadata is everything in the RFD's metadata
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+}
+
+var defaultTemplates = map[string]string{
+	CREATED:       `New RFD {{.ID}}: {{.Title}}, by {{.Authors}}`,
+	STATE_CHANGED: `RFD {{.ID}}: {{.Title}} has moved from {{.PreviousState}} to {{.State}}`,
+	MERGED:        `RFD {{.ID}}: {{.Title}} has been merged`,
+	STALE:         `RFD {{.ID}}: {{.Title}} has been in {{.State}} for too long{{if .Since}}, since {{.Since.Format "2 January 2006"}}{{end}}`,
+}
+
+const DEFAULT_SUBJECT_TEMPLATE = `RFD {{.ID}}: {{.Title}}`
+
+// Channel is somewhere notifications are sent.
+type Channel struct {
+	Name string
+	Type string
+
+	// URL is the webhook's URL
+	URL string
+
+	// Events are those notified on the channel, or every event if there are none
+	Events []string
+
+	// Templates word the message for each event, in place of the default
+	Templates map[string]string
+
+	// Subject is the template for the subject of emails
+	Subject string
+
+	// The SMTP server emails are sent through, and who they're sent from and to
+	SMTPHost string
+	SMTPPort int
+	Username string
+	Password string
+	From     string
+	To       []string
+
+	// ToAuthors also emails the authors of the RFD
+	ToAuthors bool
+}
+
+// Notifier delivers events to the channels that want them.
+type Notifier struct {
+	channels   []Channel
+	retries    int
+	retryDelay time.Duration
+	logFile    string
+	httpClient *http.Client
+}
+
+// New returns a Notifier for channels, which retries each failed delivery up to retries times and logs
+// deliveries to logFile. The channels and their templates are checked first.
+func New(channels []Channel, retries int, logFile string) (*Notifier, error) {
+
+	n := &Notifier{
+		channels:   channels,
+		retries:    retries,
+		retryDelay: 2 * time.Second,
+		logFile:    logFile,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+
+	for i, channel := range channels {
+
+		n.channels[i].Type = strings.ToLower(channel.Type)
+		if channel.Name == "" {
+			n.channels[i].Name = n.channels[i].Type
+		}
+
+		switch n.channels[i].Type {
+		case WEBHOOK, SLACK, MATTERMOST, EMAIL:
+		default:
+			return nil, fmt.Errorf("unknown notification channel type %q, expected %s, %s, %s or %s", channel.Type, WEBHOOK, SLACK, MATTERMOST, EMAIL)
+		}
+		if n.channels[i].Type == EMAIL {
+			if channel.SMTPHost == "" || channel.From == "" || (len(channel.To) == 0 && !channel.ToAuthors) {
+				return nil, fmt.Errorf("the %s channel needs an SMTP host, and who to send from and to", n.channels[i].Name)
+			}
+		} else if channel.URL == "" {
+			return nil, fmt.Errorf("the %s channel has no URL", n.channels[i].Name)
+		}
+
+		for _, text := range channel.Templates {
+			_, err := parseTemplate(text)
+			if err != nil {
+				return nil, fmt.Errorf("the %s channel's template: %v", n.channels[i].Name, err)
+			}
+		}
+		if channel.Subject != "" {
+			_, err := parseTemplate(channel.Subject)
+			if err != nil {
+				return nil, fmt.Errorf("the %s channel's subject: %v", n.channels[i].Name, err)
+			}
+		}
+	}
+
+	return n, nil
+}
+
+// Notify delivers an event to every channel that wants it, returning the deliveries that failed.
+func (n *Notifier) Notify(event Event) error {
+
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	var failures []error
+	for _, channel := range n.channels {
+
+		if !channel.wants(event.Type) || (channel.Type == EMAIL && len(getRecipients(channel, event)) == 0) {
+			continue
+		}
+
+		text, ok := channel.Templates[event.Type]
+		if !ok {
+			text = defaultTemplates[event.Type]
+		}
+		message, err := render(text, event)
+		if err != nil {
+			failures = append(failures, fmt.Errorf("%s: %v", channel.Name, err))
+			continue
+		}
+
+		subject := ""
+		if channel.Type == EMAIL {
+			subject = channel.Subject
+			if subject == "" {
+				subject = DEFAULT_SUBJECT_TEMPLATE
+			}
+			subject, err = render(subject, event)
+			if err != nil {
+				failures = append(failures, fmt.Errorf("%s: %v", channel.Name, err))
+				continue
+			}
+		}
+
+		err = n.deliverWithRetries(channel, event, subject, message, false)
+		if err != nil {
+			failures = append(failures, fmt.Errorf("%s: %v", channel.Name, err))
+		}
+	}
+
+	return errors.Join(failures...)
+}
+
+// Send delivers a message that has already been worded, such as a digest, to every channel that wants
+// eventType. Emails are sent as HTML if html is set.
+func (n *Notifier) Send(eventType string, subject string, message string, html bool) error {
+
+	event := Event{Type: eventType, Time: time.Now()}
+
+	var failures []error
+	for _, channel := range n.channels {
+		if !channel.wants(eventType) {
+			continue
+		}
+		err := n.deliverWithRetries(channel, event, subject, message, html)
+		if err != nil {
+			failures = append(failures, fmt.Errorf("%s: %v", channel.Name, err))
+		}
+	}
+
+	return errors.Join(failures...)
+}
+
+func (c Channel) wants(eventType string) bool {
+
+	if len(c.Events) == 0 {
+		return true
+	}
+	for _, wanted := range c.Events {
+		if strings.EqualFold(wanted, eventType) {
+			return true
+		}
+	}
+	return false
+}
+
+// deliverWithRetries delivers a message to a channel, retrying until it's delivered or there have been
+// retries failures, and logs the delivery.
+func (n *Notifier) deliverWithRetries(channel Channel, event Event, subject string, message string, html bool) error {
+
+	delay := n.retryDelay
+
+	var err error
+	attempts := 0
+	for {
+		attempts++
+		err = n.deliver(channel, event, subject, message, html)
+		if err == nil || attempts > n.retries || isPermanent(err) {
+			break
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+
+	logErr := n.logDelivery(delivery{
+		Time:     time.Now(),
+		Channel:  channel.Name,
+		Event:    event.Type,
+		ID:       event.ID,
+		Attempts: attempts,
+		Sent:     err == nil,
+		Error:    errorText(err),
+		Subject:  subject,
+		Message:  message,
+	})
+	if err == nil {
+		err = logErr
+	}
+	return err
+}
+
+// deliver makes one attempt at delivering a message to a channel.
+func (n *Notifier) deliver(channel Channel, event Event, subject string, message string, html bool) error {
+
+	switch channel.Type {
+	case WEBHOOK:
+		return n.postWebhook(channel, event, message)
+	case SLACK, MATTERMOST:
+		return n.postChatMessage(channel, message)
+	case EMAIL:
+		return sendEmail(channel, getRecipients(channel, event), subject, message, html)
+	}
+	return fmt.Errorf("unknown notification channel type %q", channel.Type)
+}
+
+// getRecipients returns who to email an event to.
+func getRecipients(channel Channel, event Event) []string {
+
+	recipients := append([]string{}, channel.To...)
+	if channel.ToAuthors {
+		recipients = append(recipients, event.AuthorEmails...)
+	}
+	return recipients
+}
+
+// delivery is a line of the delivery log.
+type delivery struct {
+	Time     time.Time `json:"time"`
+	Channel  string    `json:"channel"`
+	Event    string    `json:"event"`
+	ID       string    `json:"id"`
+	Attempts int       `json:"attempts"`
+	Sent     bool      `json:"sent"`
+	Error    string    `json:"error,omitempty"`
+	Subject  string    `json:"subject,omitempty"`
+	Message  string    `json:"message"`
+}
+
+func (n *Notifier) logDelivery(d delivery) error {
+
+	if n.logFile == "" {
+		return nil
+	}
+
+	line, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(n.logFile), 0755)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(n.logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+func errorText(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// permanentError is a failure that retrying won't fix, such as a webhook refusing the request.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func isPermanent(err error) bool {
+	var permanent *permanentError
+	return errors.As(err, &permanent)
+}
+
+func parseTemplate(text string) (*template.Template, error) {
+	return template.New("notification").Option("missingkey=zero").Parse(text)
+}
+
+func render(text string, event Event) (string, error) {
+
+	tmpl, err := parseTemplate(text)
+	if err != nil {
+		return "", err
+	}
+
+	var builder strings.Builder
+	err = tmpl.Execute(&builder, event)
+	if err != nil {
+		return "", err
+	}
+	return builder.String(), nil
+}
@@ -0,0 +1,243 @@
+package notify
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fakeWebhook stands in for a webhook, failing with each of failures in turn before accepting requests,
+// and recording the bodies posted to it.
+type fakeWebhook struct {
+	t        *testing.T
+	failures []int
+	bodies   []map[string]interface{}
+}
+
+func (f *fakeWebhook) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+
+	body := make(map[string]interface{})
+	err := json.NewDecoder(req.Body).Decode(&body)
+	if err != nil {
+		f.t.Errorf("Error decoding request body: %s", err)
+	}
+	f.bodies = append(f.bodies, body)
+
+	if len(f.failures) > 0 {
+		status := f.failures[0]
+		f.failures = f.failures[1:]
+		http.Error(w, "failed", status)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func newTestNotifier(t *testing.T, channels ...Channel) (*Notifier, string) {
+
+	logFile := filepath.Join(t.TempDir(), "notifications.log")
+	n, err := New(channels, 2, logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.retryDelay = 0
+	return n, logFile
+}
+
+func readDeliveries(t *testing.T, logFile string) []delivery {
+
+	content, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var deliveries []delivery
+	for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+		var d delivery
+		err = json.Unmarshal([]byte(line), &d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries
+}
+
+func TestWebhookRetries(t *testing.T) {
+
+	fake := &fakeWebhook{t: t, failures: []int{http.StatusServiceUnavailable, http.StatusBadGateway}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	n, logFile := newTestNotifier(t, Channel{Name: "hook", Type: WEBHOOK, URL: server.URL})
+
+	err := n.Notify(Event{Type: STATE_CHANGED, ID: "0042", Title: "Storage", State: "discussion", PreviousState: "draft"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fake.bodies) != 3 {
+		t.Fatalf("Expected 3 attempts, found %d", len(fake.bodies))
+	}
+	body := fake.bodies[2]
+	if body["event"] != STATE_CHANGED || body["id"] != "0042" || body["previous-state"] != "draft" {
+		t.Errorf("Unexpected payload %v", body)
+	}
+	if body["message"] != "RFD 0042: Storage has moved from draft to discussion" {
+		t.Errorf("Unexpected message %q", body["message"])
+	}
+
+	deliveries := readDeliveries(t, logFile)
+	if len(deliveries) != 1 || !deliveries[0].Sent || deliveries[0].Attempts != 3 || deliveries[0].Channel != "hook" {
+		t.Errorf("Unexpected deliveries %+v", deliveries)
+	}
+}
+
+func TestWebhookRefusalIsNotRetried(t *testing.T) {
+
+	fake := &fakeWebhook{t: t, failures: []int{http.StatusBadRequest}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	n, logFile := newTestNotifier(t, Channel{Type: WEBHOOK, URL: server.URL})
+
+	err := n.Notify(Event{Type: CREATED, ID: "0042", Title: "Storage"})
+	if err == nil {
+		t.Fatal("Expected the delivery to fail")
+	}
+
+	if len(fake.bodies) != 1 {
+		t.Errorf("Expected 1 attempt, found %d", len(fake.bodies))
+	}
+	deliveries := readDeliveries(t, logFile)
+	if len(deliveries) != 1 || deliveries[0].Sent || deliveries[0].Error == "" {
+		t.Errorf("Unexpected deliveries %+v", deliveries)
+	}
+}
+
+func TestChatMessage(t *testing.T) {
+
+	fake := &fakeWebhook{t: t}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	n, _ := newTestNotifier(t, Channel{
+		Type:      SLACK,
+		URL:       server.URL,
+		Events:    []string{MERGED},
+		Templates: map[string]string{MERGED: ":tada: {{.ID}} {{.Title}} merged by {{.User}}"},
+	})
+
+	err := n.Notify(Event{Type: CREATED, ID: "0042", Title: "Storage"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = n.Notify(Event{Type: MERGED, ID: "0042", Title: "Storage", User: "Alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fake.bodies) != 1 || fake.bodies[0]["text"] != ":tada: 0042 Storage merged by Alice" {
+		t.Errorf("Unexpected bodies %v", fake.bodies)
+	}
+}
+
+func TestEmail(t *testing.T) {
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	received := make(chan string, 1)
+	go serveFakeSMTP(listener, received)
+
+	address := listener.Addr().(*net.TCPAddr)
+	n, logFile := newTestNotifier(t, Channel{
+		Name:     "team",
+		Type:     EMAIL,
+		SMTPHost: "127.0.0.1",
+		SMTPPort: address.Port,
+		From:     "rfd@example.com",
+		To:       []string{"team@example.com"},
+		Subject:  "[RFD] {{.ID}} {{.Title}}",
+	})
+
+	err = n.Notify(Event{Type: CREATED, ID: "0042", Title: "Storage", Authors: "Alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transcript := <-received
+	for _, expected := range []string{
+		"MAIL FROM:<rfd@example.com>",
+		"RCPT TO:<team@example.com>",
+		"Subject: [RFD] 0042 Storage",
+		"New RFD 0042: Storage, by Alice",
+	} {
+		if !strings.Contains(transcript, expected) {
+			t.Errorf("Expected %q in the transcript:\n%s", expected, transcript)
+		}
+	}
+
+	deliveries := readDeliveries(t, logFile)
+	if len(deliveries) != 1 || !deliveries[0].Sent || deliveries[0].Subject != "[RFD] 0042 Storage" {
+		t.Errorf("Unexpected deliveries %+v", deliveries)
+	}
+}
+
+// serveFakeSMTP accepts one SMTP connection, accepting everything it's sent, and sends the transcript of
+// what the client said once it quits.
+func serveFakeSMTP(listener net.Listener, received chan string) {
+
+	conn, err := listener.Accept()
+	if err != nil {
+		received <- err.Error()
+		return
+	}
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	reply := func(code int, text string) {
+		conn.Write([]byte(strconv.Itoa(code) + " " + text + "\r\n"))
+	}
+
+	var transcript strings.Builder
+	reply(220, "localhost ready")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			break
+		}
+		transcript.WriteString(line)
+
+		command := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(command, "DATA"):
+			reply(354, "go ahead")
+			for {
+				line, err = reader.ReadString('\n')
+				if err != nil || line == ".\r\n" {
+					break
+				}
+				transcript.WriteString(line)
+			}
+			reply(250, "queued")
+		case strings.HasPrefix(command, "QUIT"):
+			reply(221, "bye")
+			received <- transcript.String()
+			return
+		default:
+			reply(250, "ok")
+		}
+	}
+
+	received <- transcript.String()
+}
@@ -0,0 +1,56 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// webhookPayload is what's posted to a webhook: the event, and the message it was worded as.
+type webhookPayload struct {
+	Event
+	Message string `json:"message"`
+}
+
+// chatPayload is what's posted to a Slack or Mattermost incoming webhook.
+type chatPayload struct {
+	Text string `json:"text"`
+}
+
+func (n *Notifier) postWebhook(channel Channel, event Event, message string) error {
+	return n.post(channel.URL, webhookPayload{Event: event, Message: message})
+}
+
+func (n *Notifier) postChatMessage(channel Channel, message string) error {
+	return n.post(channel.URL, chatPayload{Text: message})
+}
+
+// post posts a JSON body to url. Server errors and throttling can be retried, anything else the server
+// refuses can't.
+func (n *Notifier) post(url string, body interface{}) error {
+
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return &permanentError{err}
+	}
+
+	resp, err := n.httpClient.Post(url, "application/json", bytes.NewReader(encoded))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return nil
+	}
+
+	content, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	err = fmt.Errorf("POST %s: %s: %s", url, resp.Status, strings.TrimSpace(string(content)))
+	if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+		return err
+	}
+	return &permanentError{err}
+}
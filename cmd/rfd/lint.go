@@ -0,0 +1,627 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	localConfig "github.com/redazzo/rfd/cmd/rfd/internal/config"
+	"gopkg.in/yaml.v3"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+
+Checking RFDs for problems that would trip up the tooling or their readers:
+
+1. The metadata header must be there, and be YAML. It's parsed into nodes rather than values, so that
+   problems can be reported against their line, and ids such as 0002 are seen as written.
+2. The configured fields must be present, the id must match the RFD's directory, the state must be one of
+   those in states.yml, and the authors must be names, optionally followed by an <email>.
+3. The headings configured for the RFD's kind: must be present.
+4. Relative links must resolve, either to a file alongside the RFD or to another RFD, and the RFDs named
+   by relations such as supersedes: and depends-on: must exist.
+5. Old style RFDs that repeat their metadata in a table are warned about.
+
+RFD 0001 is the process RFD 'rfd init' seeds from template/0001/readme.md, which is also copied to the root
+readme. It's written for the root rather than from the RFD template, so it needn't have an id: field or
+the default headings, its links resolve from the root, and those that don't are only warned about.
+
+Problems are written for people, or as JSON or SARIF for CI, and errors make 'rfd lint' exit with 1.
+
+*/
+
+const LINT_ERROR = "error"
+const LINT_WARNING = "warning"
+
+const DEFAULT_LINT_KIND = "default"
+
+const SEEDED_RFD_ID = "0001"
+
+// lintRule is a check made by 'rfd lint'.
+type lintRule struct {
+	ID          string
+	Description string
+}
+
+var lintRules = []lintRule{
+	{"front-matter", "The readme starts with a YAML metadata header between --- lines."},
+	{"required-field", "The metadata has each of the required fields."},
+	{"field-type", "Each metadata field has the expected type."},
+	{"id-mismatch", "The id in the metadata matches the RFD's directory."},
+	{"unknown-state", "The state is one of those in states.yml."},
+	{"authors", "The authors are names, each optionally followed by an <email>."},
+	{"required-heading", "The readme has the headings required for its kind."},
+	{"broken-link", "Relative links resolve to a file or another RFD."},
+	{"relation", "Related RFDs are given by the ids of other RFDs that exist."},
+	{"metadata-table", "The metadata isn't repeated in a table below the header."},
+}
+
+// lintProblem is something wrong with an RFD.
+type lintProblem struct {
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	ID       string `json:"id"`
+	Path     string `json:"path"`
+	Line     int    `json:"line"`
+	Message  string `json:"message"`
+}
+
+var authorPattern = regexp.MustCompile(`^[^<>@,]+(\s*<[^<>\s@]+@[^<>\s@]+>)?$|^[^<>\s@]+@[^<>\s@]+$`)
+var inlineLinkPattern = regexp.MustCompile(`!?\[[^\]]*\]\(\s*<?([^)\s>]+)>?(?:\s+[^)]*)?\)`)
+var referenceLinkPattern = regexp.MustCompile(`^\s{0,3}\[[^\]]+\]:\s*<?([^\s>]+)>?`)
+var codeSpanPattern = regexp.MustCompile("`[^`]*`")
+var schemePattern = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.-]*:`)
+
+func lintRFDs(args []string, format string) (int, error) {
+
+	err := checkProblemFormat(format)
+	if err != nil {
+		return 0, err
+	}
+
+	r, err := git.PlainOpen(".")
+	localConfig.CheckFatal(err)
+
+	rfds := readWorkingTreeRFDs(r, collectRFDs(r))
+	knownIds := make(map[string]bool)
+	for _, found := range rfds {
+		knownIds[found.ID] = true
+	}
+
+	var problems []lintProblem
+	linted := 0
+	if len(args) == 0 {
+		for _, found := range rfds {
+			problems = append(problems, lintFoundRFD(found, knownIds)...)
+			linted++
+		}
+	}
+
+	for _, arg := range args {
+
+		// Readmes can be given by their path, to lint them as they are on disk
+		if strings.HasSuffix(strings.ToLower(arg), ".md") {
+			content, err := os.ReadFile(arg)
+			if err != nil {
+				return 0, err
+			}
+			file := filepath.ToSlash(filepath.Clean(arg))
+			id := path.Base(path.Dir(file))
+			problems = append(problems, lintReadme(id, file, content, newLinkChecker(func(target string) bool {
+				_, err := os.Stat(filepath.FromSlash(target))
+				return err == nil
+			}, knownIds))...)
+			linted++
+			continue
+		}
+
+		rfdId, err := parseRFDId(arg)
+		if err != nil {
+			return 0, err
+		}
+		var found *rfd
+		for _, candidate := range rfds {
+			if candidate.ID == rfdId {
+				found = candidate
+			}
+		}
+		if found == nil {
+			return 0, fmt.Errorf("RFD %s wasn't found locally, on the remote, or on the trunk", rfdId)
+		}
+		problems = append(problems, lintFoundRFD(found, knownIds)...)
+		linted++
+	}
+
+	errors := countErrors(problems)
+	err = writeProblems(problems, lintRules, format)
+	if format == "text" {
+		fmt.Printf("%d errors, %d warnings in %d RFDs\n", errors, len(problems)-errors, linted)
+	}
+
+	return errors, err
+}
+
+// lintFoundRFD lints an RFD from wherever it was read, resolving its links in the same place.
+func lintFoundRFD(found *rfd, knownIds map[string]bool) []lintProblem {
+
+	if found.WorkingTree {
+		root := localConfig.APP_CONFIG.RootDirectory
+		return lintReadme(found.ID, found.Path, found.Content, newLinkChecker(func(target string) bool {
+			_, err := os.Stat(filepath.Join(root, filepath.FromSlash(target)))
+			return err == nil
+		}, knownIds))
+	}
+
+	return lintReadme(found.ID, found.Path, found.Content, newTreeLinkChecker(found.Commit, knownIds))
+}
+
+// newTreeLinkChecker returns a link checker resolving links against the files of a commit.
+func newTreeLinkChecker(commit *object.Commit, knownIds map[string]bool) func(target string) bool {
+
+	tree, err := commit.Tree()
+	return newLinkChecker(func(target string) bool {
+		if err != nil {
+			return false
+		}
+		_, findErr := tree.FindEntry(target)
+		return findErr == nil
+	}, knownIds)
+}
+
+// newLinkChecker returns whether a link target, given by its path from the root of the repository,
+// resolves to a file that exists, or to one of the known RFDs.
+func newLinkChecker(exists func(target string) bool, knownIds map[string]bool) func(target string) bool {
+	return func(target string) bool {
+		if exists(target) {
+			return true
+		}
+		match := rfdLinkPattern.FindStringSubmatch(target)
+		return match != nil && knownIds[match[1]]
+	}
+}
+
+// lintReadme checks the readme of the RFD in directory id, found at file. resolves says whether a link
+// target, given by its path from the root of the repository, resolves.
+func lintReadme(id string, file string, content []byte, resolves func(target string) bool) []lintProblem {
+
+	var problems []lintProblem
+	report := func(rule string, severity string, line int, message string, args ...interface{}) {
+		problems = append(problems, lintProblem{
+			Rule:     rule,
+			Severity: severity,
+			ID:       id,
+			Path:     file,
+			Line:     line,
+			Message:  fmt.Sprintf(message, args...),
+		})
+	}
+
+	lines := strings.Split(strings.ReplaceAll(string(content), "\r\n", "\n"), "\n")
+
+	fields, bodyStart := lintFrontMatter(lines, report)
+	if fields != nil {
+		lintFields(id, fields, report)
+		lintRelations(id, fields, resolves, report)
+	}
+
+	directory := path.Dir(file)
+	if id == SEEDED_RFD_ID {
+		directory = path.Dir(directory)
+	}
+
+	lintHeadings(id, lines, fields, report)
+	lintLinks(id, lines, bodyStart, directory, resolves, report)
+
+	// An old style table repeating the metadata, which comes first after the header
+	for i := bodyStart; i < len(lines); i++ {
+		trimmed := strings.ToLower(strings.TrimSpace(lines[i]))
+		if trimmed == "" {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "|") && strings.Contains(trimmed, "title") && strings.Contains(trimmed, "state") {
+			report("metadata-table", LINT_WARNING, i+1, "the metadata is repeated in a table, which goes out of date as the header changes")
+		}
+		break
+	}
+
+	sort.SliceStable(problems, func(i, j int) bool {
+		return problems[i].Line < problems[j].Line
+	})
+	return problems
+}
+
+// lintFrontMatter parses the metadata header into its fields, keyed by name, returning nil if it can't.
+// It also returns the index of the first line after the header.
+func lintFrontMatter(lines []string, report func(rule string, severity string, line int, message string, args ...interface{})) (map[string]*frontMatterField, int) {
+
+	if len(lines) == 0 || strings.TrimSpace(lines[0]) != "---" {
+		report("front-matter", LINT_ERROR, 1, "there's no metadata header, it should start the readme between --- lines")
+		return nil, 0
+	}
+
+	end := -1
+	for i := 1; i < len(lines); i++ {
+		if strings.TrimSpace(lines[i]) == "---" {
+			end = i
+			break
+		}
+	}
+	if end < 0 {
+		report("front-matter", LINT_ERROR, 1, "the metadata header isn't closed with a --- line")
+		return nil, len(lines)
+	}
+
+	var document yaml.Node
+	err := yaml.Unmarshal([]byte(strings.Join(lines[1:end], "\n")), &document)
+	if err != nil {
+		report("front-matter", LINT_ERROR, 1, "the metadata header isn't valid YAML: %v", err)
+		return nil, end + 1
+	}
+
+	fields := make(map[string]*frontMatterField)
+	if len(document.Content) == 0 {
+		return fields, end + 1
+	}
+	mapping := document.Content[0]
+	if mapping.Kind != yaml.MappingNode {
+		report("front-matter", LINT_ERROR, mapping.Line+1, "the metadata header should be a set of fields")
+		return nil, end + 1
+	}
+
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		key, value := mapping.Content[i], mapping.Content[i+1]
+		fields[strings.ToLower(key.Value)] = &frontMatterField{Line: key.Line + 1, Value: value}
+	}
+
+	return fields, end + 1
+}
+
+// frontMatterField is a field of the metadata header, and the line it's on.
+type frontMatterField struct {
+	Line  int
+	Value *yaml.Node
+}
+
+func (f *frontMatterField) isEmpty() bool {
+	return f.Value.Kind == yaml.ScalarNode && (f.Value.Tag == "!!null" || strings.TrimSpace(f.Value.Value) == "")
+}
+
+func lintFields(id string, fields map[string]*frontMatterField, report func(rule string, severity string, line int, message string, args ...interface{})) {
+
+	required := localConfig.APP_CONFIG.Lint.RequiredFields
+	if len(required) == 0 {
+		required = localConfig.DEFAULT_REQUIRED_FIELDS
+	}
+	for _, name := range required {
+		field, ok := fields[strings.ToLower(name)]
+		if !ok && id == SEEDED_RFD_ID && strings.EqualFold(name, "id") {
+			continue
+		}
+		if !ok {
+			report("required-field", LINT_ERROR, 1, "the metadata has no %s: field", name)
+		} else if field.isEmpty() {
+			report("required-field", LINT_ERROR, field.Line, "the %s: field is empty", name)
+		}
+	}
+
+	for _, name := range []string{"id", "title", "state", "discussion", "kind"} {
+		field, ok := fields[name]
+		if ok && field.Value.Kind != yaml.ScalarNode {
+			report("field-type", LINT_ERROR, field.Line, "the %s: field should be a single value", name)
+			delete(fields, name)
+		}
+	}
+
+	if field, ok := fields["id"]; ok && !field.isEmpty() {
+		if field.Value.Value != id {
+			report("id-mismatch", LINT_ERROR, field.Line, "the id %s doesn't match the RFD's directory, %s", field.Value.Value, id)
+		}
+	}
+
+	if field, ok := fields["state"]; ok && !field.isEmpty() {
+		if !isConfiguredState(field.Value.Value) {
+			report("unknown-state", LINT_ERROR, field.Line, "the state %q isn't in states.yml, expected one of: %s", field.Value.Value, strings.Join(getStateNames(), ", "))
+		}
+	}
+
+	if field, ok := fields["authors"]; ok && !field.isEmpty() {
+		var authors []string
+		switch field.Value.Kind {
+		case yaml.ScalarNode:
+			authors = strings.Split(field.Value.Value, ",")
+		case yaml.SequenceNode:
+			for _, item := range field.Value.Content {
+				if item.Kind != yaml.ScalarNode {
+					report("field-type", LINT_ERROR, item.Line+1, "each of the authors should be a single value")
+					continue
+				}
+				authors = append(authors, item.Value)
+			}
+		default:
+			report("field-type", LINT_ERROR, field.Line, "the authors: field should be a comma delimited list, or a YAML list")
+		}
+		for _, author := range authors {
+			if !authorPattern.MatchString(strings.TrimSpace(author)) {
+				report("authors", LINT_ERROR, field.Line, "the author %q should be a name, optionally followed by an <email>", strings.TrimSpace(author))
+			}
+		}
+	}
+}
+
+func lintHeadings(id string, lines []string, fields map[string]*frontMatterField, report func(rule string, severity string, line int, message string, args ...interface{})) {
+
+	kind := DEFAULT_LINT_KIND
+	if field, ok := fields["kind"]; ok && !field.isEmpty() {
+		kind = field.Value.Value
+	}
+	if kind == DEFAULT_LINT_KIND && id == SEEDED_RFD_ID {
+		return
+	}
+
+	required, ok := localConfig.APP_CONFIG.Lint.Headings[kind]
+	if !ok && kind != DEFAULT_LINT_KIND {
+		report("required-heading", LINT_WARNING, fields["kind"].Line, "there are no headings configured for RFDs of kind %q", kind)
+		return
+	}
+
+	for _, heading := range required {
+		if findSection(lines, heading) == 0 {
+			report("required-heading", LINT_ERROR, 1, "there's no %q heading, which RFDs of kind %q need", heading, kind)
+		}
+	}
+}
+
+func lintLinks(id string, lines []string, bodyStart int, directory string, resolves func(target string) bool, report func(rule string, severity string, line int, message string, args ...interface{})) {
+
+	severity := LINT_ERROR
+	if id == SEEDED_RFD_ID {
+		severity = LINT_WARNING
+	}
+
+	inCode := false
+	for i := bodyStart; i < len(lines); i++ {
+
+		trimmed := strings.TrimSpace(lines[i])
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			inCode = !inCode
+			continue
+		}
+		if inCode {
+			continue
+		}
+
+		line := codeSpanPattern.ReplaceAllString(lines[i], "")
+		var targets []string
+		for _, match := range inlineLinkPattern.FindAllStringSubmatch(line, -1) {
+			targets = append(targets, match[1])
+		}
+		if match := referenceLinkPattern.FindStringSubmatch(line); match != nil {
+			targets = append(targets, match[1])
+		}
+
+		for _, target := range targets {
+			resolved, ok := resolveLinkTarget(directory, target)
+			if ok && !resolves(resolved) {
+				report("broken-link", severity, i+1, "the link to %s doesn't resolve to a file or RFD", target)
+			}
+		}
+	}
+}
+
+// resolveLinkTarget returns a relative link's target by its path from the root of the repository, or
+// false if the link isn't relative.
+func resolveLinkTarget(directory string, target string) (string, bool) {
+
+	if schemePattern.MatchString(target) || strings.HasPrefix(target, "#") || strings.HasPrefix(target, "//") {
+		return "", false
+	}
+	if index := strings.IndexAny(target, "#?"); index >= 0 {
+		target = target[:index]
+	}
+	if unescaped, err := url.PathUnescape(target); err == nil {
+		target = unescaped
+	}
+	if target == "" {
+		return "", false
+	}
+
+	if strings.HasPrefix(target, "/") {
+		return strings.TrimSuffix(path.Clean(strings.TrimPrefix(target, "/")), "/"), true
+	}
+	return path.Clean(path.Join(directory, target)), true
+}
+
+// countErrors returns how many of problems are errors rather than warnings.
+func countErrors(problems []lintProblem) int {
+
+	errors := 0
+	for _, problem := range problems {
+		if problem.Severity == LINT_ERROR {
+			errors++
+		}
+	}
+	return errors
+}
+
+// checkProblemFormat returns an error if problems can't be written in a format.
+func checkProblemFormat(format string) error {
+	if !containsString([]string{"text", "json", "sarif", "github"}, format) {
+		return fmt.Errorf("unknown format %q, expected text, json, sarif or github", format)
+	}
+	return nil
+}
+
+// writeProblems writes problems found by the rules to stdout, one a line for people, as JSON or SARIF,
+// or as GitHub Actions workflow commands, which annotate the lines of a pull request.
+func writeProblems(problems []lintProblem, rules []lintRule, format string) error {
+
+	switch format {
+	case "json":
+		if problems == nil {
+			problems = []lintProblem{}
+		}
+		return writeJSON(problems)
+	case "sarif":
+		return writeJSON(getSARIFLog(problems, rules))
+	}
+
+	for _, problem := range problems {
+		if format == "github" {
+			properties := "file=" + escapeWorkflowProperty(problem.Path)
+			if problem.Line > 0 {
+				properties += ",line=" + strconv.Itoa(problem.Line)
+			}
+			properties += ",title=" + escapeWorkflowProperty(problem.Rule)
+			fmt.Printf("::%s %s::%s\n", problem.Severity, properties, escapeWorkflowMessage(problem.Message))
+		} else if problem.Line > 0 {
+			fmt.Printf("%s:%d: %s: %s [%s]\n", problem.Path, problem.Line, problem.Severity, problem.Message, problem.Rule)
+		} else {
+			fmt.Printf("%s: %s: %s [%s]\n", problem.Path, problem.Severity, problem.Message, problem.Rule)
+		}
+	}
+	return nil
+}
+
+func escapeWorkflowMessage(message string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(message)
+}
+
+func escapeWorkflowProperty(property string) string {
+	return strings.NewReplacer(":", "%3A", ",", "%2C").Replace(escapeWorkflowMessage(property))
+}
+
+func writeJSON(value interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+// getSARIFLog returns problems found by the rules as a SARIF 2.1.0 log, which code scanning tools can
+// annotate pull requests with.
+func getSARIFLog(problems []lintProblem, rules []lintRule) map[string]interface{} {
+
+	var ruleDescriptions []map[string]interface{}
+	for _, rule := range rules {
+		ruleDescriptions = append(ruleDescriptions, map[string]interface{}{
+			"id":               rule.ID,
+			"shortDescription": map[string]string{"text": rule.Description},
+		})
+	}
+
+	results := []map[string]interface{}{}
+	for _, problem := range problems {
+		location := map[string]interface{}{
+			"artifactLocation": map[string]string{"uri": problem.Path},
+		}
+		if problem.Line > 0 {
+			location["region"] = map[string]int{"startLine": problem.Line}
+		}
+		results = append(results, map[string]interface{}{
+			"ruleId":    problem.Rule,
+			"level":     problem.Severity,
+			"message":   map[string]string{"text": problem.Message},
+			"locations": []map[string]interface{}{{"physicalLocation": location}},
+		})
+	}
+
+	return map[string]interface{}{
+		"$schema": "https://json.schemastore.org/sarif-2.1.0.json",
+		"version": "2.1.0",
+		"runs": []map[string]interface{}{{
+			"tool": map[string]interface{}{
+				"driver": map[string]interface{}{
+					"name":           "rfd",
+					"informationUri": "https://github.com/redazzo/rfd",
+					"rules":          ruleDescriptions,
+				},
+			},
+			"results": results,
+		}},
+	}
+}
+
+func lintRelations(id string, fields map[string]*frontMatterField, resolves func(target string) bool, report func(rule string, severity string, line int, message string, args ...interface{})) {
+
+	for _, name := range relationFields {
+		field, ok := fields[name]
+		if !ok || field.isEmpty() {
+			continue
+		}
+		values, ok := field.values()
+		if !ok {
+			report("field-type", LINT_ERROR, field.Line, "the %s: field should be a comma delimited list of ids, or a YAML list", name)
+			continue
+		}
+		for _, value := range values {
+			relatedId, err := parseRFDId(value)
+			switch {
+			case err != nil:
+				report("relation", LINT_ERROR, field.Line, "%s: %q isn't an RFD id", name, value)
+			case relatedId == id:
+				report("relation", LINT_ERROR, field.Line, "%s: an RFD can't be related to itself", name)
+			case !resolves(relatedId):
+				report("relation", LINT_ERROR, field.Line, "%s: there's no RFD %s", name, relatedId)
+			}
+		}
+	}
+}
+
+// values returns the items of a field that's a comma delimited list, or a YAML list, and false if it's
+// neither.
+func (f *frontMatterField) values() ([]string, bool) {
+
+	var values []string
+	switch f.Value.Kind {
+	case yaml.ScalarNode:
+		for _, value := range strings.Split(f.Value.Value, ",") {
+			if strings.TrimSpace(value) != "" {
+				values = append(values, strings.TrimSpace(value))
+			}
+		}
+	case yaml.SequenceNode:
+		for _, item := range f.Value.Content {
+			if item.Kind != yaml.ScalarNode {
+				return nil, false
+			}
+			values = append(values, strings.TrimSpace(item.Value))
+		}
+	default:
+		return nil, false
+	}
+	return values, true
+}
+
+// readFrontMatterList returns the items of a field of the metadata header that's a list, as they're
+// written, or nil if it isn't there.
+func readFrontMatterList(content []byte, name string) []string {
+
+	lines := strings.Split(strings.ReplaceAll(string(content), "\r\n", "\n"), "\n")
+	fields, _ := lintFrontMatter(lines, func(rule string, severity string, line int, message string, args ...interface{}) {})
+	field, ok := fields[strings.ToLower(name)]
+	if !ok || field.isEmpty() {
+		return nil
+	}
+	values, _ := field.values()
+	return values
+}
+
+// readFrontMatterField returns a field of the metadata header as it's written, so that ids such as 0002
+// keep their leading zeros, or "" if it isn't there.
+func readFrontMatterField(content []byte, name string) string {
+
+	lines := strings.Split(strings.ReplaceAll(string(content), "\r\n", "\n"), "\n")
+	fields, _ := lintFrontMatter(lines, func(rule string, severity string, line int, message string, args ...interface{}) {})
+	field, ok := fields[strings.ToLower(name)]
+	if !ok || field.Value.Kind != yaml.ScalarNode {
+		return ""
+	}
+	return strings.TrimSpace(field.Value.Value)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	localConfig "github.com/redazzo/rfd/cmd/rfd/internal/config"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"text/template"
+)
+
+const lintedReadme = `---
+id: 0002
+title: Test RFD 2
+authors: GKH <gkh@example
+state: prediscussion
+---
+| RFD ID | Title | Authors | State | Discussion Link |
+|---|---|---|---|---|
+| 0002 | Test RFD 2 | GKH | prediscussion |  |
+
+# Introduction
+
+See [RFD 3](../0003/readme.md), [the diagram](./diagram.png), [the site](https://example.com) and ` + "`[code](code.md)`" + `.
+
+[missing]: /docs/missing.md`
+
+func TestLintReadme(t *testing.T) {
+
+	localConfig.APP_CONFIG = &localConfig.Configuration{
+		Lint: localConfig.Lint{Headings: map[string][]string{DEFAULT_LINT_KIND: {"Purpose"}}},
+	}
+	localConfig.APP_STATES = &localConfig.States{RFDStates: []map[string]map[string]string{
+		{"state": {"id": "1", "name": "draft"}},
+	}}
+
+	existing := map[string]bool{"0003/readme.md": true}
+	resolves := newLinkChecker(func(target string) bool { return existing[target] }, map[string]bool{})
+
+	var found []string
+	for _, problem := range lintReadme("0007", "0007/readme.md", []byte(lintedReadme), resolves) {
+		found = append(found, problem.Rule+":"+problem.Severity)
+	}
+
+	expected := []string{
+		"required-heading:error",
+		"id-mismatch:error",
+		"authors:error",
+		"unknown-state:error",
+		"metadata-table:warning",
+		"broken-link:error",
+		"broken-link:error",
+	}
+	if !reflect.DeepEqual(found, expected) {
+		t.Errorf("expected %v, got %v", expected, found)
+	}
+}
+
+func TestLintSeededRFD(t *testing.T) {
+
+	localConfig.APP_CONFIG = &localConfig.Configuration{
+		Lint: localConfig.Lint{Headings: map[string][]string{DEFAULT_LINT_KIND: {"Purpose"}}},
+	}
+	localConfig.APP_STATES = &localConfig.States{RFDStates: []map[string]map[string]string{
+		{"state": {"id": "1", "name": "draft"}},
+	}}
+
+	// RFD 0001 as 'rfd init' seeds it, and as existing repositories were seeded
+	seeded, err := template.ParseFiles(filepath.Join("..", "..", "template", "0001", "readme.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var content bytes.Buffer
+	err = seeded.Execute(&content, map[string]string{
+		"Title":   "The RFD Process",
+		"Authors": "Alice",
+		"State":   "draft",
+		"Link":    "",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	existing := map[string]bool{"index.md": true}
+	resolves := newLinkChecker(func(target string) bool { return existing[target] }, map[string]bool{})
+
+	var found []string
+	for _, problem := range lintReadme(SEEDED_RFD_ID, "0001/readme.md", content.Bytes(), resolves) {
+		found = append(found, problem.Rule+":"+problem.Severity)
+	}
+
+	// Only the link to the installation instructions, which aren't shipped, is warned about
+	expected := []string{"broken-link:warning"}
+	if !reflect.DeepEqual(found, expected) {
+		t.Errorf("expected %v, got %v", expected, found)
+	}
+}
+
+func TestResolveLinkTarget(t *testing.T) {
+
+	tests := []struct {
+		target   string
+		expected string
+		relative bool
+	}{
+		{"../0003/readme.md", "0003/readme.md", true},
+		{"diagram.png#top", "0002/diagram.png", true},
+		{"/docs/a%20b.md", "docs/a b.md", true},
+		{"https://example.com/x.md", "", false},
+		{"mailto:bob@example.com", "", false},
+		{"#purpose", "", false},
+	}
+
+	for _, test := range tests {
+		resolved, relative := resolveLinkTarget("0002", test.target)
+		if resolved != test.expected || relative != test.relative {
+			t.Errorf("%s: expected %q %v, got %q %v", test.target, test.expected, test.relative, resolved, relative)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	localConfig "github.com/redazzo/rfd/cmd/rfd/internal/config"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// listRFDs writes the RFDs matching a filter expression to stdout, sorted by the given keys, as either
+// a table or JSON.
+func listRFDs(expression string, sortKeys string, format string) error {
+
+	matches, err := parseFilter(expression)
+	if err != nil {
+		return err
+	}
+
+	r, err := git.PlainOpen(".")
+	localConfig.CheckFatal(err)
+
+	var records []map[string]interface{}
+	for _, found := range collectRFDs(r) {
+		record := getRFDFields(found)
+		if matches.Matches(record) {
+			records = append(records, record)
+		}
+	}
+
+	sortByFields(records, sortKeys)
+
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if records == nil {
+			records = []map[string]interface{}{}
+		}
+		return encoder.Encode(records)
+
+	case "table":
+		writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(writer, "ID\tTITLE\tSTATE\tAUTHORS\tBRANCH")
+		for _, record := range records {
+			fmt.Fprintln(writer, strings.Join([]string{
+				metadataString(record, "id"),
+				metadataString(record, "title"),
+				metadataString(record, "state"),
+				metadataString(record, "authors"),
+				metadataString(record, "branch"),
+			}, "\t"))
+		}
+		return writer.Flush()
+	}
+
+	return fmt.Errorf("unknown format %q, expected table or json", format)
+}
+
+// getRFDFields returns the fields of an RFD that can be filtered and sorted on: its metadata, plus
+// where it was found. The id is always that of its directory.
+func getRFDFields(found *rfd) map[string]interface{} {
+
+	fields := make(map[string]interface{})
+	for key, value := range found.Metadata {
+		fields[strings.ToLower(key)] = toJSONValue(value)
+	}
+
+	fields["id"] = found.ID
+	fields["branch"] = found.Branch
+	fields["merged"] = found.Merged
+
+	return fields
+}
+
+// toJSONValue converts the nested maps produced by the YAML parser, which have interface{} keys, into
+// values that can be encoded as JSON.
+func toJSONValue(value interface{}) interface{} {
+
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{})
+		for key, item := range v {
+			result[fmt.Sprintf("%v", key)] = toJSONValue(item)
+		}
+		return result
+	case map[string]interface{}:
+		result := make(map[string]interface{})
+		for key, item := range v {
+			result[key] = toJSONValue(item)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = toJSONValue(item)
+		}
+		return result
+	}
+
+	return value
+}
@@ -1,39 +1,397 @@
 package main
 
+import (
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	localConfig "github.com/redazzo/rfd/cmd/rfd/internal/config"
+	"github.com/redazzo/rfd/cmd/rfd/internal/forge"
+	"github.com/redazzo/rfd/cmd/rfd/internal/notify"
+	"strconv"
+	"strings"
+	"time"
+)
+
 /*
-   1. Complete a pull from main / master.
-   2. Update status to "Accepted"
-   3. Allow user to check pull (TODO)
-   4. Commit and push to branch.
-   5. Check out main / master.
-   6. Merge rfd into main / master.
+
+Merging an RFD into the trunk:
+
+1. Fetch from the remote, and find the RFD's branch.
+2. Move the RFD to accepted, if it isn't already.
+3. If the RFD links to a pull request on the configured forge, archive the pull request's conversation,
+   reviews and comments on lines as nnnn/discussion.md next to the readme, so that the record of the
+   discussion stays with the RFD whichever forge the repository lives on.
+4. Merge the RFD's directory into the trunk with a merge commit, regenerate the index, and push the trunk.
+   Branches that change anything outside their RFD's directory are refused, and need merging with git.
+5. Run the post-merge hook.
+
+An RFD whose pull request has already been merged on the forge just has its discussion archived on the
+trunk.
+
 */
 
-func doMerge() {
+const DISCUSSION_ARCHIVE_FILE = "discussion.md"
+
+func doMerge(rfdId string) error {
+
+	r, err := git.PlainOpen(".")
+	localConfig.CheckFatal(err)
+
+	if !localConfig.APP_CONFIG.Offline {
+		fmt.Println("Fetching from origin ...")
+		err = localConfig.FetchFromOrigin(r)
+		if err != nil {
+			fmt.Println("Unable to fetch from origin, using the last fetched remote branches: " + err.Error())
+		}
+	}
+
+	trunkName := getTrunkBranchName(r)
+	trunk, err := getTrunkToMergeInto(r, trunkName)
+	if err != nil {
+		return err
+	}
+
+	branch := getRFDBranch(r, rfdId)
+	if branch == nil || containsCommit(trunk, branch) {
+		if !commitHasDirectory(trunk, rfdId) {
+			return fmt.Errorf("RFD %s wasn't found locally, on the remote, or on the trunk", rfdId)
+		}
+		return archiveMergedDiscussion(r, rfdId, trunkName, trunk)
+	}
+
+	found := readRFDFromCommit(branch, rfdId, rfdId)
+	if found == nil {
+		return fmt.Errorf("RFD %s has no readme on its branch", rfdId)
+	}
+
+	// Find any reason not to merge before committing anything
+	_, err = isCheckedOut(r, plumbing.NewBranchReferenceName(trunkName))
+	if err != nil {
+		return err
+	}
+	err = checkBranchChanges(rfdId, trunk, branch)
+	if err != nil {
+		return err
+	}
+	err = checkQuorum(r, rfdId)
+	if err != nil {
+		return err
+	}
+
+	if found.State() != ACCEPTED_STATE {
+		fmt.Println("Moving RFD " + rfdId + " to " + ACCEPTED_STATE + " ...")
+		err = transitionRFD(r, rfdId, ACCEPTED_STATE, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	comments, discussion, err := getDiscussion(found)
+	if err != nil {
+		return err
+	}
+	if comments != nil {
+		fmt.Println("Archiving the discussion at " + discussion + " ...")
+		err = updateRFDFiles(r, rfdId, func(found *rfd) (map[string][]byte, error) {
+			return map[string][]byte{
+				rfdId + "/" + DISCUSSION_ARCHIVE_FILE: formatDiscussion(found, discussion, comments),
+			}, nil
+		}, rfdId+": Archive discussion", nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	branch = getRFDBranch(r, rfdId)
+	treeHash, err := getMergedTree(r, rfdId, trunk, branch)
+	if err != nil {
+		return err
+	}
+
+	message := "Merge RFD " + rfdId + ": " + found.Title()
+	err = commitToTrunk(r, trunkName, treeHash, []*object.Commit{trunk, branch}, message)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("RFD " + rfdId + " has been merged into " + trunkName)
+
+	merged := readRFDFromCommit(getCommit(r, plumbing.NewBranchReferenceName(trunkName)), rfdId, trunkName)
+	if merged != nil {
+		merged.Merged = true
+		fields := getRFDFields(merged)
+		runPostHook(r, POST_MERGE_HOOK, fields, nil, nil)
+		notifyEvent(r, notify.MERGED, fields, nil, "")
+	}
+	return nil
+}
+
+// getTrunkToMergeInto returns the commit of the trunk to merge on top of, which is the remote's if the
+// local trunk is behind it.
+func getTrunkToMergeInto(r *git.Repository, trunkName string) (*object.Commit, error) {
+
+	trunk := getCommit(r, plumbing.NewBranchReferenceName(trunkName))
+	remoteTrunk := getCommit(r, plumbing.NewRemoteReferenceName("origin", trunkName))
+
+	switch {
+	case trunk == nil && remoteTrunk == nil:
+		return nil, fmt.Errorf("the trunk branch %s wasn't found", trunkName)
+	case trunk == nil:
+		return remoteTrunk, nil
+	case remoteTrunk == nil || containsCommit(trunk, remoteTrunk):
+		return trunk, nil
+	case containsCommit(remoteTrunk, trunk):
+		return remoteTrunk, nil
+	}
+
+	return nil, fmt.Errorf("%s has diverged from the remote, reconcile it before merging", trunkName)
+}
+
+// getRFDBranch returns the head of an RFD's local branch, or of its remote branch if there's no local
+// branch, or nil if there's neither.
+func getRFDBranch(r *git.Repository, rfdId string) *object.Commit {
+
+	branch := getCommit(r, plumbing.NewBranchReferenceName(rfdId))
+	if branch == nil {
+		branch = getCommit(r, plumbing.NewRemoteReferenceName("origin", rfdId))
+	}
+	return branch
+}
+
+// checkBranchChanges returns an error if an RFD's branch has changed anything other than its RFD's
+// directory and the index since it left the trunk.
+func checkBranchChanges(rfdId string, trunk *object.Commit, branch *object.Commit) error {
+
+	bases, err := branch.MergeBase(trunk)
+	if err != nil {
+		return err
+	}
+	if len(bases) == 0 {
+		return fmt.Errorf("branch %s has no history in common with the trunk", rfdId)
+	}
+
+	baseTree, err := bases[0].Tree()
+	if err != nil {
+		return err
+	}
+	branchTree, err := branch.Tree()
+	if err != nil {
+		return err
+	}
 
-	updateStatus()
-	pullFromMain()
-	commitAndPush()
-	checkoutMain()
-	merge()
+	changes, err := baseTree.Diff(branchTree)
+	if err != nil {
+		return err
+	}
+	for _, change := range changes {
+		for _, name := range []string{change.From.Name, change.To.Name} {
+			if name != "" && name != "index.md" && !strings.HasPrefix(name, rfdId+"/") {
+				return fmt.Errorf("branch %s changes %s, which is outside %s/. Merge it with git instead", rfdId, name, rfdId)
+			}
+		}
+	}
+
+	return nil
 }
 
-func updateStatus() {
+// getMergedTree returns the tree of the trunk with the RFD's directory replaced by the branch's, and the
+// index regenerated.
+func getMergedTree(r *git.Repository, rfdId string, trunk *object.Commit, branch *object.Commit) (plumbing.Hash, error) {
+
+	branchTree, err := branch.Tree()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	trunkTree, err := trunk.Tree()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	// Remove the trunk's copy of the RFD's files, then add the branch's
+	files := make(map[string][]byte)
+	if directory, err := trunkTree.Tree(rfdId); err == nil {
+		err = directory.Files().ForEach(func(file *object.File) error {
+			files[rfdId+"/"+file.Name] = nil
+			return nil
+		})
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+	}
+	directory, err := branchTree.Tree(rfdId)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	err = directory.Files().ForEach(func(file *object.File) error {
+		content, err := file.Contents()
+		files[rfdId+"/"+file.Name] = []byte(content)
+		return err
+	})
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
 
+	treeHash, err := buildTree(r, trunkTree, files)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	tree, err := r.TreeObject(treeHash)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return buildTree(r, tree, map[string][]byte{"index.md": IndexFromTree(tree)})
 }
 
-func pullFromMain() {
+// archiveMergedDiscussion archives the discussion of an RFD that's already on the trunk, if it hasn't
+// been archived already.
+func archiveMergedDiscussion(r *git.Repository, rfdId string, trunkName string, trunk *object.Commit) error {
+
+	found := readRFDFromCommit(trunk, rfdId, trunkName)
+	if found == nil {
+		return fmt.Errorf("RFD %s has no readme on %s", rfdId, trunkName)
+	}
+
+	path := rfdId + "/" + DISCUSSION_ARCHIVE_FILE
+	if readFileFromCommit(trunk, path) != nil {
+		fmt.Println("RFD " + rfdId + " has already been merged, and its discussion archived")
+		return nil
+	}
+
+	comments, discussion, err := getDiscussion(found)
+	if err != nil {
+		return err
+	}
+	if comments == nil {
+		fmt.Println("RFD " + rfdId + " has already been merged")
+		return nil
+	}
+
+	fmt.Println("RFD " + rfdId + " has already been merged, archiving the discussion at " + discussion + " ...")
+
+	trunkTree, err := trunk.Tree()
+	if err != nil {
+		return err
+	}
+	treeHash, err := buildTree(r, trunkTree, map[string][]byte{path: formatDiscussion(found, discussion, comments)})
+	if err != nil {
+		return err
+	}
 
+	return commitToTrunk(r, trunkName, treeHash, []*object.Commit{trunk}, rfdId+": Archive discussion")
 }
 
-func commitAndPush() {
+// commitToTrunk commits a tree to the trunk, brings the working tree up to date if the trunk is checked
+// out, and pushes the trunk. A push origin rejects, because someone else has pushed to the trunk first, is
+// returned as an error.
+func commitToTrunk(r *git.Repository, trunkName string, treeHash plumbing.Hash, parents []*object.Commit, message string) error {
 
+	checkedOut, err := isCheckedOut(r, plumbing.NewBranchReferenceName(trunkName))
+	if err != nil {
+		return err
+	}
+
+	localConfig.Logger.TraceLog("Committing to " + trunkName + ": " + message)
+	commit, err := commitTree(r, trunkName, treeHash, parents, message, nil)
+	if err != nil {
+		return err
+	}
+
+	if checkedOut {
+		w, err := r.Worktree()
+		if err != nil {
+			return err
+		}
+		err = w.Reset(&git.ResetOptions{
+			Commit: commit.Hash,
+			Mode:   git.HardReset,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// The trunk is never queued for 'rfd sync', which only knows how to push RFD branches
+	if localConfig.APP_CONFIG.Offline {
+		fmt.Println(trunkName + " has been committed locally. Push it with 'git push origin " + trunkName + "' when you're back online.")
+		return nil
+	}
+
+	localConfig.Logger.TraceLog("Pushing " + trunkName + " to origin ...")
+	err = localConfig.PushBranchToOrigin(r, trunkName)
+	if err != nil {
+		return fmt.Errorf("%s has been committed locally, but couldn't be pushed: %v. Pull %s from origin, then push it again", trunkName, err, trunkName)
+	}
+	return nil
 }
 
-func checkoutMain() {
+// getDiscussion fetches the discussion on an RFD's pull request, returning nil if there's no forge
+// configured or the RFD doesn't link to a pull request.
+func getDiscussion(found *rfd) ([]forge.Comment, string, error) {
+
+	discussion := metadataString(found.Metadata, "discussion")
+	if discussion == "" {
+		return nil, "", nil
+	}
+
+	f, err := getForge()
+	if err != nil {
+		return nil, "", err
+	}
+	if f == nil {
+		fmt.Println("No forge is configured, so the discussion at " + discussion + " won't be archived")
+		return nil, "", nil
+	}
+
+	comments, err := f.GetDiscussion(discussion)
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to archive the discussion at %s: %v", discussion, err)
+	}
+	if comments == nil {
+		comments = []forge.Comment{}
+	}
 
+	return comments, discussion, nil
 }
 
-func merge() {
+// formatDiscussion writes out the discussion of an RFD as markdown, quoting each comment under a heading
+// saying who made it and when.
+func formatDiscussion(found *rfd, discussion string, comments []forge.Comment) []byte {
+
+	var builder strings.Builder
+
+	builder.WriteString("# Discussion of RFD " + found.ID + ": " + found.Title() + "\n\n")
+	builder.WriteString("Archived from " + discussion + " on " + time.Now().Format("2006-01-02") + ".\n")
+	if len(comments) == 0 {
+		builder.WriteString("\nThere were no comments.\n")
+	}
+
+	for _, comment := range comments {
+
+		heading := comment.Author
+		switch {
+		case comment.Verdict == forge.APPROVED:
+			heading += " approved"
+		case comment.Verdict == forge.CHANGES_REQUESTED:
+			heading += " requested changes"
+		case comment.Path != "" && comment.Line > 0:
+			heading += " on " + comment.Path + " line " + strconv.Itoa(comment.Line)
+		case comment.Path != "":
+			heading += " on " + comment.Path
+		}
+		heading += ", " + comment.Created.UTC().Format("2006-01-02 15:04 MST")
+
+		builder.WriteString("\n## " + heading + "\n")
+
+		body := strings.TrimSpace(strings.ReplaceAll(comment.Body, "\r\n", "\n"))
+		if body != "" {
+			builder.WriteString("\n")
+			for _, line := range strings.Split(body, "\n") {
+				builder.WriteString(strings.TrimRight("> "+line, " ") + "\n")
+			}
+		}
+	}
 
+	return []byte(builder.String())
 }
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+/*
+
+The metadata header at the head of an RFD's readme.md is edited line by line rather than by
+re-marshalling the YAML, so that the ordering, comments and formatting chosen by the author survive.
+
+*/
+
+// setFrontMatterField sets key to value in the metadata header of an RFD readme, adding the key to the
+// end of the header if it isn't already present. Any list items belonging to the existing key are replaced.
+func setFrontMatterField(content []byte, key string, value string) []byte {
+
+	lines := strings.Split(string(content), "\n")
+	newLine := key + ": " + value
+
+	if len(lines) == 0 || strings.TrimSpace(lines[0]) != "---" {
+		header := []string{"---", newLine, "---"}
+		return []byte(strings.Join(append(header, lines...), "\n"))
+	}
+
+	keyPattern := regexp.MustCompile(`^` + regexp.QuoteMeta(key) + `\s*:`)
+
+	for i := 1; i < len(lines); i++ {
+
+		line := strings.TrimRight(lines[i], "\r")
+
+		if line == "---" {
+			// End of the header, and the key wasn't found
+			result := append([]string{}, lines[:i]...)
+			result = append(result, newLine)
+			return []byte(strings.Join(append(result, lines[i:]...), "\n"))
+		}
+
+		if keyPattern.MatchString(line) {
+
+			// Skip over any continuation lines (e.g. a YAML list) belonging to the key
+			end := i + 1
+			for end < len(lines) && (strings.HasPrefix(lines[end], " ") || strings.HasPrefix(lines[end], "\t") || strings.HasPrefix(lines[end], "- ")) {
+				end++
+			}
+
+			result := append([]string{}, lines[:i]...)
+			result = append(result, newLine)
+			return []byte(strings.Join(append(result, lines[end:]...), "\n"))
+		}
+	}
+
+	// Unterminated header; leave the content as it is
+	return content
+}
+
+// formatFrontMatterValue formats a value to be set with setFrontMatterField. Values are written as JSON,
+// which is also valid YAML, and keeps lists on the one line.
+func formatFrontMatterValue(value interface{}) (string, error) {
+
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+
+	err := encoder.Encode(value)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buffer.String()), nil
+}
@@ -5,7 +5,13 @@ import (
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
 	localConfig "github.com/redazzo/rfd/cmd/rfd/internal/config"
+	"github.com/redazzo/rfd/cmd/rfd/internal/notify"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"strconv"
 	"strings"
 )
@@ -29,12 +35,32 @@ To create a new RFD:
 5. Create a readme.md file --> mmmm\readme.md
 6. Stage, commit, push to remote, and update upstream tracking
 
+The pre-new hook is run before step 4, and can stop the RFD being created; the post-new hook after step 6.
+
+When working offline, step 2 uses the remote-tracking branches from the last fetch rather than
+asking the remote, and the push in step 6 is queued until the next "rfd sync". The same happens
+if the remote can't be reached, or if the user asks not to push.
+
 */
 
-func new() {
+// How the branch of a new RFD is made available to work on.
+const (
+	// Switch the working tree to the new branch
+	CHECKOUT_IN_PLACE = iota
+	// Commit to the new branch without touching the working tree
+	CHECKOUT_NONE
+	// Check the new branch out into a linked worktree under WORKTREES_DIRECTORY
+	CHECKOUT_WORKTREE
+)
+
+const WORKTREES_DIRECTORY string = localConfig.WORKTREES_DIRECTORY
+
+func new(offline bool, noPush bool, checkoutMode int) {
 	localConfig.Logger.TraceLog("Creating new RFD")
 
-	newRFDNumber := getMaxRFDNumber() + 1
+	newRFDNumber, reachable, err := getMaxRFDNumber(offline)
+	localConfig.CheckFatal(err)
+	newRFDNumber++
 	localConfig.Logger.TraceLog("New RFD Number: " + strconv.Itoa(newRFDNumber))
 
 	title := localConfig.GetUserInput("Enter title of RFD: ")
@@ -46,7 +72,11 @@ func new() {
 
 	defaultStatus := getDefaultStatus()
 
-	err := createRFD(newRFDNumber, title, authors, defaultStatus, "")
+	if checkoutMode == CHECKOUT_IN_PLACE {
+		err = createRFD(newRFDNumber, title, authors, defaultStatus, "", reachable && !noPush)
+	} else {
+		err = createRFDWithoutCheckout(newRFDNumber, title, authors, defaultStatus, "", reachable && !noPush, checkoutMode == CHECKOUT_WORKTREE)
+	}
 
 	localConfig.CheckFatal(err)
 
@@ -67,11 +97,19 @@ func getDefaultStatus() string {
 	return result
 }
 
-func createRFD(rfdNumber int, title string, authors string, state string, link string) error {
+func createRFD(rfdNumber int, title string, authors string, state string, link string, push bool) error {
 
 	// Format the number to match nnnn
 	formattedRFDNumber := formatToNNNN(rfdNumber)
 
+	r, err := git.PlainOpen(".")
+	localConfig.CheckFatal(err)
+
+	err = runHook(r, PRE_NEW_HOOK, getNewRFDFields(formattedRFDNumber, title, authors, state), nil, nil)
+	if err != nil {
+		return err
+	}
+
 	// Branch, write the readme file, stage, commit, push, and set upstream
 
 	// Create a branch named as per "nnnn"
@@ -79,12 +117,11 @@ func createRFD(rfdNumber int, title string, authors string, state string, link s
 	localConfig.CheckFatal(err)
 
 	err, _ = localConfig.CreateReadme(&localConfig.RFDMetadata{
-		formattedRFDNumber,
-		title,
-		authors,
-		state,
-		link,
-		nil,
+		RFDID:   formattedRFDNumber,
+		Title:   title,
+		Authors: authors,
+		State:   state,
+		Link:    link,
 	}, localConfig.APP_CONFIG.GetReadmeTemplateLocation())
 
 	// Update index
@@ -108,25 +145,121 @@ func createRFD(rfdNumber int, title string, authors string, state string, link s
 	})
 	localConfig.CheckFatal(err)
 
-	// Push to origin and set upstream
-	localConfig.Logger.TraceLog("Pushing to origin ...")
-	err = localConfig.PushToOrigin(r)
-	localConfig.CheckFatal(err)
-	localConfig.Logger.TraceLog("Pushed to origin")
+	// Push to origin (or queue the push for later) and set upstream
+	err = pushOrQueue(r, formattedRFDNumber, push, true)
+	if err != nil {
+		return err
+	}
 
 	localConfig.Logger.TraceLog("Setting upstream ...")
 	err = setUpstream(r, formattedRFDNumber)
 	localConfig.Logger.TraceLog("Upstream set to " + formattedRFDNumber)
 
+	fields := getBranchRFDFields(r, formattedRFDNumber)
+	runPostHook(r, POST_NEW_HOOK, fields, nil, nil)
+	notifyEvent(r, notify.CREATED, fields, nil, "")
+
 	return err
 }
 
-func setUpstream(r *git.Repository, formattedRFDNumber string) error {
+// createRFDWithoutCheckout creates an RFD's branch, readme and index as createRFD does, but by committing
+// directly to the new branch so that the current checkout (which may have uncommitted work) is left alone.
+// The new branch starts from the trunk rather than from whatever happens to be checked out.
+func createRFDWithoutCheckout(rfdNumber int, title string, authors string, state string, link string, push bool, worktree bool) error {
+
+	formattedRFDNumber := formatToNNNN(rfdNumber)
 
 	r, err := git.PlainOpen(".")
 	localConfig.CheckFatal(err)
+
+	err = runHook(r, PRE_NEW_HOOK, getNewRFDFields(formattedRFDNumber, title, authors, state), nil, nil)
+	if err != nil {
+		return err
+	}
+
+	err = earmarkRFD(r, formattedRFDNumber, title, authors, state, link, nil)
+	if err != nil {
+		return err
+	}
+
+	if worktree {
+		err = addWorktree(formattedRFDNumber)
+		localConfig.CheckFatal(err)
+	}
+
+	err = pushOrQueue(r, formattedRFDNumber, push, true)
+	if err != nil {
+		return err
+	}
+
+	err = setUpstream(r, formattedRFDNumber)
+	localConfig.Logger.TraceLog("Upstream set to " + formattedRFDNumber)
+
+	fields := getBranchRFDFields(r, formattedRFDNumber)
+	runPostHook(r, POST_NEW_HOOK, fields, nil, nil)
+	notifyEvent(r, notify.CREATED, fields, nil, "")
+
+	if worktree {
+		fmt.Println("RFD " + formattedRFDNumber + " is checked out in " + WORKTREES_DIRECTORY + localConfig.PATH_SEPARATOR + formattedRFDNumber)
+	} else {
+		fmt.Println("RFD " + formattedRFDNumber + " has been created on branch " + formattedRFDNumber + ", your current checkout is unchanged.")
+	}
+
+	return err
+}
+
+// addWorktree checks a branch out into a linked worktree. go-git can't create linked worktrees, so
+// this is left to the git command line.
+func addWorktree(branch string) error {
+
+	err := excludeFromRepository(WORKTREES_DIRECTORY + "/")
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command("git", "worktree", "add", WORKTREES_DIRECTORY+"/"+branch, branch)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
+}
+
+// excludeFromRepository adds a pattern to the repository's local exclude file, so that it's
+// ignored without needing to change (and commit) .gitignore.
+func excludeFromRepository(pattern string) error {
+
+	excludeFile := localConfig.GetGitCommonDirectory() + localConfig.PATH_SEPARATOR + "info" + localConfig.PATH_SEPARATOR + "exclude"
+
+	content, err := os.ReadFile(excludeFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.TrimSpace(line) == pattern {
+			return nil
+		}
+	}
+
+	if len(content) > 0 && !strings.HasSuffix(string(content), "\n") {
+		content = append(content, '\n')
+	}
+	content = append(content, []byte(pattern+"\n")...)
+
+	err = os.MkdirAll(filepath.Dir(excludeFile), 0755)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(excludeFile, content, 0644)
+}
+
+func setUpstream(r *git.Repository, formattedRFDNumber string) error {
+
 	currentConfig, err := r.Config()
-	localConfig.CheckFatal(err)
+	if err != nil {
+		return err
+	}
 
 	branches := currentConfig.Branches
 
@@ -140,10 +273,7 @@ func setUpstream(r *git.Repository, formattedRFDNumber string) error {
 
 	branches[formattedRFDNumber] = newBranch
 
-	err = r.Storer.SetConfig(currentConfig)
-	localConfig.CheckFatal(err)
-
-	return err
+	return r.Storer.SetConfig(currentConfig)
 }
 
 func formatToNNNN(rfdNumber int) string {
@@ -204,18 +334,142 @@ func undoCreateBranch() {
 
 }
 
-func getMaxRFDNumber() int {
+// earmarkRFD creates the branch for a new RFD from the trunk, committing its readme and the regenerated
+// index without touching the current checkout.
+func earmarkRFD(r *git.Repository, formattedRFDNumber string, title string, authors string, state string, link string, author *object.Signature) error {
+
+	trunkName := getTrunkBranchName(r)
+	var parent *object.Commit
+	for _, name := range []plumbing.ReferenceName{
+		plumbing.NewBranchReferenceName(trunkName),
+		plumbing.NewRemoteReferenceName("origin", trunkName),
+		plumbing.HEAD,
+	} {
+		commit, err := findCommit(r, name)
+		if err != nil {
+			return err
+		}
+		if commit != nil {
+			parent = commit
+			break
+		}
+	}
+	if parent == nil {
+		return fmt.Errorf("unable to find the trunk (%s) to create the RFD branch from", trunkName)
+	}
+
+	readme, err := localConfig.RenderReadme(&localConfig.RFDMetadata{
+		RFDID:   formattedRFDNumber,
+		Title:   title,
+		Authors: authors,
+		State:   state,
+		Link:    link,
+	}, localConfig.APP_CONFIG.GetReadmeTemplateLocation())
+	if err != nil {
+		return err
+	}
+
+	files := map[string][]byte{
+		formattedRFDNumber + "/readme.md": readme,
+	}
+
+	// Build the tree once to render the index from it, then commit the readme and index together
+	parentTree, err := parent.Tree()
+	if err != nil {
+		return err
+	}
+	treeHash, err := buildTree(r, parentTree, files)
+	if err != nil {
+		return err
+	}
+	tree, err := r.TreeObject(treeHash)
+	if err != nil {
+		return err
+	}
+	files["index.md"], err = indexFromTree(tree)
+	if err != nil {
+		return err
+	}
+
+	localConfig.Logger.TraceLog("Committing to " + formattedRFDNumber + " ...")
+	_, err = commitFilesToBranch(r, formattedRFDNumber, parent, files, "Earmark branch", author)
+	return err
+}
+
+// pushOrQueue pushes a branch to origin, or queues it for 'rfd sync' if push is false or origin can't be
+// reached. isNew says the branch holds a new RFD that has never been pushed. A push that origin rejects,
+// such as one that isn't a fast-forward of the branch on origin, is returned as an error rather than queued.
+func pushOrQueue(r *git.Repository, branch string, push bool, isNew bool) error {
+
+	if push {
+		localConfig.Logger.TraceLog("Pushing to origin ...")
+		err := localConfig.PushBranchToOrigin(r, branch)
+		if err == nil {
+			localConfig.Logger.TraceLog("Pushed to origin")
+			return nil
+		}
+		if isRemoteReachable(r) {
+			return fmt.Errorf("origin rejected the push of %s, which has been committed locally: %v. Pull the changes made to %s on origin, then push it again", branch, err, branch)
+		}
+		fmt.Println("Unable to reach origin: " + err.Error())
+	}
+
+	err := queuePush(branch, isNew)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Branch " + branch + " has been committed locally. Run 'rfd sync' to push it when you're back online.")
+	return nil
+}
+
+// isRemoteReachable reports whether origin can be reached, to tell a push it rejected from one that never
+// got to it.
+func isRemoteReachable(r *git.Repository) bool {
+
+	publicKey, err := localConfig.GetPublicKey()
+
+	remote, err := r.Remote("origin")
+	if err != nil {
+		return false
+	}
+	_, err = remote.List(&git.ListOptions{
+		Auth: publicKey,
+	})
+	return err == nil
+}
+
+// getMaxRFDNumber returns the greatest RFD id known locally and remotely, and whether the
+// remote could be reached to find it out.
+func getMaxRFDNumber(offline bool) (int, bool, error) {
 
 	err, maxRFDBranchId := getMaxBranchId()
-	localConfig.CheckFatal(err)
+	if err != nil {
+		return 0, false, err
+	}
 	localConfig.Logger.TraceLog("Local branch max id: " + strconv.Itoa(maxRFDBranchId))
 
 	err, maxRFDDirId := getMaxDirId()
-	localConfig.CheckFatal(err)
+	if err != nil {
+		return 0, false, err
+	}
 	localConfig.Logger.TraceLog("Directory branch max id: " + strconv.Itoa(maxRFDDirId))
 
-	err, maxRemoteRFDBranchId := getMaxRemoteBranchId()
-	localConfig.CheckFatal(err)
+	reachable := false
+	maxRemoteRFDBranchId := 0
+	if !offline {
+		err, maxRemoteRFDBranchId = getMaxRemoteBranchId()
+		if err == nil {
+			reachable = true
+		} else {
+			fmt.Println("Unable to list remote branches, falling back to the last fetched remote branches: " + err.Error())
+		}
+	}
+	if !reachable {
+		err, maxRemoteRFDBranchId = getMaxRemoteTrackingBranchId()
+		if err != nil {
+			return 0, false, err
+		}
+	}
 	localConfig.Logger.TraceLog("Remote branch max id: " + strconv.Itoa(maxRemoteRFDBranchId))
 
 	maxRFDId := maxRFDBranchId
@@ -226,21 +480,25 @@ func getMaxRFDNumber() int {
 		maxRFDId = maxRemoteRFDBranchId
 	}
 
-	return maxRFDId
+	return maxRFDId, reachable, nil
 }
 
 func getMaxBranchId() (error, int) {
 
 	r, err := git.PlainOpen(".")
-	localConfig.CheckFatal(err)
+	if err != nil {
+		return err, 0
+	}
 
 	// ... retrieving the branches
 	branches, err := r.Branches()
-	localConfig.CheckFatal(err)
+	if err != nil {
+		return err, 0
+	}
 
 	var maxRFDId = 0
 
-	branches.ForEach(func(p *plumbing.Reference) error {
+	err = branches.ForEach(func(p *plumbing.Reference) error {
 		rName := p.Name()
 		name := rName.String()
 
@@ -249,7 +507,9 @@ func getMaxBranchId() (error, int) {
 
 		// A valid branch id is nnnn, e.g. 0007
 		entryIsBranchID, err := localConfig.IsRFDIDFormat(sId)
-		localConfig.CheckFatal(err)
+		if err != nil {
+			return err
+		}
 
 		if entryIsBranchID {
 			rfdId, err := strconv.Atoi(sId)
@@ -269,11 +529,16 @@ func getMaxDirId() (error, int) {
 
 	var maxRFDId = 0
 
-	entries := getDirectories()
+	entries, err := ioutil.ReadDir(localConfig.APP_CONFIG.RootDirectory)
+	if err != nil {
+		return err, 0
+	}
 	for _, entry := range entries {
 
 		entryIsBranchID, err := localConfig.IsRFDIDFormat(entry.Name())
-		localConfig.CheckFatal(err)
+		if err != nil {
+			return err, 0
+		}
 
 		if entryIsBranchID {
 
@@ -302,16 +567,22 @@ func getMaxRemoteBranchId() (error, int) {
 	var maxRemoteBranchId = 0
 
 	r, err := git.PlainOpen(".")
-	localConfig.CheckFatal(err)
+	if err != nil {
+		return err, 0
+	}
 
 	publicKey, err := localConfig.GetPublicKey()
 
 	remote, err := r.Remote("origin")
-	localConfig.CheckFatal(err)
+	if err != nil {
+		return err, 0
+	}
 	refList, err := remote.List(&git.ListOptions{
 		Auth: publicKey,
 	})
-	localConfig.CheckFatal(err)
+	if err != nil {
+		return err, 0
+	}
 
 	refPrefix := "refs/heads/"
 	for _, ref := range refList {
@@ -323,7 +594,9 @@ func getMaxRemoteBranchId() (error, int) {
 		branchName := refName[len(refPrefix):]
 
 		entryIsBranchID, err := localConfig.IsRFDIDFormat(branchName)
-		localConfig.CheckFatal(err)
+		if err != nil {
+			return err, 0
+		}
 
 		if entryIsBranchID {
 			entryId, err := strconv.Atoi(branchName)
@@ -333,5 +606,47 @@ func getMaxRemoteBranchId() (error, int) {
 		}
 	}
 
+	return nil, maxRemoteBranchId
+}
+
+// getMaxRemoteTrackingBranchId works out the greatest remote RFD branch id from the
+// remote-tracking branches recorded at the last fetch, without contacting the remote.
+func getMaxRemoteTrackingBranchId() (error, int) {
+
+	var maxRemoteBranchId = 0
+
+	r, err := git.PlainOpen(".")
+	if err != nil {
+		return err, 0
+	}
+
+	refs, err := r.References()
+	if err != nil {
+		return err, 0
+	}
+
+	refPrefix := "refs/remotes/origin/"
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+
+		refName := ref.Name().String()
+		if !strings.HasPrefix(refName, refPrefix) {
+			return nil
+		}
+		branchName := refName[len(refPrefix):]
+
+		entryIsBranchID, err := localConfig.IsRFDIDFormat(branchName)
+		if err != nil {
+			return err
+		}
+
+		if entryIsBranchID {
+			entryId, err := strconv.Atoi(branchName)
+			if (entryId > maxRemoteBranchId) && err == nil {
+				maxRemoteBranchId = entryId
+			}
+		}
+		return nil
+	})
+
 	return err, maxRemoteBranchId
 }
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	localConfig "github.com/redazzo/rfd/cmd/rfd/internal/config"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestMergeRFDCheckedOutInWorktree(t *testing.T) {
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git isn't installed")
+	}
+
+	directory, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repository := t.TempDir()
+
+	localConfig.APP_CONFIG = &localConfig.Configuration{
+		RootDirectory:      repository,
+		TemplatesDirectory: filepath.Join(directory, "..", "..", "template"),
+		Offline:            true,
+	}
+	localConfig.APP_STATES = &localConfig.States{RFDStates: []map[string]map[string]string{
+		{"state": {"id": "1", "name": "draft"}},
+		{"state": {"id": "2", "name": ACCEPTED_STATE}},
+	}}
+
+	runGit := func(args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repository
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, output)
+		}
+	}
+	runGit("init", "-q")
+	runGit("checkout", "-q", "-b", "main")
+	runGit("config", "user.name", "Alice")
+	runGit("config", "user.email", "alice@example.com")
+	for name, content := range map[string]string{
+		"readme.md":  "# RFDs\n",
+		"config.yml": "root-directory: " + repository + "\n",
+		".gitignore": "config.yml\n",
+	} {
+		err = os.WriteFile(filepath.Join(repository, name), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	runGit("add", "-A")
+	runGit("commit", "-q", "-m", "Initialise")
+
+	err = os.Chdir(repository)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(directory)
+
+	// As 'rfd new --worktree' does, for an RFD that's ready to merge
+	err = createRFDWithoutCheckout(2, "Worktrees", "Alice", ACCEPTED_STATE, "", false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(WORKTREES_DIRECTORY, "0002", "0002", "readme.md")); err != nil {
+		t.Fatalf("expected RFD 0002 to be checked out in a linked worktree: %v", err)
+	}
+
+	// State such as the push queue is shared with the linked worktree, where .git is a file
+	err = os.Chdir(filepath.Join(WORKTREES_DIRECTORY, "0002"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared, err := filepath.Abs(localConfig.GetRFDStateDirectory())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := filepath.Join(repository, ".git", "rfd"); shared != expected {
+		t.Errorf("expected the linked worktree's state in %s, got %s", expected, shared)
+	}
+	err = os.Chdir(repository)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !localConfig.CheckAndReportOnRepositoryState() {
+		t.Errorf("expected the linked worktree not to count as a change to the working tree")
+	}
+
+	err = doMerge("0002")
+	if err != nil {
+		t.Fatalf("expected RFD 0002 to merge, got %v", err)
+	}
+
+	r, err := git.PlainOpen(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	trunk := getCommit(r, plumbing.NewBranchReferenceName("main"))
+	if !commitHasDirectory(trunk, "0002") {
+		t.Errorf("expected RFD 0002 to be on the trunk")
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	localConfig "github.com/redazzo/rfd/cmd/rfd/internal/config"
+	"github.com/redazzo/rfd/cmd/rfd/internal/notify"
+	"os"
+)
+
+/*
+
+Notifying the channels configured in the notifications section of config.yml as RFDs are created, change
+state and are merged. Notifications are sent once the change has been committed, alongside the post-
+hooks, and failing to deliver them doesn't undo the change. The web UI delivers them in the background, so
+that a slow or unreachable channel doesn't hold up the repository while it's retried.
+
+*/
+
+// notifyInBackground delivers notifications from a goroutine rather than waiting for them, for the web UI,
+// which outlives the deliveries.
+var notifyInBackground = false
+
+func getNotificationLogLocation() string {
+	return localConfig.GetRFDStateDirectory() + localConfig.PATH_SEPARATOR + "notifications.log"
+}
+
+// getNotifier returns a Notifier for the configured channels, or nil if there aren't any.
+func getNotifier() (*notify.Notifier, error) {
+
+	config := localConfig.APP_CONFIG.Notifications
+	if len(config.Channels) == 0 {
+		return nil, nil
+	}
+
+	var channels []notify.Channel
+	for _, channel := range config.Channels {
+		password := ""
+		if channel.PasswordEnv != "" {
+			password = os.Getenv(channel.PasswordEnv)
+		}
+		channels = append(channels, notify.Channel{
+			Name:      channel.Name,
+			Type:      channel.Type,
+			URL:       channel.URL,
+			Events:    channel.Events,
+			Templates: channel.Templates,
+			Subject:   channel.Subject,
+			SMTPHost:  channel.SMTPHost,
+			SMTPPort:  channel.SMTPPort,
+			Username:  channel.Username,
+			Password:  password,
+			From:      channel.From,
+			To:        channel.To,
+			ToAuthors: channel.ToAuthors,
+		})
+	}
+
+	retries := config.Retries
+	if retries == 0 {
+		retries = notify.DEFAULT_RETRIES
+	}
+
+	return notify.New(channels, retries, getNotificationLogLocation())
+}
+
+// notifyEvent tells the configured channels about something that has happened to an RFD, described by
+// fields as getRFDFields returns them. author is who made the change, or nil for the configured git user.
+// Failures are reported rather than returned.
+func notifyEvent(r *git.Repository, eventType string, fields map[string]interface{}, author *object.Signature, previousState string) {
+
+	if fields == nil {
+		return
+	}
+
+	notifier, err := getNotifier()
+	if err != nil {
+		fmt.Println("Unable to send notifications: " + err.Error())
+		return
+	}
+	if notifier == nil {
+		return
+	}
+
+	event := newEvent(eventType, fields)
+	event.PreviousState = previousState
+	if author == nil {
+		author, _ = getSignature(r)
+	}
+	if author != nil {
+		event.User = author.Name
+	}
+
+	if notifyInBackground {
+		go deliverEvent(notifier, event)
+		return
+	}
+	deliverEvent(notifier, event)
+}
+
+func deliverEvent(notifier *notify.Notifier, event notify.Event) {
+
+	err := notifier.Notify(event)
+	if err != nil {
+		fmt.Println("Unable to send notifications: " + err.Error())
+	}
+}
+
+// newEvent returns an event for an RFD described by fields.
+func newEvent(eventType string, fields map[string]interface{}) notify.Event {
+	return notify.Event{
+		Type:         eventType,
+		ID:           metadataString(fields, "id"),
+		Title:        metadataString(fields, "title"),
+		Authors:      metadataString(fields, "authors"),
+		AuthorEmails: getAuthorEmails(fields),
+		State:        metadataString(fields, "state"),
+		Branch:       metadataString(fields, "branch"),
+		URL:          metadataString(fields, "discussion"),
+		Metadata:     fields,
+	}
+}
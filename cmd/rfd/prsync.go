@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	localConfig "github.com/redazzo/rfd/cmd/rfd/internal/config"
+	"github.com/redazzo/rfd/cmd/rfd/internal/forge"
+	"strconv"
+	"strings"
+)
+
+/*
+
+Bringing the status of each RFD's pull request back into the RFD:
+
+1. For each RFD that links to a pull request in its discussion: field, ask the forge for the pull
+   request's state and reviews.
+2. Record them in the RFD's metadata, committing to the RFD's branch if they've changed. RFDs found only on
+   the trunk, whose branch has gone, are left as they are.
+3. Suggest the state the RFD should be in: discussion while the pull request is open, accepted once it's
+   merged, and abandoned if it's closed without being merged. With --apply, move the RFD to it. RFDs
+   found only on the trunk have no branch to move, so the suggestion is left for changing on the trunk.
+
+*/
+
+const PR_STATE_FIELD = "pr-state"
+const PR_APPROVED_BY_FIELD = "pr-approved-by"
+const PR_CHANGES_REQUESTED_FIELD = "pr-changes-requested-by"
+const PR_COMMENTS_FIELD = "pr-comments"
+
+const ACCEPTED_STATE = "accepted"
+const ABANDONED_STATE = "abandoned"
+
+func syncPullRequests(rfdIds []string, apply bool, dryRun bool) error {
+
+	f, err := getForge()
+	if err != nil {
+		return err
+	}
+	if f == nil {
+		return fmt.Errorf("no forge is configured, see the forge section of config.yml")
+	}
+
+	r, err := git.PlainOpen(".")
+	localConfig.CheckFatal(err)
+
+	if !localConfig.APP_CONFIG.Offline {
+		fmt.Println("Fetching from origin ...")
+		err = localConfig.FetchFromOrigin(r)
+		if err != nil {
+			fmt.Println("Unable to fetch from origin, using the last fetched remote branches: " + err.Error())
+		}
+	}
+
+	trunkName := getTrunkBranchName(r)
+
+	for _, found := range collectRFDs(r) {
+
+		if len(rfdIds) > 0 && !containsString(rfdIds, found.ID) {
+			continue
+		}
+
+		discussion := metadataString(found.Metadata, "discussion")
+		if discussion == "" {
+			if len(rfdIds) > 0 {
+				fmt.Println(found.ID + "  has no discussion link")
+			}
+			continue
+		}
+
+		status, err := f.GetPullRequestStatus(discussion)
+		if err != nil {
+			fmt.Println(found.ID + "  unable to get the status of " + discussion + ": " + err.Error())
+			continue
+		}
+
+		fmt.Println(found.ID + "  " + describePullRequestStatus(status))
+
+		fields := getPullRequestFields(status)
+		if found.Branch != trunkName && !dryRun && pullRequestFieldsChanged(found.Metadata, fields) {
+			err = updateRFD(r, found.ID, func(content []byte) ([]byte, error) {
+				for _, key := range []string{PR_STATE_FIELD, PR_APPROVED_BY_FIELD, PR_CHANGES_REQUESTED_FIELD, PR_COMMENTS_FIELD} {
+					content = setFrontMatterField(content, key, fields[key])
+				}
+				return content, nil
+			}, found.ID+": Sync pull request status", nil)
+			if err != nil {
+				fmt.Println("      unable to record the pull request's status: " + err.Error())
+			}
+		}
+
+		suggested := suggestState(found.State(), status)
+		if suggested == "" {
+			continue
+		}
+
+		if found.Branch == trunkName {
+			fmt.Println("      suggest moving to " + suggested + ", but it has no branch, so change its state: field on the trunk")
+			continue
+		}
+
+		if !apply || dryRun {
+			fmt.Println("      suggest moving to " + suggested + ": rfd state " + found.ID + " " + suggested)
+			continue
+		}
+
+		err = transitionRFD(r, found.ID, suggested, nil)
+		if err != nil {
+			fmt.Println("      unable to move to " + suggested + ": " + err.Error())
+			continue
+		}
+		fmt.Println("      moved to " + suggested)
+	}
+
+	return nil
+}
+
+func describePullRequestStatus(status *forge.PullRequestStatus) string {
+
+	description := status.State
+	if len(status.ApprovedBy) > 0 {
+		description += ", approved by " + strings.Join(status.ApprovedBy, ", ")
+	}
+	if len(status.ChangesRequested) > 0 {
+		description += ", changes requested by " + strings.Join(status.ChangesRequested, ", ")
+	}
+	return description + ", " + strconv.Itoa(status.Comments) + " comments"
+}
+
+// getPullRequestFields returns the metadata recording a pull request's status, as the values to be
+// written into the front matter.
+func getPullRequestFields(status *forge.PullRequestStatus) map[string]string {
+
+	approvedBy, changesRequested := status.ApprovedBy, status.ChangesRequested
+	if approvedBy == nil {
+		approvedBy = []string{}
+	}
+	if changesRequested == nil {
+		changesRequested = []string{}
+	}
+
+	fields := make(map[string]string)
+	for key, value := range map[string]interface{}{
+		PR_STATE_FIELD:             status.State,
+		PR_APPROVED_BY_FIELD:       approvedBy,
+		PR_CHANGES_REQUESTED_FIELD: changesRequested,
+		PR_COMMENTS_FIELD:          status.Comments,
+	} {
+		fields[key], _ = formatFrontMatterValue(value)
+	}
+
+	return fields
+}
+
+func pullRequestFieldsChanged(metadata map[string]interface{}, fields map[string]string) bool {
+	for key, value := range fields {
+		existing, ok := metadata[key]
+		if !ok {
+			return true
+		}
+		encoded, err := formatFrontMatterValue(toJSONValue(existing))
+		if err != nil || encoded != value {
+			return true
+		}
+	}
+	return false
+}
+
+// suggestState returns the state an RFD should move to given its pull request's status, or "" if it's
+// where it should be.
+func suggestState(current string, status *forge.PullRequestStatus) string {
+
+	suggested := ""
+	switch status.State {
+	case forge.OPEN:
+		if current == getDefaultStatus() {
+			suggested = DISCUSSION_STATE
+		}
+	case forge.MERGED:
+		if current == getDefaultStatus() || current == DISCUSSION_STATE {
+			suggested = ACCEPTED_STATE
+		}
+	case forge.CLOSED:
+		if current == getDefaultStatus() || current == DISCUSSION_STATE {
+			suggested = ABANDONED_STATE
+		}
+	}
+
+	if suggested != "" && !isConfiguredState(suggested) {
+		return ""
+	}
+	return suggested
+}
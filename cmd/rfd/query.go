@@ -0,0 +1,380 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+/*
+
+Filter expressions select RFDs by their metadata, e.g.
+
+    state = discussion and authors ~ "Bob"
+    (state = accepted or state = committed) and tags = storage and not id < 10
+
+The grammar is:
+
+    expression := and-expression { "or" and-expression }
+    and-expression := not-expression { "and" not-expression }
+    not-expression := "not" not-expression | "(" expression ")" | comparison
+    comparison := field operator value
+
+where the operators are = (equals), != (doesn't equal), ~ (contains), !~ (doesn't contain), <, <=, >
+and >=. Values are either bare words or double quoted strings. Comparisons ignore case. Fields holding
+lists, whether as YAML lists or comma delimited, are equal to a value if any of their items is; numeric
+values, such as ids, are compared as numbers.
+
+*/
+
+type tokenKind int
+
+const (
+	TOKEN_WORD tokenKind = iota
+	TOKEN_STRING
+	TOKEN_OPERATOR
+	TOKEN_OPEN
+	TOKEN_CLOSE
+	TOKEN_END
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+}
+
+// filter is a parsed filter expression that can be matched against an RFD's fields.
+type filter interface {
+	Matches(fields map[string]interface{}) bool
+}
+
+type orFilter struct{ left, right filter }
+type andFilter struct{ left, right filter }
+type notFilter struct{ operand filter }
+type comparisonFilter struct{ field, operator, value string }
+type allFilter struct{}
+
+func (f allFilter) Matches(fields map[string]interface{}) bool {
+	return true
+}
+
+func (f orFilter) Matches(fields map[string]interface{}) bool {
+	return f.left.Matches(fields) || f.right.Matches(fields)
+}
+
+func (f andFilter) Matches(fields map[string]interface{}) bool {
+	return f.left.Matches(fields) && f.right.Matches(fields)
+}
+
+func (f notFilter) Matches(fields map[string]interface{}) bool {
+	return !f.operand.Matches(fields)
+}
+
+func (f comparisonFilter) Matches(fields map[string]interface{}) bool {
+
+	whole, items, present := fieldValues(fields, f.field)
+	value := strings.ToLower(f.value)
+
+	switch f.operator {
+	case "=":
+		return present && equalsAny(whole, items, value)
+	case "!=":
+		return !present || !equalsAny(whole, items, value)
+	case "~":
+		return present && strings.Contains(whole, value)
+	case "!~":
+		return !present || !strings.Contains(whole, value)
+	}
+
+	if !present {
+		return false
+	}
+	comparison := compareValues(whole, value)
+	switch f.operator {
+	case "<":
+		return comparison < 0
+	case "<=":
+		return comparison <= 0
+	case ">":
+		return comparison > 0
+	case ">=":
+		return comparison >= 0
+	}
+
+	return false
+}
+
+// fieldValues returns a field's value as a lower case string, the individual items of list values, and
+// whether the field is present at all.
+func fieldValues(fields map[string]interface{}, field string) (string, []string, bool) {
+
+	value, ok := fields[field]
+	if !ok || value == nil {
+		return "", nil, false
+	}
+
+	var items []string
+	switch v := value.(type) {
+	case []interface{}:
+		for _, item := range v {
+			items = append(items, strings.ToLower(strings.TrimSpace(fmt.Sprintf("%v", item))))
+		}
+		return strings.Join(items, ", "), items, true
+	default:
+		whole := strings.ToLower(strings.TrimSpace(fmt.Sprintf("%v", v)))
+		for _, item := range strings.Split(whole, ",") {
+			items = append(items, strings.TrimSpace(item))
+		}
+		return whole, items, true
+	}
+}
+
+func equalsAny(whole string, items []string, value string) bool {
+	if compareValues(whole, value) == 0 {
+		return true
+	}
+	for _, item := range items {
+		if compareValues(item, value) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// compareValues compares two values numerically if both are numbers, and as strings otherwise.
+func compareValues(a string, b string) int {
+
+	aNumber, aErr := strconv.ParseFloat(a, 64)
+	bNumber, bErr := strconv.ParseFloat(b, 64)
+	if aErr == nil && bErr == nil {
+		switch {
+		case aNumber < bNumber:
+			return -1
+		case aNumber > bNumber:
+			return 1
+		}
+		return 0
+	}
+
+	return strings.Compare(a, b)
+}
+
+// parseFilter parses a filter expression. An empty expression matches everything.
+func parseFilter(expression string) (filter, error) {
+
+	tokens, err := tokenise(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tokens) == 1 {
+		return allFilter{}, nil
+	}
+
+	p := &filterParser{tokens: tokens}
+	result, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.peek().kind != TOKEN_END {
+		return nil, fmt.Errorf("unexpected %q in filter", p.peek().value)
+	}
+
+	return result, nil
+}
+
+type filterParser struct {
+	tokens   []token
+	position int
+}
+
+func (p *filterParser) peek() token {
+	return p.tokens[p.position]
+}
+
+func (p *filterParser) next() token {
+	t := p.tokens[p.position]
+	if t.kind != TOKEN_END {
+		p.position++
+	}
+	return t
+}
+
+func (p *filterParser) isKeyword(keyword string) bool {
+	t := p.peek()
+	return t.kind == TOKEN_WORD && strings.EqualFold(t.value, keyword)
+}
+
+func (p *filterParser) parseOr() (filter, error) {
+
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isKeyword("or") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orFilter{left, right}
+	}
+
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (filter, error) {
+
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isKeyword("and") {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = andFilter{left, right}
+	}
+
+	return left, nil
+}
+
+func (p *filterParser) parseNot() (filter, error) {
+
+	if p.isKeyword("not") {
+		p.next()
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notFilter{operand}, nil
+	}
+
+	if p.peek().kind == TOKEN_OPEN {
+		p.next()
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next().kind != TOKEN_CLOSE {
+			return nil, fmt.Errorf("missing ) in filter")
+		}
+		return inner, nil
+	}
+
+	return p.parseComparison()
+}
+
+func (p *filterParser) parseComparison() (filter, error) {
+
+	field := p.next()
+	if field.kind != TOKEN_WORD {
+		return nil, fmt.Errorf("expected a field name in filter, found %q", field.value)
+	}
+
+	operator := p.next()
+	if operator.kind != TOKEN_OPERATOR {
+		return nil, fmt.Errorf("expected an operator after %q in filter", field.value)
+	}
+
+	value := p.next()
+	if value.kind != TOKEN_WORD && value.kind != TOKEN_STRING {
+		return nil, fmt.Errorf("expected a value after %q in filter", field.value+" "+operator.value)
+	}
+
+	return comparisonFilter{strings.ToLower(field.value), operator.value, value.value}, nil
+}
+
+func tokenise(expression string) ([]token, error) {
+
+	var tokens []token
+	runes := []rune(expression)
+
+	for i := 0; i < len(runes); {
+
+		c := runes[i]
+		switch {
+		case unicode.IsSpace(c):
+			i++
+
+		case c == '(':
+			tokens = append(tokens, token{TOKEN_OPEN, "("})
+			i++
+
+		case c == ')':
+			tokens = append(tokens, token{TOKEN_CLOSE, ")"})
+			i++
+
+		case c == '"':
+			var value strings.Builder
+			i++
+			for i < len(runes) && runes[i] != '"' {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				value.WriteRune(runes[i])
+				i++
+			}
+			if i >= len(runes) {
+				return nil, fmt.Errorf("unterminated string in filter")
+			}
+			i++
+			tokens = append(tokens, token{TOKEN_STRING, value.String()})
+
+		case strings.ContainsRune("=!~<>", c):
+			operator := string(c)
+			if i+1 < len(runes) && (runes[i+1] == '=' || (c == '!' && runes[i+1] == '~')) {
+				operator += string(runes[i+1])
+			}
+			if operator == "!" {
+				return nil, fmt.Errorf("unknown operator ! in filter")
+			}
+			tokens = append(tokens, token{TOKEN_OPERATOR, operator})
+			i += len(operator)
+
+		default:
+			start := i
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && !strings.ContainsRune("()\"=!~<>", runes[i]) {
+				i++
+			}
+			tokens = append(tokens, token{TOKEN_WORD, string(runes[start:i])})
+		}
+	}
+
+	return append(tokens, token{TOKEN_END, "end of filter"}), nil
+}
+
+// sortByFields sorts records by a comma delimited list of fields, each optionally prefixed with - to
+// sort in descending order.
+func sortByFields(records []map[string]interface{}, sortKeys string) {
+
+	keys := strings.Split(sortKeys, ",")
+
+	sort.SliceStable(records, func(i, j int) bool {
+		for _, key := range keys {
+
+			key = strings.TrimSpace(key)
+			descending := strings.HasPrefix(key, "-")
+			key = strings.ToLower(strings.TrimPrefix(key, "-"))
+			if key == "" {
+				continue
+			}
+
+			a, _, _ := fieldValues(records[i], key)
+			b, _, _ := fieldValues(records[j], key)
+
+			comparison := compareValues(a, b)
+			if comparison != 0 {
+				return (comparison < 0) != descending
+			}
+		}
+		return false
+	})
+}
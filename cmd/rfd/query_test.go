@@ -0,0 +1,88 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestFilterMatches(t *testing.T) {
+
+	fields := map[string]interface{}{
+		"id":      "0042",
+		"title":   "Storage for the RFD tool",
+		"authors": "Bob the Builder <bob@thebuilder.co>, Alice",
+		"state":   "discussion",
+		"tags":    []interface{}{"storage", "databases"},
+	}
+
+	tests := []struct {
+		expression string
+		expected   bool
+	}{
+		{"", true},
+		{"state = discussion", true},
+		{"state = Discussion", true},
+		{"state != discussion", false},
+		{`state = discussion and authors ~ "Bob"`, true},
+		{`state = discussion and authors ~ "Carol"`, false},
+		{"authors = alice", true},
+		{"tags = storage", true},
+		{"tags = stor", false},
+		{"tags ~ stor", true},
+		{"tags !~ network", true},
+		{"id = 42", true},
+		{"id > 9 and id <= 42", true},
+		{"id < 42", false},
+		{"state = accepted or state = discussion", true},
+		{"not (state = accepted or state = committed)", true},
+		{"discussion = anything", false},
+		{"discussion != anything", true},
+		{`title = "Storage for the RFD tool"`, true},
+	}
+
+	for _, test := range tests {
+
+		f, err := parseFilter(test.expression)
+		if err != nil {
+			t.Errorf("Error parsing %q: %s", test.expression, err)
+			continue
+		}
+
+		if f.Matches(fields) != test.expected {
+			t.Errorf("Expected %q to be %v", test.expression, test.expected)
+		}
+	}
+}
+
+func TestFilterErrors(t *testing.T) {
+
+	for _, expression := range []string{
+		"state =",
+		"state discussion",
+		"(state = draft",
+		`title = "unterminated`,
+		"state = draft or",
+		"state ! draft",
+	} {
+		if _, err := parseFilter(expression); err == nil {
+			t.Errorf("Expected an error parsing %q", expression)
+		}
+	}
+}
+
+func TestSortByFields(t *testing.T) {
+
+	records := []map[string]interface{}{
+		{"id": "0002", "state": "draft"},
+		{"id": "0010", "state": "accepted"},
+		{"id": "0001", "state": "draft"},
+	}
+
+	sortByFields(records, "state,-id")
+
+	expected := []string{"0010", "0002", "0001"}
+	for i, id := range expected {
+		if records[i]["id"] != id {
+			t.Errorf("Expected %s at position %d, found %s", id, i, records[i]["id"])
+		}
+	}
+}
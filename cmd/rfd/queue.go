@@ -0,0 +1,113 @@
+package main
+
+import (
+	"github.com/redazzo/rfd/cmd/rfd/internal/config"
+	"os"
+	"strings"
+)
+
+/*
+
+Branches that couldn't be pushed when they were committed (because we were offline, the remote couldn't
+be reached, or the user asked us not to push) are recorded one per line in .git/rfd/push-queue. "rfd
+sync" works through the queue, pushes each branch, and removes it from the queue once pushed.
+
+A branch holding a new RFD that has never been pushed is recorded as "<branch> new". Only those are
+renumbered by "rfd sync" if their id has been taken in the meantime; any other branch is an existing
+RFD, which keeps its id.
+
+*/
+
+const NEW_RFD_QUEUE_MARKER = "new"
+
+// queuedPush is a branch waiting to be pushed by 'rfd sync'.
+type queuedPush struct {
+	Branch string
+	New    bool
+}
+
+func getPushQueueLocation() string {
+	return config.GetRFDStateDirectory() + config.PATH_SEPARATOR + "push-queue"
+}
+
+func readQueuedPushes() ([]queuedPush, error) {
+
+	var pushes []queuedPush
+
+	content, err := os.ReadFile(getPushQueueLocation())
+	if os.IsNotExist(err) {
+		return pushes, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		pushes = append(pushes, queuedPush{
+			Branch: fields[0],
+			New:    len(fields) > 1 && fields[1] == NEW_RFD_QUEUE_MARKER,
+		})
+	}
+
+	return pushes, nil
+}
+
+// readPushQueue returns the names of the branches waiting to be pushed.
+func readPushQueue() ([]string, error) {
+
+	pushes, err := readQueuedPushes()
+	if err != nil {
+		return nil, err
+	}
+
+	var branches []string
+	for _, push := range pushes {
+		branches = append(branches, push.Branch)
+	}
+	return branches, nil
+}
+
+func writePushQueue(pushes []queuedPush) error {
+
+	err := os.MkdirAll(config.GetRFDStateDirectory(), 0755)
+	if err != nil {
+		return err
+	}
+
+	content := ""
+	for _, push := range pushes {
+		content += push.Branch
+		if push.New {
+			content += " " + NEW_RFD_QUEUE_MARKER
+		}
+		content += "\n"
+	}
+
+	return os.WriteFile(getPushQueueLocation(), []byte(content), 0644)
+}
+
+// queuePush queues a branch to be pushed by 'rfd sync'. A branch queued as a new RFD stays one until it's
+// been pushed, even if it's changed and queued again.
+func queuePush(branch string, isNew bool) error {
+
+	pushes, err := readQueuedPushes()
+	if err != nil {
+		return err
+	}
+
+	for i, queued := range pushes {
+		if queued.Branch == branch {
+			if isNew && !queued.New {
+				pushes[i].New = true
+				return writePushQueue(pushes)
+			}
+			return nil
+		}
+	}
+
+	return writePushQueue(append(pushes, queuedPush{Branch: branch, New: isNew}))
+}
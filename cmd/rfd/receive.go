@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/go-git/go-billy/v5/helper/mount"
+	"github.com/go-git/go-billy/v5/helper/polyfill"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/go-git/go-git/v5/storage/filesystem/dotgit"
+	localConfig "github.com/redazzo/rfd/cmd/rfd/internal/config"
+	"io"
+	"os"
+	"strings"
+)
+
+/*
+
+Enforcing the rules on RFDs centrally, as a pre-receive hook of a self-hosted git server:
+
+1. Open the bare repository git runs the hook in. The objects being pushed are kept in a quarantine
+   directory until the hook accepts them, so they're looked for there as well.
+2. Read the refs being updated from stdin, a line of <old hash> <new hash> <ref> for each.
+3. For an RFD branch, check its RFD as pushed: it must have a readme in the directory named after the
+   branch, pass 'rfd lint', which also checks its id is the branch's name, and be able to get to its
+   state from its state before the push, or on the trunk if the branch is new, by the moves states.yml
+   allows.
+4. For the trunk, check each RFD whose readme the push changes in the same way, against its state on the
+   trunk before the push.
+5. Print the problems, which git passes on to whoever pushed, and refuse the whole push if there are
+   errors. Deleting a ref isn't checked.
+
+config.yml is read from the bare repository's directory, as it is from the root of a working tree.
+
+*/
+
+// quarantinedStorage is a repository's storage that also finds the objects of a push not yet accepted.
+type quarantinedStorage struct {
+	*filesystem.Storage
+	incoming *filesystem.ObjectStorage
+}
+
+func (s *quarantinedStorage) EncodedObject(objectType plumbing.ObjectType, hash plumbing.Hash) (plumbing.EncodedObject, error) {
+	obj, err := s.incoming.EncodedObject(objectType, hash)
+	if err == plumbing.ErrObjectNotFound {
+		return s.Storage.EncodedObject(objectType, hash)
+	}
+	return obj, err
+}
+
+func (s *quarantinedStorage) HasEncodedObject(hash plumbing.Hash) error {
+	if s.incoming.HasEncodedObject(hash) == nil {
+		return nil
+	}
+	return s.Storage.HasEncodedObject(hash)
+}
+
+// openReceivingRepository opens the bare repository a pre-receive hook is run in, including the objects
+// git has quarantined in GIT_QUARANTINE_PATH while the hook decides on them.
+func openReceivingRepository() (*git.Repository, error) {
+
+	directory := os.Getenv("GIT_DIR")
+	if directory == "" {
+		directory = "."
+	}
+
+	objectCache := cache.NewObjectLRUDefault()
+	storage := filesystem.NewStorage(osfs.New(directory), objectCache)
+
+	quarantine := os.Getenv("GIT_QUARANTINE_PATH")
+	if quarantine == "" {
+		return git.Open(storage, nil)
+	}
+
+	// The quarantine directory is laid out as an objects directory, so it's mounted as one
+	incoming := polyfill.New(mount.New(memfs.New(), "objects", osfs.New(quarantine)))
+	return git.Open(&quarantinedStorage{
+		Storage:  storage,
+		incoming: filesystem.NewObjectStorage(dotgit.New(incoming), objectCache),
+	}, nil)
+}
+
+// runPreReceiveHook checks the ref updates git is about to make, read as lines of
+// <old hash> <new hash> <ref>, returning whether the push should be refused.
+func runPreReceiveHook(input io.Reader) (bool, error) {
+
+	r, err := openReceivingRepository()
+	if err != nil {
+		return false, err
+	}
+
+	trunkName := getTrunkBranchName(r)
+	if head, err := r.Reference(plumbing.HEAD, false); err == nil && head.Type() == plumbing.SymbolicReference {
+		trunkName = head.Target().Short()
+	}
+
+	type refUpdate struct {
+		old  plumbing.Hash
+		new  plumbing.Hash
+		name plumbing.ReferenceName
+	}
+	var updates []refUpdate
+
+	knownIds := make(map[string]bool)
+	for _, found := range collectRFDs(r) {
+		knownIds[found.ID] = true
+	}
+
+	scanner := bufio.NewScanner(input)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 {
+			continue
+		}
+		update := refUpdate{old: plumbing.NewHash(fields[0]), new: plumbing.NewHash(fields[1]), name: plumbing.ReferenceName(fields[2])}
+		if update.new.IsZero() || !update.name.IsBranch() {
+			continue
+		}
+		if isRFDBranch, _ := localConfig.IsRFDIDFormat(update.name.Short()); isRFDBranch {
+			knownIds[update.name.Short()] = true
+		}
+		updates = append(updates, update)
+	}
+	if scanner.Err() != nil {
+		return false, scanner.Err()
+	}
+
+	trunk := getCommit(r, plumbing.NewBranchReferenceName(trunkName))
+
+	refused := false
+	for _, update := range updates {
+
+		commit, err := r.CommitObject(update.new)
+		if err != nil {
+			return false, err
+		}
+		var previous *object.Commit
+		if !update.old.IsZero() {
+			previous, err = r.CommitObject(update.old)
+			if err != nil {
+				return false, err
+			}
+		}
+
+		branch := update.name.Short()
+		var problems []lintProblem
+
+		if branch == trunkName {
+			problems, err = checkReceivedTrunk(previous, commit, trunkName, knownIds)
+			if err != nil {
+				return false, err
+			}
+		} else if isRFDBranch, _ := localConfig.IsRFDIDFormat(branch); isRFDBranch {
+			if previous == nil {
+				previous = trunk
+			}
+			found := readRFDFromCommit(commit, branch, branch)
+			if found == nil {
+				problems = []lintProblem{getMissingRFDProblem(branch, commit, previous)}
+			} else {
+				problems = checkReceivedRFD(found, previous, knownIds)
+			}
+		}
+
+		if len(problems) == 0 {
+			continue
+		}
+		if countErrors(problems) > 0 {
+			refused = true
+			fmt.Println("Refusing the push to " + branch + ":")
+		} else {
+			fmt.Println("Warnings for the push to " + branch + ":")
+		}
+		err = writeProblems(problems, lintRules, "text")
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return refused, nil
+}
+
+// checkReceivedTrunk returns the problems with the RFDs whose readmes are changed by moving the trunk from
+// previous, which is nil if the trunk is new, to commit.
+func checkReceivedTrunk(previous *object.Commit, commit *object.Commit, trunkName string, knownIds map[string]bool) ([]lintProblem, error) {
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	previousTree := &object.Tree{}
+	if previous != nil {
+		previousTree, err = previous.Tree()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	changes, err := previousTree.Diff(tree)
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []lintProblem
+	for _, change := range changes {
+		rfdId, isReadme := getReadmeRFDId(change.To.Name)
+		if !isReadme {
+			continue
+		}
+		found := readRFDFromCommit(commit, rfdId, trunkName)
+		if found == nil || found.Path != change.To.Name {
+			continue
+		}
+		problems = append(problems, checkReceivedRFD(found, previous, knownIds)...)
+	}
+
+	return problems, nil
+}
+
+// getMissingRFDProblem returns the problem with a push to an RFD branch that has no readme for the branch's
+// RFD, naming any RFDs the push adds instead.
+func getMissingRFDProblem(branch string, commit *object.Commit, previous *object.Commit) lintProblem {
+
+	message := "branch " + branch + " has no " + branch + "/readme.md"
+	if added := getAddedRFDs(commit, previous); len(added) > 0 {
+		message += ", but adds " + strings.Join(added, ", ") + "/readme.md. An RFD's branch is named after its id"
+	}
+
+	return lintProblem{
+		Rule:     "id-mismatch",
+		Severity: LINT_ERROR,
+		ID:       branch,
+		Path:     branch + "/readme.md",
+		Line:     1,
+		Message:  message,
+	}
+}
+
+// checkReceivedRFD returns the problems with an RFD as pushed, which can move from its state in previous by
+// the moves states.yml allows. found is nil if the push has no readme for the RFD, and previous nil if there
+// was nothing before the push.
+func checkReceivedRFD(found *rfd, previous *object.Commit, knownIds map[string]bool) []lintProblem {
+
+	if found == nil {
+		return nil
+	}
+
+	problems := lintReadme(found.ID, found.Path, found.Content, newTreeLinkChecker(found.Commit, knownIds))
+
+	if previous == nil {
+		return problems
+	}
+	return append(problems, checkStateReachable(found, readRFDFromCommit(previous, found.ID, ""))...)
+}
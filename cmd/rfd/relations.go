@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	localConfig "github.com/redazzo/rfd/cmd/rfd/internal/config"
+	"strings"
+)
+
+/*
+
+Relationships between RFDs, given in the metadata header by the ids of the RFDs related, comma delimited
+or as a YAML list:
+
+    ---
+    id: 0042
+    supersedes: 0017
+    depends-on: 0031, 0035
+    related: [0040]
+    parent: 0012
+    ---
+
+'rfd lint' checks the related RFDs exist. 'rfd status' shows an RFD's relations, along with those of other
+RFDs that point to it, and the index lists them.
+
+'rfd supersede <old> <new>' records that one RFD replaces another in both, and moves the old RFD to the
+superseded-state set in config.yml, abandoned unless it says otherwise.
+
+*/
+
+const SUPERSEDES_RELATION = "supersedes"
+const SUPERSEDED_BY_RELATION = "superseded-by"
+const DEPENDS_ON_RELATION = "depends-on"
+const RELATED_RELATION = "related"
+const PARENT_RELATION = "parent"
+
+const DEFAULT_SUPERSEDED_STATE = "abandoned"
+
+var relationFields = []string{SUPERSEDES_RELATION, SUPERSEDED_BY_RELATION, DEPENDS_ON_RELATION, RELATED_RELATION, PARENT_RELATION}
+
+// inverseRelations names each relation as it's seen from the RFD it points to.
+var inverseRelations = map[string]string{
+	SUPERSEDES_RELATION:    SUPERSEDED_BY_RELATION,
+	SUPERSEDED_BY_RELATION: SUPERSEDES_RELATION,
+	DEPENDS_ON_RELATION:    "depended-on-by",
+	RELATED_RELATION:       RELATED_RELATION,
+	PARENT_RELATION:        "child",
+}
+
+// relation is an RFD's relationship to another.
+type relation struct {
+	Type string
+	ID   string
+}
+
+// getRelations returns the relations declared in the metadata header of an RFD readme, ignoring ids that
+// aren't valid.
+func getRelations(content []byte) []relation {
+
+	var relations []relation
+	for _, field := range relationFields {
+		for _, value := range readFrontMatterList(content, field) {
+			if id, err := parseRFDId(value); err == nil {
+				relations = append(relations, relation{Type: field, ID: id})
+			}
+		}
+	}
+	return relations
+}
+
+// getAllRelations returns an RFD's relations, followed by the relations of the other RFDs that point to it
+// named as they're seen from the RFD.
+func getAllRelations(found *rfd, rfds []*rfd) []relation {
+
+	relations := getRelations(found.Content)
+	seen := make(map[relation]bool)
+	for _, declared := range relations {
+		seen[declared] = true
+	}
+
+	for _, other := range rfds {
+		if other.ID == found.ID {
+			continue
+		}
+		for _, declared := range getRelations(other.Content) {
+			inverse := relation{Type: inverseRelations[declared.Type], ID: other.ID}
+			if declared.ID == found.ID && !seen[inverse] {
+				seen[inverse] = true
+				relations = append(relations, inverse)
+			}
+		}
+	}
+
+	return relations
+}
+
+// describeRelation describes a relation for people, e.g. "depends on 0031".
+func describeRelation(related relation) string {
+	return strings.ReplaceAll(related.Type, "-", " ") + " " + related.ID
+}
+
+func getSupersededState() string {
+	if localConfig.APP_CONFIG.SupersededState != "" {
+		return localConfig.APP_CONFIG.SupersededState
+	}
+	return DEFAULT_SUPERSEDED_STATE
+}
+
+func supersedeRFD(oldId string, newId string) error {
+
+	if oldId == newId {
+		return fmt.Errorf("an RFD can't supersede itself")
+	}
+
+	state := getSupersededState()
+	if !isConfiguredState(state) {
+		return fmt.Errorf("the superseded-state in config.yml, %q, isn't a configured state, expected one of: %s", state, strings.Join(getStateNames(), ", "))
+	}
+
+	r := openRepositoryToChange(oldId)
+
+	var old, replacement *rfd
+	for _, found := range collectRFDs(r) {
+		switch found.ID {
+		case oldId:
+			old = found
+		case newId:
+			replacement = found
+		}
+	}
+	if old == nil {
+		return fmt.Errorf("RFD %s wasn't found locally, on the remote, or on the trunk", oldId)
+	}
+	if replacement == nil {
+		return fmt.Errorf("RFD %s wasn't found locally, on the remote, or on the trunk", newId)
+	}
+
+	// Refuse before changing either RFD if the old one can't be moved
+	if old.State() != state && !isAllowedTransition(old.State(), state) {
+		return fmt.Errorf("RFD %s can't move from %s to %s, states.yml only allows it to move to: %s", oldId, old.State(), state, strings.Join(getNextStates(old.State()), ", "))
+	}
+
+	if !hasRelation(replacement, relation{Type: SUPERSEDES_RELATION, ID: oldId}) {
+		err := addRelation(r, newId, SUPERSEDES_RELATION, oldId, newId+": Supersede "+oldId)
+		if err != nil {
+			return err
+		}
+	}
+	if !hasRelation(old, relation{Type: SUPERSEDED_BY_RELATION, ID: newId}) {
+		err := addRelation(r, oldId, SUPERSEDED_BY_RELATION, newId, oldId+": Superseded by "+newId)
+		if err != nil {
+			return err
+		}
+	}
+
+	if old.State() != state {
+		err := transitionRFD(r, oldId, state, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Println("RFD " + newId + " now supersedes RFD " + oldId + ", which is in the " + state + " state")
+	return nil
+}
+
+func hasRelation(found *rfd, related relation) bool {
+	for _, declared := range getRelations(found.Content) {
+		if declared == related {
+			return true
+		}
+	}
+	return false
+}
+
+// addRelation adds a relation to an RFD's metadata, committing it to the RFD's branch.
+func addRelation(r *git.Repository, rfdId string, field string, relatedId string, message string) error {
+
+	return updateRFD(r, rfdId, func(content []byte) ([]byte, error) {
+		value := strings.Join(append(readFrontMatterList(content, field), relatedId), ", ")
+		return setFrontMatterField(content, field, value), nil
+	}, message, nil)
+}
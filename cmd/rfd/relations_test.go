@@ -0,0 +1,34 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGetAllRelations(t *testing.T) {
+
+	rfds := []*rfd{
+		{ID: "0002", Content: []byte("---\nid: 0002\nsupersedes: 0001\ndepends-on: 0003, 4\nrelated: [0005, nope]\n---\n")},
+		{ID: "0003", Content: []byte("---\nid: 0003\nparent: 0002\n---\n")},
+		{ID: "0005", Content: []byte("---\nid: 0005\nrelated: 0002\n---\n")},
+	}
+
+	expected := []relation{
+		{SUPERSEDES_RELATION, "0001"},
+		{DEPENDS_ON_RELATION, "0003"},
+		{DEPENDS_ON_RELATION, "0004"},
+		{RELATED_RELATION, "0005"},
+		{"child", "0003"},
+	}
+	if relations := getAllRelations(rfds[0], rfds); !reflect.DeepEqual(relations, expected) {
+		t.Errorf("expected %v, got %v", expected, relations)
+	}
+
+	expected = []relation{
+		{PARENT_RELATION, "0002"},
+		{"depended-on-by", "0002"},
+	}
+	if relations := getAllRelations(rfds[1], rfds); !reflect.DeepEqual(relations, expected) {
+		t.Errorf("expected %v, got %v", expected, relations)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	meta "github.com/yuin/goldmark-meta"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+/*
+
+Rendering RFDs to HTML, for the static site and the web UI. Both lay RFDs out the same way:
+
+    index.html              the index of all RFDs
+    nnnn/index.html         an RFD, with its attachments alongside it
+    states/<state>.html     the RFDs in a state
+    authors/<author>.html   the RFDs by an author
+
+Links from one RFD to another (e.g. ../0003/readme.md, or 0003/) are rewritten to point to the other
+RFD's page, and links to index.md to the index page.
+
+*/
+
+var rfdIdContextKey = parser.NewContextKey()
+
+var rfdLinkPattern = regexp.MustCompile(`^(\d{4})(/(?i:readme\.md)?)?$`)
+
+var markdownRenderer = goldmark.New(
+	goldmark.WithExtensions(
+		meta.Meta,
+		extension.GFM,
+	),
+	goldmark.WithParserOptions(
+		parser.WithAutoHeadingID(),
+		parser.WithASTTransformers(util.Prioritized(&rfdLinkTransformer{}, 100)),
+	),
+)
+
+// renderRFD renders the markdown of an RFD's readme to HTML, returning it along with its metadata.
+func renderRFD(id string, content []byte) (template.HTML, map[string]interface{}, error) {
+
+	context := parser.NewContext()
+	context.Set(rfdIdContextKey, id)
+
+	var buf bytes.Buffer
+	err := markdownRenderer.Convert(content, &buf, parser.WithContext(context))
+	if err != nil {
+		return "", nil, err
+	}
+
+	return template.HTML(buf.String()), meta.Get(context), nil
+}
+
+type rfdLinkTransformer struct{}
+
+func (t *rfdLinkTransformer) Transform(document *ast.Document, reader text.Reader, context parser.Context) {
+
+	id, _ := context.Get(rfdIdContextKey).(string)
+
+	ast.Walk(document, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if link, ok := node.(*ast.Link); ok && entering {
+			link.Destination = []byte(rewriteRFDLink(id, string(link.Destination)))
+		}
+		return ast.WalkContinue, nil
+	})
+}
+
+// rewriteRFDLink rewrites a link found in RFD id that points at another RFD, or at the index, to the
+// page rendered for it. Other links are left alone.
+func rewriteRFDLink(id string, destination string) string {
+
+	parsed, err := url.Parse(destination)
+	if err != nil || parsed.Scheme != "" || parsed.Host != "" || parsed.Path == "" {
+		return destination
+	}
+
+	target := parsed.Path
+	if strings.HasPrefix(target, "/") {
+		target = strings.TrimPrefix(target, "/")
+	} else {
+		target = path.Join(id, target)
+	}
+	if strings.HasSuffix(parsed.Path, "/") {
+		target += "/"
+	}
+
+	fragment := ""
+	if parsed.Fragment != "" {
+		fragment = "#" + parsed.Fragment
+	}
+
+	if strings.EqualFold(target, "index.md") {
+		return "../index.html" + fragment
+	}
+
+	match := rfdLinkPattern.FindStringSubmatch(target)
+	if match == nil {
+		return destination
+	}
+
+	return "../" + match[1] + "/index.html" + fragment
+}
+
+var emailPattern = regexp.MustCompile(`<[^>]*>`)
+
+// getAuthorNames splits an RFD's authors, given either as a YAML list or comma delimited, into
+// individual names, dropping email addresses.
+func getAuthorNames(metadata map[string]interface{}) []string {
+
+	var authors []string
+	switch value := metadata["authors"].(type) {
+	case nil:
+	case []interface{}:
+		for _, item := range value {
+			authors = append(authors, fmt.Sprintf("%v", item))
+		}
+	default:
+		authors = strings.Split(fmt.Sprintf("%v", value), ",")
+	}
+
+	var names []string
+	for _, author := range authors {
+		name := strings.TrimSpace(emailPattern.ReplaceAllString(author, ""))
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// getAuthorEmails returns the email addresses given with an RFD's authors.
+func getAuthorEmails(metadata map[string]interface{}) []string {
+
+	var emails []string
+	for _, match := range emailPattern.FindAllString(metadataString(metadata, "authors"), -1) {
+		email := strings.TrimSpace(strings.Trim(match, "<>"))
+		if email != "" {
+			emails = append(emails, email)
+		}
+	}
+
+	return emails
+}
+
+var slugPattern = regexp.MustCompile(`[^a-z0-9]+`)
+
+// slugify turns a name into something safe to use as a file name or URL path segment.
+func slugify(name string) string {
+	slug := strings.Trim(slugPattern.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	if slug == "" {
+		slug = "unknown"
+	}
+	return slug
+}
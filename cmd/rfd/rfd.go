@@ -7,6 +7,7 @@ import (
 	"github.com/urfave/cli/v2"
 	"os"
 	"runtime"
+	"strings"
 )
 
 func main() {
@@ -43,13 +44,39 @@ func createCommandLineApp() *cli.App {
 			{
 				Name:  "new",
 				Usage: "Create a new rfd",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "offline",
+						Usage: "Don't contact the remote. Allocate the id from the last fetched remote branches, and queue the push for 'rfd sync'.",
+					},
+					&cli.BoolFlag{
+						Name:  "no-push",
+						Usage: "Commit the new RFD locally, and queue the push for 'rfd sync'.",
+					},
+					&cli.BoolFlag{
+						Name:  "no-checkout",
+						Usage: "Create the RFD's branch without switching to it, leaving the working tree as it is.",
+					},
+					&cli.BoolFlag{
+						Name:  "worktree",
+						Usage: "Check the RFD's branch out into a linked worktree under " + WORKTREES_DIRECTORY + ", leaving the working tree as it is.",
+					},
+				},
 				Action: func(c *cli.Context) error {
-					if config.CheckAndReportOnRepositoryState() {
+					checkoutMode := CHECKOUT_IN_PLACE
+					if c.Bool("worktree") {
+						checkoutMode = CHECKOUT_WORKTREE
+					} else if c.Bool("no-checkout") {
+						checkoutMode = CHECKOUT_NONE
+					}
+
+					if checkoutMode != CHECKOUT_IN_PLACE || config.CheckAndReportOnRepositoryState() {
 						config.Configure()
 						config.PostConfigure()
-						new()
+						new(c.Bool("offline") || config.APP_CONFIG.Offline, c.Bool("no-push"), checkoutMode)
 					} else {
-						fmt.Println("Creating a new RFD creates and switches to new branch. Commit (or otherwise) unstaged and/or uncommitted work first.")
+						fmt.Println("Creating a new RFD creates and switches to new branch. Commit (or otherwise) unstaged and/or uncommitted work first,")
+						fmt.Println("or use --no-checkout or --worktree to leave the working tree as it is.")
 						fmt.Println()
 					}
 					return nil
@@ -66,6 +93,556 @@ func createCommandLineApp() *cli.App {
 					return nil
 				},
 			},
+			{
+				Name:  "sync",
+				Usage: "Fetch from the remote, push RFD branches queued while offline, fast-forward RFD branches, and regenerate the index.",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "prune",
+						Usage: "Delete local RFD branches that have been merged, and whose remote branch has been deleted.",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					config.Configure()
+					config.PostConfigure()
+					doSync(c.Bool("prune"))
+					return nil
+				},
+			},
+			{
+				Name:      "checkout",
+				Usage:     "Switch to an RFD's branch, finding it locally, on the remote, or merged on the trunk.",
+				ArgsUsage: "<id>",
+				Action: func(c *cli.Context) error {
+					if !config.CheckAndReportOnRepositoryState() {
+						fmt.Println("Checking out an RFD switches branch. Commit (or otherwise) unstaged and/or uncommitted work first.")
+						fmt.Println()
+						return nil
+					}
+					config.Configure()
+					config.PostConfigure()
+					rfdId, err := parseRFDId(c.Args().First())
+					if err != nil {
+						return err
+					}
+					return checkoutRFD(rfdId)
+				},
+			},
+			{
+				Name:      "edit",
+				Usage:     "Check out an RFD, open its readme.md in $EDITOR, and offer to commit and push the changes.",
+				ArgsUsage: "<id>",
+				Action: func(c *cli.Context) error {
+					if !config.CheckAndReportOnRepositoryState() {
+						fmt.Println("Editing an RFD switches branch. Commit (or otherwise) unstaged and/or uncommitted work first.")
+						fmt.Println()
+						return nil
+					}
+					config.Configure()
+					config.PostConfigure()
+					rfdId, err := parseRFDId(c.Args().First())
+					if err != nil {
+						return err
+					}
+					return editRFD(rfdId)
+				},
+			},
+			{
+				Name:      "state",
+				Usage:     "Move an RFD to another state, committing and pushing the change to its branch without switching to it.",
+				ArgsUsage: "<id> <state>",
+				Action: func(c *cli.Context) error {
+					config.Configure()
+					config.PostConfigure()
+					rfdId, err := parseRFDId(c.Args().Get(0))
+					if err != nil {
+						return err
+					}
+					if c.Args().Len() != 2 {
+						return fmt.Errorf("expected an RFD id and a state")
+					}
+					return setRFDState(rfdId, c.Args().Get(1))
+				},
+			},
+			{
+				Name:      "supersede",
+				Usage:     "Record that one RFD supersedes another in both, and move the old RFD to the superseded-state in config.yml.",
+				ArgsUsage: "<old id> <new id>",
+				Action: func(c *cli.Context) error {
+					config.Configure()
+					config.PostConfigure()
+					if c.Args().Len() != 2 {
+						return fmt.Errorf("expected the id of the RFD being superseded, and of the RFD superseding it")
+					}
+					oldId, err := parseRFDId(c.Args().Get(0))
+					if err != nil {
+						return err
+					}
+					newId, err := parseRFDId(c.Args().Get(1))
+					if err != nil {
+						return err
+					}
+					return supersedeRFD(oldId, newId)
+				},
+			},
+			{
+				Name:      "approve",
+				Usage:     "Approve an RFD, recording your approval in its metadata and committing it to the RFD's branch.",
+				ArgsUsage: "<id>",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "withdraw",
+						Usage: "Withdraw your approval.",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					config.Configure()
+					config.PostConfigure()
+					args, err := getArgs(c)
+					if err != nil {
+						return err
+					}
+					if len(args) != 1 {
+						return fmt.Errorf("expected an RFD id")
+					}
+					rfdId, err := parseRFDId(args[0])
+					if err != nil {
+						return err
+					}
+					return approveRFD(rfdId, c.Bool("withdraw"))
+				},
+			},
+			{
+				Name:      "comment",
+				Usage:     "Comment on a line or section of an RFD's readme, or reply to, resolve or unresolve a comment thread, committing to the RFD's branch.",
+				ArgsUsage: "<id> <text>",
+				Flags: []cli.Flag{
+					&cli.IntFlag{
+						Name:  "line",
+						Usage: "The line of readme.md to comment on.",
+					},
+					&cli.StringFlag{
+						Name:  "section",
+						Usage: "The heading of the section of readme.md to comment on.",
+					},
+					&cli.IntFlag{
+						Name:  "reply",
+						Usage: "The thread to reply to.",
+					},
+					&cli.IntFlag{
+						Name:  "resolve",
+						Usage: "The thread to resolve, with an optional closing comment.",
+					},
+					&cli.IntFlag{
+						Name:  "unresolve",
+						Usage: "The thread to unresolve, with an optional comment.",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					config.Configure()
+					config.PostConfigure()
+					args, err := getArgs(c)
+					if err != nil {
+						return err
+					}
+					if len(args) == 0 {
+						return fmt.Errorf("expected an RFD id")
+					}
+					rfdId, err := parseRFDId(args[0])
+					if err != nil {
+						return err
+					}
+					text := strings.Join(args[1:], " ")
+
+					switch {
+					case c.Int("resolve") > 0:
+						return replyToThread(rfdId, c.Int("resolve"), text, true, false)
+					case c.Int("unresolve") > 0:
+						return replyToThread(rfdId, c.Int("unresolve"), text, false, true)
+					case strings.TrimSpace(text) == "":
+						return fmt.Errorf("expected the text of the comment")
+					case c.Int("reply") > 0:
+						return replyToThread(rfdId, c.Int("reply"), text, false, false)
+					case c.Int("line") > 0 || c.String("section") != "":
+						return addComment(rfdId, c.Int("line"), c.String("section"), text)
+					}
+					return fmt.Errorf("expected --line or --section to start a thread, or --reply, --resolve or --unresolve")
+				},
+			},
+			{
+				Name:      "comments",
+				Usage:     "Show the comment threads on an RFD.",
+				ArgsUsage: "<id>",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "unresolved",
+						Usage: "Show only the threads that haven't been resolved.",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					config.Configure()
+					config.PostConfigure()
+					args, err := getArgs(c)
+					if err != nil {
+						return err
+					}
+					if len(args) != 1 {
+						return fmt.Errorf("expected an RFD id")
+					}
+					rfdId, err := parseRFDId(args[0])
+					if err != nil {
+						return err
+					}
+					return listComments(rfdId, c.Bool("unresolved"))
+				},
+			},
+			{
+				Name:      "pr-sync",
+				Usage:     "Record the state, approvals and comment count of each RFD's pull request in its metadata, and suggest the state it should move to.",
+				ArgsUsage: "[id ...]",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "apply",
+						Usage: "Move RFDs to the suggested state, rather than only suggesting it.",
+					},
+					&cli.BoolFlag{
+						Name:  "dry-run",
+						Usage: "Report the status of each pull request without changing anything.",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					config.Configure()
+					config.PostConfigure()
+					args, err := getArgs(c)
+					if err != nil {
+						return err
+					}
+					var rfdIds []string
+					for _, arg := range args {
+						rfdId, err := parseRFDId(arg)
+						if err != nil {
+							return err
+						}
+						rfdIds = append(rfdIds, rfdId)
+					}
+					return syncPullRequests(rfdIds, c.Bool("apply"), c.Bool("dry-run"))
+				},
+			},
+			{
+				Name:  "digest",
+				Usage: "Summarise the RFDs created and moved between states over a period, those waiting on review, and those gone stale.",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "since",
+						Value: DEFAULT_DIGEST_PERIOD,
+						Usage: "The period to summarise, back from now e.g. 7d, 2w or 36h.",
+					},
+					&cli.StringFlag{
+						Name:  "stale-after",
+						Value: DEFAULT_STALE_THRESHOLD,
+						Usage: "How long an RFD can be in draft or discussion before it's stale, if states.yml doesn't say.",
+					},
+					&cli.StringFlag{
+						Name:  "format",
+						Value: "markdown",
+						Usage: "Output format, markdown, html or text.",
+					},
+					&cli.BoolFlag{
+						Name:  "send",
+						Usage: "Send the digest to the notification channels that want digests, rather than writing it to stdout.",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					config.Configure()
+					config.PostConfigure()
+					return writeDigest(c.String("since"), c.String("stale-after"), c.String("format"), c.Bool("send"))
+				},
+			},
+			{
+				Name:  "stale",
+				Usage: "List the RFDs that have been in their state for longer than its max-age in states.yml.",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "notify",
+						Usage: "Remind the notification channels, and the authors if a channel emails them, of each stale RFD.",
+					},
+					&cli.StringFlag{
+						Name:  "remind-every",
+						Value: DEFAULT_REMINDER_INTERVAL,
+						Usage: "How often to remind about an RFD that's still stale.",
+					},
+					&cli.BoolFlag{
+						Name:  "move",
+						Usage: "Move RFDs still stale after their state's grace-period to its stale-state.",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					config.Configure()
+					config.PostConfigure()
+					return findStaleRFDs(c.Bool("notify"), c.Bool("move"), c.String("remind-every"))
+				},
+			},
+			{
+				Name:      "lint",
+				Usage:     "Check RFDs' metadata, required headings and relative links. Exits with 1 if there are errors, and 2 if they couldn't be checked.",
+				ArgsUsage: "[id or path to readme ...]",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "format",
+						Value: "text",
+						Usage: "Output format, text, json, sarif or github.",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					config.Configure()
+					config.PostConfigure()
+					args, err := getArgs(c)
+					if err != nil {
+						return cli.Exit(err.Error(), 2)
+					}
+					errors, err := lintRFDs(args, c.String("format"))
+					if err != nil {
+						return cli.Exit(err.Error(), 2)
+					}
+					if errors > 0 {
+						return cli.Exit("", 1)
+					}
+					return nil
+				},
+			},
+			{
+				Name:  "hooks",
+				Usage: "Install or uninstall git hooks that check RFDs before they're committed or pushed.",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "install",
+						Usage: "Write pre-commit and pre-push hooks that run 'rfd hook'.",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:  "force",
+								Usage: "Replace existing hooks that weren't installed by rfd.",
+							},
+						},
+						Action: func(c *cli.Context) error {
+							return installGitHooks(c.Bool("force"))
+						},
+					},
+					{
+						Name:  "uninstall",
+						Usage: "Remove the hooks installed by 'rfd hooks install'.",
+						Action: func(c *cli.Context) error {
+							return uninstallGitHooks()
+						},
+					},
+				},
+			},
+			{
+				Name:  "hook",
+				Usage: "Run the checks of a git hook, as the hooks installed by 'rfd hooks install', or a git server's pre-receive hook, do.",
+				Subcommands: []*cli.Command{
+					{
+						Name:  PRE_COMMIT_GIT_HOOK,
+						Usage: "Refuse commits to the trunk, and lint the staged RFD readmes.",
+						Action: func(c *cli.Context) error {
+							config.Configure()
+							config.PostConfigure()
+							refused, err := runPreCommitHook()
+							return getGitHookExit(refused, err)
+						},
+					},
+					{
+						Name:      PRE_PUSH_GIT_HOOK,
+						Usage:     "Refuse to push RFD branches whose readme's id isn't the branch's name, reading the refs being pushed from stdin.",
+						ArgsUsage: "[remote] [url]",
+						Action: func(c *cli.Context) error {
+							config.Configure()
+							config.PostConfigure()
+							refused, err := runPrePushHook(os.Stdin)
+							return getGitHookExit(refused, err)
+						},
+					},
+					{
+						Name:  PRE_RECEIVE_GIT_HOOK,
+						Usage: "Refuse pushes to a server's bare repository that break the lint, id or state rules, reading the ref updates from stdin.",
+						Action: func(c *cli.Context) error {
+							config.Configure()
+							config.PostConfigure()
+							refused, err := runPreReceiveHook(os.Stdin)
+							return getGitHookExit(refused, err)
+						},
+					},
+				},
+			},
+			{
+				Name:  "ci",
+				Usage: "Checks to run in CI.",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "verify",
+						Usage: "Verify an RFD's pull request can be merged: its branch, the files it changes, its id, its change of state, and the index. Exits with 1 if it can't, and 2 if it couldn't be verified.",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:  "base",
+								Usage: "The trunk branch the pull request is to be merged into, by default the repository's trunk.",
+							},
+							&cli.StringFlag{
+								Name:  "head",
+								Value: "HEAD",
+								Usage: "The commit to verify.",
+							},
+							&cli.StringFlag{
+								Name:  "branch",
+								Usage: "The name of the pull request's branch, if it can't be told from the CI's environment or what's checked out.",
+							},
+							&cli.StringSliceFlag{
+								Name:  "allow",
+								Usage: "A path or glob the pull request may change besides its RFD's directory and the index, in addition to those in config.yml.",
+							},
+							&cli.StringFlag{
+								Name:  "format",
+								Usage: "Output format, text, json, sarif or github. By default github when run by GitHub Actions, and text otherwise.",
+							},
+						},
+						Action: func(c *cli.Context) error {
+							config.Configure()
+							config.PostConfigure()
+							errors, err := verifyPullRequest(c.String("base"), c.String("head"), c.String("branch"), c.StringSlice("allow"), c.String("format"))
+							if err != nil {
+								return cli.Exit(err.Error(), 2)
+							}
+							if errors > 0 {
+								return cli.Exit("", 1)
+							}
+							return nil
+						},
+					},
+				},
+			},
+			{
+				Name:      "list",
+				Usage:     "List RFDs, merged or not, whose metadata matches a filter e.g. state = discussion and authors ~ Bob",
+				ArgsUsage: "[filter]",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "sort",
+						Value: "id",
+						Usage: "Comma delimited fields to sort by, prefix a field with - to sort in descending order.",
+					},
+					&cli.StringFlag{
+						Name:  "format",
+						Value: "table",
+						Usage: "Output format, table or json.",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					config.Configure()
+					config.PostConfigure()
+					return listRFDs(strings.Join(c.Args().Slice(), " "), c.String("sort"), c.String("format"))
+				},
+			},
+			{
+				Name:      "graph",
+				Usage:     "Draw the relations between RFDs matching a filter, coloured by state. Exits with 1 if there are cycles in depends-on.",
+				ArgsUsage: "[filter]",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "format",
+						Value: "mermaid",
+						Usage: "Output format, dot, mermaid or json.",
+					},
+					&cli.StringFlag{
+						Name:  "subtree",
+						Usage: "Only draw this RFD, its children and what it depends on, and theirs in turn.",
+					},
+					&cli.StringFlag{
+						Name:  "embed",
+						Usage: "Embed the mermaid graph in a markdown file such as index.md or readme.md, replacing the graph already there.",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					config.Configure()
+					config.PostConfigure()
+					subtree := ""
+					if c.String("subtree") != "" {
+						var err error
+						subtree, err = parseRFDId(c.String("subtree"))
+						if err != nil {
+							return cli.Exit(err.Error(), 2)
+						}
+					}
+					cycles, err := graphRFDs(strings.Join(c.Args().Slice(), " "), subtree, c.String("format"), c.String("embed"))
+					if err != nil {
+						return cli.Exit(err.Error(), 2)
+					}
+					if cycles > 0 {
+						return cli.Exit("", 1)
+					}
+					return nil
+				},
+			},
+			{
+				Name:      "search",
+				Usage:     "Search the text of all RFDs, merged or not. Terms can be scoped with title:, author:, state: and id:",
+				ArgsUsage: "<terms>",
+				Flags: []cli.Flag{
+					&cli.IntFlag{
+						Name:  "limit",
+						Value: 10,
+						Usage: "The maximum number of results to show, 0 for all.",
+					},
+					&cli.BoolFlag{
+						Name:  "rebuild",
+						Usage: "Rebuild the search index from scratch.",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					config.Configure()
+					config.PostConfigure()
+					return searchRFDs(strings.Join(c.Args().Slice(), " "), c.Int("limit"), c.Bool("rebuild"))
+				},
+			},
+			{
+				Name:  "site",
+				Usage: "Publish the RFDs as a static web site.",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "build",
+						Usage: "Render every RFD, merged or not, to HTML along with index, state and author pages.",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:  "out",
+								Value: "public",
+								Usage: "The directory to write the site to.",
+							},
+						},
+						Action: func(c *cli.Context) error {
+							config.Configure()
+							config.PostConfigure()
+							return buildSite(c.String("out"))
+						},
+					},
+				},
+			},
+			{
+				Name:  "serve",
+				Usage: "Serve a web UI for browsing and searching the RFDs, rendered live from the repository.",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "addr",
+						Value: ":8080",
+						Usage: "The address to listen on.",
+					},
+					&cli.BoolFlag{
+						Name:  "api",
+						Usage: "Serve a read-only JSON API over the RFDs instead of the web UI.",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					config.Configure()
+					config.PostConfigure()
+					return serveRFDs(c.String("addr"), c.Bool("api"))
+				},
+			},
 			{
 				Name:  "environment",
 				Usage: "Displays configuration settings and relevant operating system environment variables.",
@@ -77,25 +654,37 @@ func createCommandLineApp() *cli.App {
 				},
 			},
 			{
-				Name:  "merge",
-				Usage: "Transitions an RFD's status to Accepted, captures the discussion link from the user, and merges it into the main branch (NOT IMPLEMENTED)",
+				Name:      "merge",
+				Usage:     "Move an RFD to accepted, archive its pull request's discussion as nnnn/" + DISCUSSION_ARCHIVE_FILE + ", and merge it into the trunk.",
+				ArgsUsage: "<id>",
 				Action: func(c *cli.Context) error {
 					config.Configure()
 					config.PostConfigure()
-					doMerge()
-					return nil
+					rfdId, err := parseRFDId(c.Args().First())
+					if err != nil {
+						return err
+					}
+					return doMerge(rfdId)
 				},
 			},
 			{
-				Name:  "status",
-				Usage: "Displays the status of the current RFD (as per branch). (NOT IMPLEMENTED)",
+				Name:      "status",
+				Usage:     "Displays the status of an RFD, or of the RFD whose branch is checked out, including its unresolved comments.",
+				ArgsUsage: "[id]",
 				Action: func(c *cli.Context) error {
 
 					config.Configure()
 					config.PostConfigure()
 
-					getDefaultStatus()
-					return nil
+					rfdId := ""
+					if c.Args().Present() {
+						var err error
+						rfdId, err = parseRFDId(c.Args().First())
+						if err != nil {
+							return err
+						}
+					}
+					return showStatus(rfdId)
 				},
 			},
 		},
@@ -124,6 +713,62 @@ VERSION:
 	return app
 }
 
+// getArgs returns the arguments of a command, first applying any of the command's flags given among them.
+// urfave/cli only reads flags given before the first argument, which reads awkwardly for commands such as
+// 'rfd comment 42 --line 12 "..."'.
+func getArgs(c *cli.Context) ([]string, error) {
+
+	var args []string
+	given := c.Args().Slice()
+
+	for i := 0; i < len(given); i++ {
+
+		arg := given[i]
+		if arg == "--" {
+			return append(args, given[i+1:]...), nil
+		}
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			args = append(args, arg)
+			continue
+		}
+
+		name := strings.TrimLeft(arg, "-")
+		value, hasValue := "", false
+		if equals := strings.Index(name, "="); equals >= 0 {
+			name, value, hasValue = name[:equals], name[equals+1:], true
+		}
+
+		var flag cli.Flag
+		for _, candidate := range c.Command.Flags {
+			for _, candidateName := range candidate.Names() {
+				if candidateName == name {
+					flag = candidate
+				}
+			}
+		}
+		if flag == nil {
+			return nil, fmt.Errorf("flag provided but not defined: %s", arg)
+		}
+
+		if _, isBool := flag.(*cli.BoolFlag); isBool && !hasValue {
+			value = "true"
+		} else if !hasValue {
+			if i+1 >= len(given) {
+				return nil, fmt.Errorf("flag needs an argument: %s", arg)
+			}
+			i++
+			value = given[i]
+		}
+
+		err := c.Set(name, value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q for flag %s: %v", value, arg, err)
+		}
+	}
+
+	return args, nil
+}
+
 func displayEnvironment() {
 	operatingSystem := runtime.GOOS
 	fmt.Println("OS: " + operatingSystem)
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	localConfig "github.com/redazzo/rfd/cmd/rfd/internal/config"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+/*
+
+Gathering every RFD, whatever state it's in:
+
+1. Read each nnnn directory on the trunk; these are the merged RFDs.
+2. Read the nnnn directory of each local and remote-tracking nnnn branch. Where a branch holds changes
+   not yet on the trunk, its version of the RFD is the more current, so it replaces the trunk's. A local
+   branch is preferred to the remote-tracking branch of the same name.
+
+Everything is read from git objects, so the result doesn't depend on what's checked out. The web UI
+additionally reads the RFDs checked out in the working tree, using readWorkingTreeRFDs.
+
+*/
+
+// rfd is an RFD as read from the trunk or its branch.
+type rfd struct {
+	ID       string
+	Branch   string
+	Merged   bool
+	Path     string
+	BlobHash plumbing.Hash
+	Content  []byte
+	Commit   *object.Commit
+	Metadata map[string]interface{}
+
+	// WorkingTree is set if the RFD was read from the working tree rather than from Commit
+	WorkingTree bool
+}
+
+// Title returns the title from the RFD's metadata.
+func (d *rfd) Title() string {
+	return metadataString(d.Metadata, "title")
+}
+
+// State returns the state from the RFD's metadata.
+func (d *rfd) State() string {
+	return metadataString(d.Metadata, "state")
+}
+
+// Authors returns the authors from the RFD's metadata.
+func (d *rfd) Authors() string {
+	return metadataString(d.Metadata, "authors")
+}
+
+func metadataString(metadata map[string]interface{}, key string) string {
+	value, ok := metadata[key]
+	if !ok || value == nil {
+		return ""
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+func collectRFDs(r *git.Repository) []*rfd {
+
+	rfds := make(map[string]*rfd)
+
+	trunkName := getTrunkBranchName(r)
+	trunk := getCommit(r, plumbing.NewBranchReferenceName(trunkName))
+	if trunk == nil {
+		trunk = getCommit(r, plumbing.NewRemoteReferenceName("origin", trunkName))
+	}
+
+	if trunk != nil {
+		tree, err := trunk.Tree()
+		localConfig.CheckFatal(err)
+
+		for _, entry := range tree.Entries {
+			isRFDDirectory, err := localConfig.IsRFDIDFormat(entry.Name)
+			localConfig.CheckFatal(err)
+			if !isRFDDirectory || entry.Mode.IsFile() {
+				continue
+			}
+			if found := readRFDFromCommit(trunk, entry.Name, trunkName); found != nil {
+				found.Merged = true
+				rfds[entry.Name] = found
+			}
+		}
+	}
+
+	// Remote-tracking branches first, so that local branches take precedence
+	for _, remote := range []bool{true, false} {
+		for _, ref := range getRFDBranchReferences(r, remote) {
+
+			id := ref.Name().Short()
+			if remote {
+				id = id[len("origin/"):]
+			}
+
+			commit, err := r.CommitObject(ref.Hash())
+			localConfig.CheckFatal(err)
+
+			if trunk != nil && containsCommit(trunk, commit) {
+				// Nothing on the branch that isn't already on the trunk
+				continue
+			}
+
+			found := readRFDFromCommit(commit, id, ref.Name().Short())
+			if found == nil {
+				continue
+			}
+			found.Merged = commitHasDirectory(trunk, id)
+			rfds[id] = found
+		}
+	}
+
+	var result []*rfd
+	for _, found := range rfds {
+		result = append(result, found)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID < result[j].ID
+	})
+
+	return result
+}
+
+// getRFDBranchReferences returns the local nnnn branches, or the remote-tracking nnnn branches of origin.
+func getRFDBranchReferences(r *git.Repository, remote bool) []*plumbing.Reference {
+
+	var result []*plumbing.Reference
+
+	refs, err := r.References()
+	localConfig.CheckFatal(err)
+
+	refs.ForEach(func(ref *plumbing.Reference) error {
+
+		if ref.Type() != plumbing.HashReference {
+			return nil
+		}
+
+		var branch string
+		switch {
+		case !remote && ref.Name().IsBranch():
+			branch = ref.Name().Short()
+		case remote && ref.Name().IsRemote() && strings.HasPrefix(ref.Name().Short(), "origin/"):
+			branch = ref.Name().Short()[len("origin/"):]
+		default:
+			return nil
+		}
+
+		isRFDBranch, err := localConfig.IsRFDIDFormat(branch)
+		localConfig.CheckFatal(err)
+		if isRFDBranch {
+			result = append(result, ref)
+		}
+		return nil
+	})
+
+	return result
+}
+
+var readmePattern = regexp.MustCompile(`(?i)^readme.md`)
+
+// readRFDFromCommit reads the readme of an RFD from a commit, returning nil if there isn't one.
+func readRFDFromCommit(commit *object.Commit, id string, branch string) *rfd {
+
+	tree, err := commit.Tree()
+	localConfig.CheckFatal(err)
+
+	subTree, err := tree.Tree(id)
+	if err != nil {
+		return nil
+	}
+
+	for _, entry := range subTree.Entries {
+
+		if !entry.Mode.IsFile() || !readmePattern.MatchString(entry.Name) {
+			continue
+		}
+
+		path := id + "/" + entry.Name
+		content := readFileFromTree(tree, path)
+
+		return &rfd{
+			ID:       id,
+			Branch:   branch,
+			Path:     path,
+			BlobHash: entry.Hash,
+			Content:  content,
+			Commit:   commit,
+			Metadata: parseMetadata(content),
+		}
+	}
+
+	return nil
+}
+
+// readWorkingTreeRFDs replaces RFDs with their copies in the working tree, so that edits show before
+// they're committed. With the trunk checked out that's every merged RFD not changed on its branch; with
+// an RFD branch checked out, it's that branch's RFD.
+func readWorkingTreeRFDs(r *git.Repository, rfds []*rfd) []*rfd {
+
+	head, err := r.Head()
+	if err != nil || !head.Name().IsBranch() {
+		return rfds
+	}
+
+	headCommit, err := r.CommitObject(head.Hash())
+	localConfig.CheckFatal(err)
+
+	branch := head.Name().Short()
+	trunkName := getTrunkBranchName(r)
+
+	byID := make(map[string]int)
+	for i, found := range rfds {
+		byID[found.ID] = i
+	}
+
+	for _, entry := range getDirectories() {
+
+		isRFDDirectory, err := localConfig.IsRFDIDFormat(entry.Name())
+		localConfig.CheckFatal(err)
+		if !isRFDDirectory || !entry.IsDir() {
+			continue
+		}
+
+		id := entry.Name()
+		i, exists := byID[id]
+
+		if branch == trunkName {
+			if exists && !(rfds[i].Merged && rfds[i].Branch == trunkName) {
+				continue
+			}
+		} else if branch != id {
+			continue
+		}
+
+		found := readRFDFromDirectory(id, branch)
+		if found == nil {
+			continue
+		}
+		found.Commit = headCommit
+		found.Merged = branch == trunkName || (exists && rfds[i].Merged)
+
+		if exists {
+			rfds[i] = found
+		} else {
+			byID[id] = len(rfds)
+			rfds = append(rfds, found)
+		}
+	}
+
+	sort.Slice(rfds, func(i, j int) bool {
+		return rfds[i].ID < rfds[j].ID
+	})
+
+	return rfds
+}
+
+// readRFDFromDirectory reads the readme of an RFD from the working tree, returning nil if there isn't one.
+func readRFDFromDirectory(id string, branch string) *rfd {
+
+	entries, err := ioutil.ReadDir(localConfig.APP_CONFIG.RootDirectory + localConfig.PATH_SEPARATOR + id)
+	localConfig.CheckFatal(err)
+
+	for _, entry := range entries {
+
+		if entry.IsDir() || !readmePattern.MatchString(entry.Name()) {
+			continue
+		}
+
+		content, err := os.ReadFile(localConfig.APP_CONFIG.RootDirectory + localConfig.PATH_SEPARATOR + id + localConfig.PATH_SEPARATOR + entry.Name())
+		localConfig.CheckFatal(err)
+
+		return &rfd{
+			ID:          id,
+			Branch:      branch,
+			Path:        id + "/" + entry.Name(),
+			BlobHash:    plumbing.ComputeHash(plumbing.BlobObject, content),
+			Content:     content,
+			Metadata:    parseMetadata(content),
+			WorkingTree: true,
+		}
+	}
+
+	return nil
+}
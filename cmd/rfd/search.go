@@ -0,0 +1,432 @@
+package main
+
+import (
+	"encoding/gob"
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	localConfig "github.com/redazzo/rfd/cmd/rfd/internal/config"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+/*
+
+Full-text search across every RFD, merged or not.
+
+The search index is an inverted index kept in .git/rfd/search-index. Documents in it are keyed by the hash
+of the readme blob they were read from, so bringing the index up to date only means indexing blobs that
+haven't been seen before, and forgetting those no longer found on the trunk or any RFD branch.
+
+A query is a list of terms, any of which may be scoped to a metadata field with title:, author:, state:,
+or id:. Field scoped terms must all match; the remaining terms rank the matching RFDs using BM25, with
+terms in the title counting for more than those in the body.
+
+*/
+
+const TITLE_WEIGHT = 3
+
+type searchIndex struct {
+	Documents map[string]*searchDocument
+	Postings  map[string]map[string]int
+}
+
+type searchDocument struct {
+	Length int
+	Fields map[string]string
+}
+
+type searchResult struct {
+	RFD     *rfd
+	Score   float64
+	Snippet string
+}
+
+var searchFields = map[string]string{
+	"title":   "title",
+	"author":  "authors",
+	"authors": "authors",
+	"state":   "state",
+	"id":      "id",
+}
+
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true, "by": true,
+	"for": true, "from": true, "in": true, "is": true, "it": true, "of": true, "on": true, "or": true,
+	"that": true, "the": true, "this": true, "to": true, "was": true, "with": true,
+}
+
+func getSearchIndexLocation() string {
+	return localConfig.GetRFDStateDirectory() + localConfig.PATH_SEPARATOR + "search-index"
+}
+
+func searchRFDs(query string, limit int, rebuild bool) error {
+
+	r, err := git.PlainOpen(".")
+	localConfig.CheckFatal(err)
+
+	rfds := collectRFDs(r)
+
+	index := &searchIndex{
+		Documents: make(map[string]*searchDocument),
+		Postings:  make(map[string]map[string]int),
+	}
+	if !rebuild {
+		index, err = loadSearchIndex()
+		if err != nil {
+			return err
+		}
+	}
+
+	if index.update(rfds) {
+		err = index.save()
+		if err != nil {
+			return err
+		}
+	}
+
+	results := index.search(rfds, query)
+	if len(results) == 0 {
+		fmt.Println("No RFDs found.")
+		return nil
+	}
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+
+	highlight := isTerminal(os.Stdout)
+	for _, result := range results {
+		fmt.Printf("%s  %s  [%s, %s]\n", result.RFD.ID, result.RFD.Title(), result.RFD.State(), result.RFD.Branch)
+		if result.Snippet != "" {
+			fmt.Println("    " + highlightTerms(result.Snippet, queryTerms(query), highlight))
+		}
+	}
+
+	return nil
+}
+
+func loadSearchIndex() (*searchIndex, error) {
+
+	index := &searchIndex{
+		Documents: make(map[string]*searchDocument),
+		Postings:  make(map[string]map[string]int),
+	}
+
+	file, err := os.Open(getSearchIndexLocation())
+	if os.IsNotExist(err) {
+		return index, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	err = gob.NewDecoder(file).Decode(index)
+	if err != nil {
+		// A damaged or outdated index is simply rebuilt
+		localConfig.Logger.TraceLog("Rebuilding search index: " + err.Error())
+		return &searchIndex{
+			Documents: make(map[string]*searchDocument),
+			Postings:  make(map[string]map[string]int),
+		}, nil
+	}
+
+	return index, nil
+}
+
+func (index *searchIndex) save() error {
+
+	err := os.MkdirAll(localConfig.GetRFDStateDirectory(), 0755)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(getSearchIndexLocation())
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return gob.NewEncoder(file).Encode(index)
+}
+
+// update indexes the RFDs whose readme hasn't been indexed before, and removes documents that no longer
+// belong to any RFD. It reports whether the index changed.
+func (index *searchIndex) update(rfds []*rfd) bool {
+
+	changed := false
+	current := make(map[string]bool)
+
+	for _, found := range rfds {
+
+		key := found.BlobHash.String()
+		current[key] = true
+		if _, indexed := index.Documents[key]; indexed {
+			continue
+		}
+
+		localConfig.Logger.TraceLog("Indexing " + found.ID + " (" + key + ")")
+
+		document := &searchDocument{Fields: make(map[string]string)}
+		for field, key := range searchFields {
+			document.Fields[field] = strings.ToLower(metadataString(found.Metadata, key))
+		}
+		document.Fields["id"] = found.ID
+
+		terms := make(map[string]int)
+		for _, term := range tokeniseText(found.Title()) {
+			terms[term] += TITLE_WEIGHT
+		}
+		for _, term := range tokeniseText(string(stripFrontMatter(found.Content))) {
+			terms[term]++
+		}
+		for term, count := range terms {
+			if index.Postings[term] == nil {
+				index.Postings[term] = make(map[string]int)
+			}
+			index.Postings[term][key] = count
+			document.Length += count
+		}
+
+		index.Documents[key] = document
+		changed = true
+	}
+
+	for key := range index.Documents {
+		if current[key] {
+			continue
+		}
+		delete(index.Documents, key)
+		for term, postings := range index.Postings {
+			delete(postings, key)
+			if len(postings) == 0 {
+				delete(index.Postings, term)
+			}
+		}
+		changed = true
+	}
+
+	return changed
+}
+
+// search returns the RFDs matching a query, best first.
+func (index *searchIndex) search(rfds []*rfd, query string) []searchResult {
+
+	var terms []string
+	fieldTerms := make(map[string][]string)
+
+	for _, word := range strings.Fields(query) {
+		colon := strings.Index(word, ":")
+		if colon > 0 {
+			if field, ok := searchFields[strings.ToLower(word[:colon])]; ok {
+				if field == "authors" {
+					field = "author"
+				}
+				fieldTerms[field] = append(fieldTerms[field], strings.ToLower(word[colon+1:]))
+				continue
+			}
+		}
+		terms = append(terms, tokeniseText(word)...)
+	}
+
+	averageLength := 0.0
+	for _, document := range index.Documents {
+		averageLength += float64(document.Length)
+	}
+	if len(index.Documents) > 0 {
+		averageLength /= float64(len(index.Documents))
+	}
+
+	var results []searchResult
+
+	for _, found := range rfds {
+
+		key := found.BlobHash.String()
+		document, ok := index.Documents[key]
+		if !ok || !document.matchesFields(fieldTerms) {
+			continue
+		}
+
+		score := 0.0
+		for _, term := range terms {
+			frequency := float64(index.Postings[term][key])
+			if frequency == 0 {
+				continue
+			}
+			score += bm25(frequency, len(index.Postings[term]), len(index.Documents), float64(document.Length), averageLength)
+		}
+
+		if len(terms) > 0 && score == 0 {
+			continue
+		}
+
+		results = append(results, searchResult{
+			RFD:     found,
+			Score:   score,
+			Snippet: getSnippet(stripFrontMatter(found.Content), terms),
+		})
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].RFD.ID < results[j].RFD.ID
+	})
+
+	return results
+}
+
+func (document *searchDocument) matchesFields(fieldTerms map[string][]string) bool {
+	for field, values := range fieldTerms {
+		for _, value := range values {
+			if field == "id" {
+				if compareValues(document.Fields[field], value) != 0 {
+					return false
+				}
+				continue
+			}
+			if !strings.Contains(document.Fields[field], value) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// bm25 scores a term in a document, given how often it occurs there, how many documents it occurs in,
+// and how long the document is compared to the average.
+func bm25(frequency float64, documentsWithTerm int, documents int, length float64, averageLength float64) float64 {
+
+	const k1 = 1.2
+	const b = 0.75
+
+	idf := math.Log(1 + (float64(documents)-float64(documentsWithTerm)+0.5)/(float64(documentsWithTerm)+0.5))
+
+	norm := 1.0
+	if averageLength > 0 {
+		norm = 1 - b + b*length/averageLength
+	}
+
+	return idf * frequency * (k1 + 1) / (frequency + k1*norm)
+}
+
+func tokeniseText(text string) []string {
+
+	var terms []string
+
+	words := strings.FieldsFunc(strings.ToLower(text), func(c rune) bool {
+		return !unicode.IsLetter(c) && !unicode.IsDigit(c)
+	})
+	for _, word := range words {
+		if len(word) > 1 && !stopWords[word] {
+			terms = append(terms, word)
+		}
+	}
+
+	return terms
+}
+
+// queryTerms returns the words of a query to be highlighted, including those scoped to a field.
+func queryTerms(query string) []string {
+
+	var terms []string
+	for _, word := range strings.Fields(query) {
+		if colon := strings.Index(word, ":"); colon > 0 {
+			word = word[colon+1:]
+		}
+		terms = append(terms, tokeniseText(word)...)
+	}
+
+	return terms
+}
+
+var frontMatterPattern = regexp.MustCompile(`(?s)^---\r?\n.*?\n---\r?\n`)
+
+func stripFrontMatter(content []byte) []byte {
+	return frontMatterPattern.ReplaceAll(content, nil)
+}
+
+// getSnippet returns the line of the body with the most query terms in it, trimmed to a readable length.
+func getSnippet(body []byte, terms []string) string {
+
+	bestLine := ""
+	bestCount := -1
+
+	for _, line := range strings.Split(string(body), "\n") {
+
+		line = strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(line), "#*->|"))
+		if line == "" {
+			continue
+		}
+
+		count := 0
+		lower := strings.ToLower(line)
+		for _, term := range terms {
+			count += strings.Count(lower, term)
+		}
+		if count > bestCount {
+			bestLine = line
+			bestCount = count
+		}
+	}
+
+	const maxLength = 160
+	runes := []rune(bestLine)
+	if len(runes) <= maxLength {
+		return bestLine
+	}
+
+	// Centre the snippet on the first term found
+	start := 0
+	lower := []rune(strings.ToLower(bestLine))
+	for _, term := range terms {
+		if position := strings.Index(string(lower), term); position >= 0 {
+			start = len([]rune(string(lower)[:position])) - maxLength/3
+			break
+		}
+	}
+	if start < 0 {
+		start = 0
+	}
+	if start+maxLength > len(runes) {
+		start = len(runes) - maxLength
+	}
+
+	snippet := string(runes[start : start+maxLength])
+	if start > 0 {
+		snippet = "..." + snippet
+	}
+	if start+maxLength < len(runes) {
+		snippet += "..."
+	}
+
+	return snippet
+}
+
+// highlightTerms marks the query terms in a snippet, in bold on a terminal and with ** otherwise.
+func highlightTerms(snippet string, terms []string, terminal bool) string {
+
+	if len(terms) == 0 {
+		return snippet
+	}
+
+	var quoted []string
+	for _, term := range terms {
+		quoted = append(quoted, regexp.QuoteMeta(term))
+	}
+	pattern := regexp.MustCompile(`(?i)(` + strings.Join(quoted, "|") + `)`)
+
+	if terminal {
+		return pattern.ReplaceAllString(snippet, "\033[1m$1\033[0m")
+	}
+	return pattern.ReplaceAllString(snippet, "**$1**")
+}
+
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"github.com/go-git/go-git/v5/plumbing"
+	"reflect"
+	"testing"
+)
+
+func newSearchRFD(id string, title string, authors string, state string, body string) *rfd {
+
+	content := []byte("---\nid: " + id + "\ntitle: " + title + "\nauthors: " + authors + "\nstate: " + state + "\n---\n\n" + body + "\n")
+	return &rfd{
+		ID:       id,
+		Branch:   id,
+		BlobHash: plumbing.ComputeHash(plumbing.BlobObject, content),
+		Content:  content,
+		Metadata: parseMetadata(content),
+	}
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		Documents: make(map[string]*searchDocument),
+		Postings:  make(map[string]map[string]int),
+	}
+}
+
+func TestSearchRanking(t *testing.T) {
+
+	rfds := []*rfd{
+		newSearchRFD("0001", "Networking", "Bob", "discussion", "Routing between sites, with a note on the storage of routing tables, and the switches and links carrying the traffic between them."),
+		newSearchRFD("0002", "Storage for the RFD tool", "Alice", "discussion", "Where the tool keeps its storage, and how storage is backed up."),
+		newSearchRFD("0003", "Backups", "Alice", "accepted", "Nightly backups of the databases, kept for a month."),
+		newSearchRFD("0004", "Databases", "Carol <carol@example.com>", "accepted", "Moving the databases to Postgres."),
+	}
+
+	index := newSearchIndex()
+	index.update(rfds)
+
+	tests := []struct {
+		query    string
+		expected []string
+	}{
+		// Terms in the title count for more than those in the body
+		{"storage", []string{"0002", "0001"}},
+		{"databases", []string{"0004", "0003"}},
+		// Matching a rarer term counts for more than matching a common one
+		{"routing databases", []string{"0001", "0004", "0003"}},
+		{"Postgres", []string{"0004"}},
+		// Stop words aren't terms, so like field scoped terms alone they match without ranking
+		{"the", []string{"0001", "0002", "0003", "0004"}},
+		{"author:alice", []string{"0002", "0003"}},
+		{"databases author:carol", []string{"0004"}},
+		{"storage state:accepted", nil},
+		{"state:accepted title:backups", []string{"0003"}},
+		{"id:2", []string{"0002"}},
+		{"unheardof", nil},
+	}
+
+	for _, test := range tests {
+
+		var ids []string
+		for _, result := range index.search(rfds, test.query) {
+			ids = append(ids, result.RFD.ID)
+		}
+
+		if !reflect.DeepEqual(ids, test.expected) {
+			t.Errorf("Expected %q to find %v, got %v", test.query, test.expected, ids)
+		}
+	}
+}
+
+func TestSearchIndexUpdate(t *testing.T) {
+
+	first := newSearchRFD("0001", "Storage", "Alice", "discussion", "Keeping things on disk.")
+	second := newSearchRFD("0002", "Networking", "Bob", "discussion", "Routing between sites.")
+	edited := newSearchRFD("0002", "Networking", "Bob", "discussion", "Switching between sites.")
+
+	index := newSearchIndex()
+
+	tests := []struct {
+		name      string
+		rfds      []*rfd
+		changed   bool
+		documents []plumbing.Hash
+		query     string
+		expected  []string
+	}{
+		{"indexes new RFDs", []*rfd{first, second}, true, []plumbing.Hash{first.BlobHash, second.BlobHash}, "routing", []string{"0002"}},
+		{"reuses unchanged readmes", []*rfd{first, second}, false, []plumbing.Hash{first.BlobHash, second.BlobHash}, "routing", []string{"0002"}},
+		{"replaces an edited readme", []*rfd{first, edited}, true, []plumbing.Hash{first.BlobHash, edited.BlobHash}, "switching", []string{"0002"}},
+		{"forgets the edited readme's old terms", []*rfd{first, edited}, false, []plumbing.Hash{first.BlobHash, edited.BlobHash}, "routing", nil},
+		{"forgets RFDs that have gone", []*rfd{first}, true, []plumbing.Hash{first.BlobHash}, "switching", nil},
+	}
+
+	for _, test := range tests {
+
+		if changed := index.update(test.rfds); changed != test.changed {
+			t.Errorf("%s: expected the index to have changed to be %v", test.name, test.changed)
+		}
+
+		if len(index.Documents) != len(test.documents) {
+			t.Errorf("%s: expected %d documents, got %d", test.name, len(test.documents), len(index.Documents))
+		}
+		for _, hash := range test.documents {
+			if _, ok := index.Documents[hash.String()]; !ok {
+				t.Errorf("%s: expected %s to be indexed", test.name, hash)
+			}
+		}
+
+		var ids []string
+		for _, result := range index.search(test.rfds, test.query) {
+			ids = append(ids, result.RFD.ID)
+		}
+		if !reflect.DeepEqual(ids, test.expected) {
+			t.Errorf("%s: expected %q to find %v, got %v", test.name, test.query, test.expected, ids)
+		}
+	}
+
+	if _, ok := index.Postings["routing"]; ok {
+		t.Errorf("Expected no postings for a term no longer in any readme")
+	}
+}
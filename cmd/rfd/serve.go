@@ -0,0 +1,521 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	localConfig "github.com/redazzo/rfd/cmd/rfd/internal/config"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+
+Serving the RFDs as a web UI, rendered live from the repository:
+
+1. Gather the RFDs as the static site does, from the trunk and their branches, then replace those checked
+   out with their copies in the working tree.
+2. Render them, and bring the search index up to date.
+3. Serve pages laid out as the static site is, plus a search page and a history page for each RFD. With
+   --api, serve the JSON API in api.go instead.
+4. Every POLL_INTERVAL, check whether any ref or any file in an RFD directory has changed. If so, go back
+   to 1, and tell open pages to reload.
+
+*/
+
+const POLL_INTERVAL = time.Second
+
+const HISTORY_LIMIT = 100
+
+type rfdServer struct {
+
+	// Serve the JSON API rather than the web UI
+	api bool
+
+	// Guards the RFDs, search index and version
+	mutex   sync.RWMutex
+	rfds    []*siteRFD
+	byID    map[string]*siteRFD
+	index   *searchIndex
+	version int
+
+	// Guards the repository, which isn't safe to read from more than one goroutine at a time
+	git         sync.Mutex
+	repository  *git.Repository
+	fingerprint string
+}
+
+type siteSearchResult struct {
+	RFD     *siteRFD
+	Snippet template.HTML
+}
+
+type siteCommit struct {
+	ShortHash string
+	Date      string
+	Author    string
+	Message   string
+}
+
+func serveRFDs(addr string, api bool) error {
+
+	index, err := loadSearchIndex()
+	if err != nil {
+		return err
+	}
+
+	server := &rfdServer{index: index, api: api}
+	err = server.load()
+	if err != nil {
+		return err
+	}
+
+	// Changes are made while holding the repository, so don't wait for their notifications
+	notifyInBackground = true
+
+	go server.watch()
+
+	url := addr
+	if strings.HasPrefix(url, ":") {
+		url = "localhost" + url
+	}
+	fmt.Println("Serving RFDs on http://" + url + "/")
+
+	return http.ListenAndServe(addr, server)
+}
+
+// load reads and renders the RFDs afresh.
+func (s *rfdServer) load() error {
+
+	s.git.Lock()
+	defer s.git.Unlock()
+
+	// Opened again each time, so nothing read before the change is cached
+	r, err := git.PlainOpen(".")
+	if err != nil {
+		return err
+	}
+
+	s.fingerprint = s.getFingerprint(r)
+
+	rfds := readWorkingTreeRFDs(r, collectRFDs(r))
+	pages, err := getSiteRFDs(rfds)
+	if err != nil {
+		return err
+	}
+
+	byID := make(map[string]*siteRFD)
+	for _, page := range pages {
+		byID[page.ID] = page
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.index.update(rfds) {
+		err = s.index.save()
+		if err != nil {
+			localConfig.Logger.TraceLog("Unable to save the search index: " + err.Error())
+		}
+	}
+
+	s.repository = r
+	s.rfds = pages
+	s.byID = byID
+	s.version++
+
+	return nil
+}
+
+// watch reloads the RFDs whenever a ref, or a file in an RFD directory, changes.
+func (s *rfdServer) watch() {
+
+	for range time.Tick(POLL_INTERVAL) {
+
+		s.git.Lock()
+		changed := s.getFingerprint(s.repository) != s.fingerprint
+		s.git.Unlock()
+
+		if !changed {
+			continue
+		}
+
+		localConfig.Logger.TraceLog("Reloading RFDs")
+		err := s.load()
+		if err != nil {
+			fmt.Println("Unable to reload the RFDs: " + err.Error())
+		}
+	}
+}
+
+// getFingerprint summarises the state of the refs and RFD directories, so that a change to either can be
+// spotted by comparing it with the last.
+func (s *rfdServer) getFingerprint(r *git.Repository) string {
+
+	var fingerprint strings.Builder
+
+	head, err := r.Reference(plumbing.HEAD, false)
+	if err == nil {
+		fingerprint.WriteString(head.String() + "\n")
+	}
+
+	refs, err := r.References()
+	if err == nil {
+		refs.ForEach(func(ref *plumbing.Reference) error {
+			fingerprint.WriteString(ref.String() + "\n")
+			return nil
+		})
+	}
+
+	entries, _ := ioutil.ReadDir(localConfig.APP_CONFIG.RootDirectory)
+	for _, entry := range entries {
+
+		isRFDDirectory, _ := localConfig.IsRFDIDFormat(entry.Name())
+		if !isRFDDirectory || !entry.IsDir() {
+			continue
+		}
+
+		filepath.Walk(filepath.Join(localConfig.APP_CONFIG.RootDirectory, entry.Name()), func(name string, info os.FileInfo, err error) error {
+			if err == nil {
+				fmt.Fprintf(&fingerprint, "%s %d %d\n", name, info.Size(), info.ModTime().UnixNano())
+			}
+			return nil
+		})
+	}
+
+	return fingerprint.String()
+}
+
+func (s *rfdServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+
+	requestPath := path.Clean("/" + req.URL.Path)
+	if strings.HasSuffix(req.URL.Path, "/") && requestPath != "/" {
+		requestPath += "/"
+	}
+
+	// Only the web UI's forms are posted to
+	isForm := !s.api && (requestPath == "/new.html" || strings.HasSuffix(requestPath, "/edit.html") || strings.HasSuffix(requestPath, "/state"))
+	if req.Method != http.MethodGet && req.Method != http.MethodHead && !(isForm && req.Method == http.MethodPost) {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if s.api {
+		s.serveAPI(w, req, requestPath)
+		return
+	}
+
+	switch {
+	case requestPath == "/" || requestPath == "/index.html":
+		s.serveIndex(w)
+	case requestPath == "/new.html":
+		s.serveNew(w, req)
+	case requestPath == "/search.html":
+		s.serveSearch(w, req.URL.Query().Get("q"))
+	case requestPath == "/_events":
+		s.serveEvents(w, req)
+	case strings.HasPrefix(requestPath, "/states/") || strings.HasPrefix(requestPath, "/authors/"):
+		s.serveList(w, req, requestPath[1:])
+	default:
+		s.serveRFD(w, req, requestPath[1:])
+	}
+}
+
+func (s *rfdServer) serveIndex(w http.ResponseWriter) {
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	s.writePage(w, http.StatusOK, "index.html", &sitePage{
+		Title:   "Index of Requests for Discussion",
+		RFDs:    s.rfds,
+		States:  getStateLinks(s.rfds),
+		Authors: getAuthorLinks(s.rfds),
+		Version: s.version,
+	})
+}
+
+func (s *rfdServer) serveList(w http.ResponseWriter, req *http.Request, pagePath string) {
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for _, links := range [][]siteLink{getStateLinks(s.rfds), getAuthorLinks(s.rfds)} {
+		for _, link := range links {
+			if link.Path != pagePath {
+				continue
+			}
+			title := "RFDs in the " + link.Name + " state"
+			if strings.HasPrefix(link.Path, "authors/") {
+				title = "RFDs by " + link.Name
+			}
+			s.writePage(w, http.StatusOK, "list.html", &sitePage{
+				Title:   title,
+				Root:    "../",
+				RFDs:    filterSiteRFDs(s.rfds, link.Path),
+				Version: s.version,
+			})
+			return
+		}
+	}
+
+	http.NotFound(w, req)
+}
+
+func (s *rfdServer) serveSearch(w http.ResponseWriter, query string) {
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var sources []*rfd
+	for _, page := range s.rfds {
+		sources = append(sources, page.Source)
+	}
+
+	var results []siteSearchResult
+	for _, result := range s.index.search(sources, query) {
+		results = append(results, siteSearchResult{
+			RFD:     s.byID[result.RFD.ID],
+			Snippet: highlightTermsHTML(result.Snippet, queryTerms(query)),
+		})
+	}
+
+	s.writePage(w, http.StatusOK, "search.html", &sitePage{
+		Title:   "Search results for \"" + query + "\"",
+		Query:   query,
+		Results: results,
+		Version: s.version,
+	})
+}
+
+// serveRFD serves an RFD's page, its history, or one of its attachments.
+func (s *rfdServer) serveRFD(w http.ResponseWriter, req *http.Request, pagePath string) {
+
+	id, name := pagePath, ""
+	if slash := strings.Index(pagePath, "/"); slash >= 0 {
+		id, name = pagePath[:slash], pagePath[slash+1:]
+	}
+
+	s.mutex.RLock()
+	found := s.byID[id]
+	version := s.version
+	s.mutex.RUnlock()
+
+	if found == nil {
+		http.NotFound(w, req)
+		return
+	}
+
+	switch name {
+	case "", "index.html":
+		if !strings.Contains(pagePath, "/") {
+			http.Redirect(w, req, "/"+id+"/", http.StatusMovedPermanently)
+			return
+		}
+		s.writePage(w, http.StatusOK, "rfd.html", &sitePage{
+			Title:   "RFD " + found.ID + ": " + found.Title,
+			Root:    "../",
+			RFD:     found,
+			Version: version,
+		})
+
+	case "edit.html":
+		s.serveEdit(w, req, found)
+
+	case "state":
+		s.serveTransition(w, req, found)
+
+	case "history.html":
+		history, err := s.getHistory(found.Source)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.writePage(w, http.StatusOK, "history.html", &sitePage{
+			Title:   "History of RFD " + found.ID + ": " + found.Title,
+			Root:    "../",
+			RFD:     found,
+			History: getSiteCommits(history),
+			Version: version,
+		})
+
+	default:
+		content, err := s.readAttachment(found.Source, name)
+		if err != nil {
+			http.NotFound(w, req)
+			return
+		}
+		http.ServeContent(w, req, name, time.Time{}, bytes.NewReader(content))
+	}
+}
+
+// serveEvents sends the version of the RFDs to the page as server-sent events, whenever it changes.
+func (s *rfdServer) serveEvents(w http.ResponseWriter, req *http.Request) {
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	ticker := time.NewTicker(POLL_INTERVAL)
+	defer ticker.Stop()
+
+	sent := -1
+	for {
+		s.mutex.RLock()
+		version := s.version
+		s.mutex.RUnlock()
+
+		if version != sent {
+			fmt.Fprintf(w, "data: %d\n\n", version)
+			flusher.Flush()
+			sent = version
+		}
+
+		select {
+		case <-req.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// getHistory returns the commits that changed an RFD's directory, newest first.
+func (s *rfdServer) getHistory(found *rfd) ([]*object.Commit, error) {
+
+	s.git.Lock()
+	defer s.git.Unlock()
+
+	commits, err := s.repository.Log(&git.LogOptions{
+		From:  found.Commit.Hash,
+		Order: git.LogOrderCommitterTime,
+		PathFilter: func(name string) bool {
+			return strings.HasPrefix(name, found.ID+"/")
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer commits.Close()
+
+	var history []*object.Commit
+	for len(history) < HISTORY_LIMIT {
+
+		commit, err := commits.Next()
+		if err != nil {
+			break
+		}
+		history = append(history, commit)
+	}
+
+	return history, nil
+}
+
+func getSiteCommits(commits []*object.Commit) []siteCommit {
+
+	var history []siteCommit
+	for _, commit := range commits {
+		history = append(history, siteCommit{
+			ShortHash: commit.Hash.String()[:7],
+			Date:      commit.Author.When.Format("2006-01-02 15:04"),
+			Author:    commit.Author.Name,
+			Message:   getCommitSubject(commit),
+		})
+	}
+
+	return history
+}
+
+func getCommitSubject(commit *object.Commit) string {
+	return strings.SplitN(strings.TrimSpace(commit.Message), "\n", 2)[0]
+}
+
+// readAttachment reads a file from an RFD's directory, from wherever the RFD itself was read.
+func (s *rfdServer) readAttachment(found *rfd, name string) ([]byte, error) {
+
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+
+	if found.WorkingTree {
+		return os.ReadFile(filepath.Join(localConfig.APP_CONFIG.RootDirectory, found.ID, filepath.FromSlash(name)))
+	}
+
+	s.git.Lock()
+	defer s.git.Unlock()
+
+	tree, err := found.Commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := tree.File(found.ID + "/" + name)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := file.Contents()
+	return []byte(content), err
+}
+
+// writePage renders a page in full before writing it, so that a template error results in an error
+// response rather than half a page.
+func (s *rfdServer) writePage(w http.ResponseWriter, status int, templateName string, page *sitePage) {
+
+	tmpl, err := loadSiteTemplate(templateName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	page.Organisation = localConfig.APP_CONFIG.Organisation
+	page.Live = true
+	page.Editable = isWebEditingEnabled()
+	page.StateNames = getStateNames()
+	if page.Version == 0 {
+		// Pages rendered while holding the lock set their own version
+		s.mutex.RLock()
+		page.Version = s.version
+		s.mutex.RUnlock()
+	}
+
+	var buf bytes.Buffer
+	err = tmpl.ExecuteTemplate(&buf, "layout", page)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
+
+// highlightTermsHTML marks the query terms in a snippet for display on a page.
+func highlightTermsHTML(snippet string, terms []string) template.HTML {
+
+	escaped := template.HTMLEscapeString(snippet)
+	if len(terms) == 0 {
+		return template.HTML(escaped)
+	}
+
+	var quoted []string
+	for _, term := range terms {
+		quoted = append(quoted, regexp.QuoteMeta(template.HTMLEscapeString(term)))
+	}
+	pattern := regexp.MustCompile(`(?i)(` + strings.Join(quoted, "|") + `)`)
+
+	return template.HTML(pattern.ReplaceAllString(escaped, "<mark>$1</mark>"))
+}
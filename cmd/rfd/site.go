@@ -0,0 +1,428 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	localConfig "github.com/redazzo/rfd/cmd/rfd/internal/config"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+/*
+
+Building a static site of every RFD, merged or not:
+
+1. Gather the RFDs from the trunk and their branches.
+2. Render each RFD's readme.md to nnnn/index.html, and copy the other files in its directory alongside.
+3. Render the index page, and a page for each state and author.
+
+The HTML templates are built in, but any of them can be replaced by putting a file of the same name
+(layout.html, index.html, rfd.html, list.html, and for the web UI search.html, history.html, new.html
+and edit.html) in the site directory of the templates directory.
+
+*/
+
+//go:embed site/*.html
+var siteTemplates embed.FS
+
+type sitePage struct {
+	Title        string
+	Root         string
+	Organisation string
+	RFDs         []*siteRFD
+	States       []siteLink
+	Authors      []siteLink
+	RFD          *siteRFD
+
+	// Set only when pages are served by the web UI
+	Live       bool
+	Version    int
+	Query      string
+	Results    []siteSearchResult
+	History    []siteCommit
+	Editable   bool
+	StateNames []string
+	Form       map[string]string
+	Message    string
+}
+
+type siteRFD struct {
+	ID         string
+	Title      string
+	State      string
+	StatePath  string
+	Branch     string
+	Merged     bool
+	Authors    []siteLink
+	Discussion string
+	Metadata   map[string]interface{}
+	Fields     []siteField
+	Threads    []siteThread
+	Body       template.HTML
+	Source     *rfd
+}
+
+type siteLink struct {
+	Name  string
+	Path  string
+	Count int
+}
+
+type siteField struct {
+	Name  string
+	Value string
+}
+
+// siteThread is an unresolved comment thread on an RFD.
+type siteThread struct {
+	ID       int
+	Anchor   string
+	Quote    string
+	Comments []siteComment
+}
+
+type siteComment struct {
+	Author string
+	Date   string
+	Text   string
+}
+
+func getSiteTemplatesDirectory() string {
+	return localConfig.APP_CONFIG.TemplatesDirectory + localConfig.PATH_SEPARATOR + "site"
+}
+
+// loadSiteTemplate parses the layout along with the named page template, preferring the user's own
+// copies of either over the built in ones.
+func loadSiteTemplate(page string) (*template.Template, error) {
+
+	tmpl := template.New("layout")
+
+	for _, name := range []string{"layout.html", page} {
+
+		content, err := os.ReadFile(getSiteTemplatesDirectory() + localConfig.PATH_SEPARATOR + name)
+		if os.IsNotExist(err) {
+			content, err = siteTemplates.ReadFile("site/" + name)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = tmpl.New(name).Parse(string(content))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+	}
+
+	return tmpl, nil
+}
+
+func buildSite(outputDirectory string) error {
+
+	r, err := git.PlainOpen(".")
+	localConfig.CheckFatal(err)
+
+	rfds, err := getSiteRFDs(collectRFDs(r))
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(outputDirectory, 0755)
+	if err != nil {
+		return err
+	}
+
+	for _, found := range rfds {
+
+		localConfig.Logger.TraceLog("Rendering " + found.ID)
+
+		err = writeSitePage(outputDirectory, found.ID+"/index.html", "rfd.html", &sitePage{
+			Title: "RFD " + found.ID + ": " + found.Title,
+			Root:  "../",
+			RFD:   found,
+		})
+		if err != nil {
+			return err
+		}
+
+		err = copyAttachments(found.Source, filepath.Join(outputDirectory, found.ID))
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, pages := range [][]siteLink{getStateLinks(rfds), getAuthorLinks(rfds)} {
+		for _, link := range pages {
+			title := "RFDs in the " + link.Name + " state"
+			if strings.HasPrefix(link.Path, "authors/") {
+				title = "RFDs by " + link.Name
+			}
+			err = writeSitePage(outputDirectory, link.Path, "list.html", &sitePage{
+				Title: title,
+				Root:  "../",
+				RFDs:  filterSiteRFDs(rfds, link.Path),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err = writeSitePage(outputDirectory, "index.html", "index.html", &sitePage{
+		Title:   "Index of Requests for Discussion",
+		RFDs:    rfds,
+		States:  getStateLinks(rfds),
+		Authors: getAuthorLinks(rfds),
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Site built in " + outputDirectory)
+	return nil
+}
+
+// getSiteRFDs renders RFDs ready to be laid out on a page.
+func getSiteRFDs(rfds []*rfd) ([]*siteRFD, error) {
+
+	var result []*siteRFD
+
+	for _, found := range rfds {
+
+		body, _, err := renderRFD(found.ID, found.Content)
+		if err != nil {
+			return nil, fmt.Errorf("rendering RFD %s: %v", found.ID, err)
+		}
+
+		page := &siteRFD{
+			ID:         found.ID,
+			Title:      found.Title(),
+			State:      found.State(),
+			StatePath:  "states/" + slugify(found.State()) + ".html",
+			Branch:     found.Branch,
+			Merged:     found.Merged,
+			Discussion: metadataString(found.Metadata, "discussion"),
+			Metadata:   found.Metadata,
+			Body:       body,
+			Source:     found,
+		}
+		for _, key := range getOtherMetadataKeys(found.Metadata) {
+			page.Fields = append(page.Fields, siteField{Name: key, Value: formatMetadataValue(found.Metadata[key])})
+		}
+		for _, name := range getAuthorNames(found.Metadata) {
+			page.Authors = append(page.Authors, siteLink{Name: name, Path: "authors/" + slugify(name) + ".html"})
+		}
+
+		threads, err := readCommentThreads(found)
+		if err != nil {
+			localConfig.Logger.TraceLog("Unable to read the comments on RFD " + found.ID + ": " + err.Error())
+			threads = &commentThreads{}
+		}
+		lines := strings.Split(string(found.Content), "\n")
+		for _, thread := range threads.unresolved() {
+			siteThread := siteThread{ID: thread.ID, Anchor: describeThreadAnchor(thread, lines), Quote: thread.Quote}
+			for _, comment := range thread.Comments {
+				siteThread.Comments = append(siteThread.Comments, siteComment{
+					Author: comment.Author,
+					Date:   comment.Date.Local().Format("2006-01-02 15:04"),
+					Text:   comment.Text,
+				})
+			}
+			page.Threads = append(page.Threads, siteThread)
+		}
+
+		result = append(result, page)
+	}
+
+	return result, nil
+}
+
+// getOtherMetadataKeys returns the keys of the metadata that don't have a place of their own on an RFD's
+// page, sorted.
+func getOtherMetadataKeys(metadata map[string]interface{}) []string {
+
+	var keys []string
+	for key := range metadata {
+		switch strings.ToLower(key) {
+		case "id", "title", "state", "authors", "discussion":
+		default:
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// formatMetadataValue formats a metadata value for display, listing the items of a list with commas.
+func formatMetadataValue(value interface{}) string {
+
+	if items, ok := value.([]interface{}); ok {
+		var values []string
+		for _, item := range items {
+			values = append(values, formatMetadataValue(item))
+		}
+		return strings.Join(values, ", ")
+	}
+
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprintf("%v", toJSONValue(value))
+}
+
+// getStateLinks returns a link for each state in use, in the order the states are configured.
+func getStateLinks(rfds []*siteRFD) []siteLink {
+
+	counts := make(map[string]int)
+	for _, found := range rfds {
+		counts[found.State]++
+	}
+
+	var links []siteLink
+	for _, state := range getStateNames() {
+		if counts[state] > 0 {
+			links = append(links, siteLink{Name: state, Path: "states/" + slugify(state) + ".html", Count: counts[state]})
+			delete(counts, state)
+		}
+	}
+
+	// States in use that aren't configured
+	var others []string
+	for state := range counts {
+		others = append(others, state)
+	}
+	sort.Strings(others)
+	for _, state := range others {
+		links = append(links, siteLink{Name: state, Path: "states/" + slugify(state) + ".html", Count: counts[state]})
+	}
+
+	return links
+}
+
+func getAuthorLinks(rfds []*siteRFD) []siteLink {
+
+	authors := make(map[string]*siteLink)
+	for _, found := range rfds {
+		for _, author := range found.Authors {
+			if authors[author.Path] == nil {
+				authors[author.Path] = &siteLink{Name: author.Name, Path: author.Path}
+			}
+			authors[author.Path].Count++
+		}
+	}
+
+	var links []siteLink
+	for _, link := range authors {
+		links = append(links, *link)
+	}
+	sort.Slice(links, func(i, j int) bool {
+		return strings.ToLower(links[i].Name) < strings.ToLower(links[j].Name)
+	})
+
+	return links
+}
+
+// filterSiteRFDs returns the RFDs listed on a state or author page.
+func filterSiteRFDs(rfds []*siteRFD, pagePath string) []*siteRFD {
+
+	var result []*siteRFD
+	for _, found := range rfds {
+		if found.StatePath == pagePath {
+			result = append(result, found)
+			continue
+		}
+		for _, author := range found.Authors {
+			if author.Path == pagePath {
+				result = append(result, found)
+				break
+			}
+		}
+	}
+
+	return result
+}
+
+// getStateNames returns the names of the configured states, in order.
+func getStateNames() []string {
+
+	var names []string
+	for _, state := range localConfig.APP_STATES.RFDStates {
+		for _, m := range state {
+			names = append(names, m["name"])
+		}
+	}
+
+	return names
+}
+
+func writeSitePage(outputDirectory string, pagePath string, templateName string, page *sitePage) error {
+
+	tmpl, err := loadSiteTemplate(templateName)
+	if err != nil {
+		return err
+	}
+
+	page.Organisation = localConfig.APP_CONFIG.Organisation
+
+	fileName := filepath.Join(outputDirectory, filepath.FromSlash(pagePath))
+	err = os.MkdirAll(filepath.Dir(fileName), 0755)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return tmpl.ExecuteTemplate(file, "layout", page)
+}
+
+// copyAttachments copies everything in an RFD's directory, other than its readme, to the output directory.
+func copyAttachments(found *rfd, outputDirectory string) error {
+
+	tree, err := found.Commit.Tree()
+	if err != nil {
+		return err
+	}
+
+	directory, err := tree.Tree(found.ID)
+	if err != nil {
+		return err
+	}
+
+	return directory.Files().ForEach(func(file *object.File) error {
+
+		if file.Name == found.Path[len(found.ID)+1:] {
+			return nil
+		}
+
+		target := filepath.Join(outputDirectory, filepath.FromSlash(file.Name))
+		err := os.MkdirAll(filepath.Dir(target), 0755)
+		if err != nil {
+			return err
+		}
+
+		reader, err := file.Reader()
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+
+		output, err := os.Create(target)
+		if err != nil {
+			return err
+		}
+		defer output.Close()
+
+		_, err = io.Copy(output, reader)
+		return err
+	})
+}
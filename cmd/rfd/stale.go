@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	localConfig "github.com/redazzo/rfd/cmd/rfd/internal/config"
+	"github.com/redazzo/rfd/cmd/rfd/internal/notify"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+/*
+
+Finding RFDs that have been in a state for longer than expected. Each state in states.yml can say how long
+an RFD is expected to stay in it, and what to do with those that stay longer:
+
+    - state:
+        id: 1
+        name: draft
+        max-age: 90d
+        stale-state: abandoned
+        grace-period: 30d
+
+1. Work out from each RFD's history when it entered the state it's in, and list those that have been there
+   for longer than the state's max-age.
+2. With --notify, send a stale event to the notification channels, no more than once every --remind-every
+   for each RFD. Reminders sent are recorded in .git/rfd/stale-reminders.json.
+3. With --move, move RFDs that are still there a grace-period after going stale to the state's stale-state.
+   RFDs found only on the trunk have no branch to move, so they're listed for moving on the trunk by hand.
+
+*/
+
+const MAX_AGE_SETTING = "max-age"
+const STALE_STATE_SETTING = "stale-state"
+const GRACE_PERIOD_SETTING = "grace-period"
+
+const DEFAULT_REMINDER_INTERVAL = "7d"
+
+// staleReminder records the last reminder sent about a stale RFD.
+type staleReminder struct {
+	State string    `json:"state"`
+	Sent  time.Time `json:"sent"`
+}
+
+func getStaleRemindersLocation() string {
+	return localConfig.GetRFDStateDirectory() + localConfig.PATH_SEPARATOR + "stale-reminders.json"
+}
+
+func findStaleRFDs(notifyAuthors bool, move bool, remindEvery string) error {
+
+	reminderInterval, err := parseAge(remindEvery)
+	if err != nil {
+		return err
+	}
+
+	var notifier *notify.Notifier
+	if notifyAuthors {
+		notifier, err = getNotifier()
+		if err != nil {
+			return err
+		}
+		if notifier == nil {
+			return fmt.Errorf("no notification channels are configured, see the notifications section of config.yml")
+		}
+	}
+
+	r, err := git.PlainOpen(".")
+	localConfig.CheckFatal(err)
+
+	if !localConfig.APP_CONFIG.Offline {
+		fmt.Println("Fetching from origin ...")
+		err = localConfig.FetchFromOrigin(r)
+		if err != nil {
+			fmt.Println("Unable to fetch from origin, using the last fetched remote branches: " + err.Error())
+		}
+	}
+
+	now := time.Now()
+
+	type staleRFD struct {
+		found   *rfd
+		entered time.Time
+		overdue time.Duration
+	}
+	var stale []staleRFD
+
+	for _, found := range collectRFDs(r) {
+
+		maxAge, err := getStateAge(found.State(), MAX_AGE_SETTING)
+		if err != nil {
+			return err
+		}
+		if maxAge == 0 {
+			continue
+		}
+
+		changes, err := getStateChanges(r, found)
+		if err != nil {
+			return err
+		}
+		entered := getStateEntered(found, changes)
+		if entered.IsZero() || now.Sub(entered) <= maxAge {
+			continue
+		}
+
+		overdue := now.Sub(entered) - maxAge
+		stale = append(stale, staleRFD{found: found, entered: entered, overdue: overdue})
+	}
+
+	if len(stale) == 0 {
+		fmt.Println("No RFDs have been in their state for longer than expected")
+		return nil
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "ID\tTITLE\tSTATE\tSINCE\tOVERDUE\tAUTHORS")
+	for _, s := range stale {
+		fmt.Fprintln(writer, strings.Join([]string{
+			s.found.ID,
+			s.found.Title(),
+			s.found.State(),
+			s.entered.Format("2006-01-02"),
+			formatAge(s.overdue),
+			strings.Join(getAuthorNames(s.found.Metadata), ", "),
+		}, "\t"))
+	}
+	err = writer.Flush()
+	if err != nil {
+		return err
+	}
+
+	if notifyAuthors {
+		reminders, err := readStaleReminders()
+		if err != nil {
+			return err
+		}
+
+		for _, s := range stale {
+			last, ok := reminders[s.found.ID]
+			if ok && last.State == s.found.State() && now.Sub(last.Sent) < reminderInterval {
+				continue
+			}
+
+			event := newEvent(notify.STALE, getRFDFields(s.found))
+			event.Since = &s.entered
+			err = notifier.Notify(event)
+			if err != nil {
+				fmt.Println(s.found.ID + "  unable to send a reminder: " + err.Error())
+				continue
+			}
+			reminders[s.found.ID] = staleReminder{State: s.found.State(), Sent: now}
+			fmt.Println(s.found.ID + "  sent a reminder")
+		}
+
+		err = writeStaleReminders(reminders)
+		if err != nil {
+			return err
+		}
+	}
+
+	if move {
+		trunkName := getTrunkBranchName(r)
+		for _, s := range stale {
+
+			staleState := getStateSetting(s.found.State(), STALE_STATE_SETTING)
+			if staleState == "" || staleState == s.found.State() {
+				continue
+			}
+			gracePeriod, err := getStateAge(s.found.State(), GRACE_PERIOD_SETTING)
+			if err != nil {
+				return err
+			}
+			if s.overdue <= gracePeriod {
+				continue
+			}
+
+			if s.found.Branch == trunkName {
+				fmt.Println(s.found.ID + "  has no branch, so change its state: field to " + staleState + " on the trunk")
+				continue
+			}
+
+			err = transitionRFD(r, s.found.ID, staleState, nil)
+			if err != nil {
+				fmt.Println(s.found.ID + "  unable to move to " + staleState + ": " + err.Error())
+				continue
+			}
+			fmt.Println(s.found.ID + "  moved to " + staleState)
+		}
+	}
+
+	return nil
+}
+
+// getStateSetting returns a setting of a state from states.yml, or "" if it isn't set.
+func getStateSetting(state string, setting string) string {
+
+	for _, configured := range localConfig.APP_STATES.RFDStates {
+		for _, m := range configured {
+			if m["name"] == state {
+				return strings.TrimSpace(m[setting])
+			}
+		}
+	}
+
+	return ""
+}
+
+// getStateAge returns a length of time set for a state in states.yml, or 0 if it isn't set.
+func getStateAge(state string, setting string) (time.Duration, error) {
+
+	value := getStateSetting(state, setting)
+	if value == "" {
+		return 0, nil
+	}
+
+	age, err := parseAge(value)
+	if err != nil {
+		return 0, fmt.Errorf("the %s of the %s state in states.yml: %v", setting, state, err)
+	}
+	return age, nil
+}
+
+func readStaleReminders() (map[string]staleReminder, error) {
+
+	reminders := make(map[string]staleReminder)
+
+	content, err := os.ReadFile(getStaleRemindersLocation())
+	if os.IsNotExist(err) {
+		return reminders, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(content, &reminders)
+	return reminders, err
+}
+
+func writeStaleReminders(reminders map[string]staleReminder) error {
+
+	err := os.MkdirAll(localConfig.GetRFDStateDirectory(), 0755)
+	if err != nil {
+		return err
+	}
+
+	content, err := json.MarshalIndent(reminders, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(getStaleRemindersLocation(), content, 0644)
+}
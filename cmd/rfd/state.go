@@ -0,0 +1,279 @@
+package main
+
+import (
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	localConfig "github.com/redazzo/rfd/cmd/rfd/internal/config"
+	"github.com/redazzo/rfd/cmd/rfd/internal/notify"
+	"os"
+	"strings"
+)
+
+/*
+
+Changing an RFD, whether from the command line or the web UI, without needing it checked out:
+
+1. Find the RFD's branch, creating it from the remote branch or the trunk if there's no local branch.
+2. Apply the change to the branch's copy of the readme, regenerate the index, and commit both to the branch.
+3. If the branch is checked out here, and the working tree is clean, bring the working tree up to date.
+   If it isn't clean, refuse rather than leave the working tree behind its branch.
+4. Push the branch, or queue it for "rfd sync" if that isn't possible.
+
+*/
+
+const NEXT_STATES_SETTING = "next"
+
+// setRFDState moves an RFD to another state, as 'rfd state' does.
+func setRFDState(rfdId string, state string) error {
+
+	r := openRepositoryToChange(rfdId)
+
+	err := transitionRFD(r, rfdId, state, nil)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("RFD " + rfdId + " is now in the " + state + " state")
+	return nil
+}
+
+// openRepositoryToChange opens the repository to change an RFD from the command line, first fetching
+// the RFD's remote branch if there's no local branch to change.
+func openRepositoryToChange(rfdId string) *git.Repository {
+
+	r, err := git.PlainOpen(".")
+	localConfig.CheckFatal(err)
+
+	if getCommit(r, plumbing.NewBranchReferenceName(rfdId)) == nil && !localConfig.APP_CONFIG.Offline {
+		fmt.Println("Fetching from origin ...")
+		err = localConfig.FetchFromOrigin(r)
+		if err != nil {
+			fmt.Println("Unable to fetch from origin, using the last fetched remote branches: " + err.Error())
+		}
+	}
+
+	return r
+}
+
+// transitionRFD moves an RFD to another state, committing the change to its branch as author, or as the
+// configured git user if author is nil. Moving to discussion also opens a pull request if there's a forge.
+// The pre-transition hook can refuse the move.
+func transitionRFD(r *git.Repository, rfdId string, state string, author *object.Signature) error {
+
+	if !isConfiguredState(state) {
+		return fmt.Errorf("%q isn't a configured state, expected one of: %s", state, strings.Join(getStateNames(), ", "))
+	}
+	if state == ACCEPTED_STATE {
+		err := checkQuorum(r, rfdId)
+		if err != nil {
+			return err
+		}
+	}
+
+	var from string
+	err := updateRFDFiles(r, rfdId, func(found *rfd) (map[string][]byte, error) {
+		from = found.State()
+		if from == state {
+			return nil, fmt.Errorf("RFD %s is already in the %s state", rfdId, state)
+		}
+		if !isAllowedTransition(from, state) {
+			return nil, fmt.Errorf("RFD %s can't move from %s to %s, states.yml only allows it to move to: %s", rfdId, from, state, strings.Join(getNextStates(from), ", "))
+		}
+		err := runHook(r, PRE_TRANSITION_HOOK, getRFDFields(found), author, getTransitionEnvironment(from, state))
+		if err != nil {
+			return nil, err
+		}
+		return map[string][]byte{found.Path: setFrontMatterField(found.Content, "state", state)}, nil
+	}, rfdId+": Move to "+state, author)
+	if err != nil {
+		return err
+	}
+
+	if state == DISCUSSION_STATE {
+		err = openDiscussion(r, rfdId, author)
+	}
+
+	fields := getBranchRFDFields(r, rfdId)
+	runPostHook(r, POST_TRANSITION_HOOK, fields, author, getTransitionEnvironment(from, state))
+	notifyEvent(r, notify.STATE_CHANGED, fields, author, from)
+
+	if err != nil {
+		return fmt.Errorf("RFD %s was moved to %s, but no pull request was opened: %v", rfdId, state, err)
+	}
+	return nil
+}
+
+// saveRFD replaces the readme of an RFD, committing the change to its branch.
+func saveRFD(r *git.Repository, rfdId string, readme []byte, author *object.Signature) error {
+
+	title := metadataString(parseMetadata(readme), "title")
+
+	return updateRFD(r, rfdId, func(content []byte) ([]byte, error) {
+		if string(content) == string(readme) {
+			return nil, fmt.Errorf("no changes made to RFD %s", rfdId)
+		}
+		return readme, nil
+	}, rfdId+": Update "+title, author)
+}
+
+// updateRFD applies a change to the readme on an RFD's branch, commits it along with the regenerated
+// index, and pushes the branch.
+func updateRFD(r *git.Repository, rfdId string, change func(content []byte) ([]byte, error), message string, author *object.Signature) error {
+	return updateRFDFiles(r, rfdId, func(found *rfd) (map[string][]byte, error) {
+		content, err := change(found.Content)
+		if err != nil {
+			return nil, err
+		}
+		return map[string][]byte{found.Path: content}, nil
+	}, message, author)
+}
+
+// updateRFDFiles is updateRFD for changes to more than the readme. The change is given the RFD as it is
+// on its branch, and returns the files to commit by their path from the root of the repository.
+func updateRFDFiles(r *git.Repository, rfdId string, change func(found *rfd) (map[string][]byte, error), message string, author *object.Signature) error {
+
+	branchName := plumbing.NewBranchReferenceName(rfdId)
+
+	parent := getCommit(r, branchName)
+	created := parent == nil
+	if created {
+		start, err := getRFDBranchStart(r, rfdId)
+		if err != nil {
+			return err
+		}
+		parent = start
+	}
+
+	checkedOut, err := isCheckedOut(r, branchName)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(WORKTREES_DIRECTORY + localConfig.PATH_SEPARATOR + rfdId); err == nil {
+		return fmt.Errorf("RFD %s is checked out in %s, make the change there instead", rfdId, WORKTREES_DIRECTORY+localConfig.PATH_SEPARATOR+rfdId)
+	}
+
+	found := readRFDFromCommit(parent, rfdId, rfdId)
+	if found == nil {
+		return fmt.Errorf("RFD %s has no readme on its branch", rfdId)
+	}
+
+	files, err := change(found)
+	if err != nil {
+		return err
+	}
+
+	parentTree, err := parent.Tree()
+	if err != nil {
+		return err
+	}
+	treeHash, err := buildTree(r, parentTree, files)
+	if err != nil {
+		return err
+	}
+	tree, err := r.TreeObject(treeHash)
+	if err != nil {
+		return err
+	}
+	files["index.md"] = IndexFromTree(tree)
+
+	localConfig.Logger.TraceLog("Committing to " + rfdId + ": " + message)
+	commit, err := commitFilesToBranch(r, rfdId, parent, files, message, author)
+	if err != nil {
+		return err
+	}
+
+	if checkedOut {
+		w, err := r.Worktree()
+		if err != nil {
+			return err
+		}
+		err = w.Reset(&git.ResetOptions{
+			Commit: commit.Hash,
+			Mode:   git.HardReset,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if created {
+		err = setUpstream(r, rfdId)
+		if err != nil {
+			return err
+		}
+	}
+
+	return pushOrQueue(r, rfdId, !localConfig.APP_CONFIG.Offline, false)
+}
+
+// isCheckedOut reports whether a branch is checked out in the working tree, returning an error if it is
+// but the working tree has uncommitted changes.
+func isCheckedOut(r *git.Repository, branchName plumbing.ReferenceName) (bool, error) {
+
+	head, err := r.Head()
+	if err != nil || head.Name() != branchName {
+		return false, nil
+	}
+
+	w, err := r.Worktree()
+	if err != nil {
+		return false, err
+	}
+
+	status, err := localConfig.GetWorktreeStatus(w)
+	if err != nil {
+		return false, err
+	}
+	if !status.IsClean() {
+		return false, fmt.Errorf("branch %s is checked out with uncommitted changes, commit or stash them first", branchName.Short())
+	}
+
+	return true, nil
+}
+
+func isConfiguredState(state string) bool {
+	return containsString(getStateNames(), state)
+}
+
+// getNextStates returns the states that states.yml allows an RFD to move to from a state, or nil if it
+// doesn't restrict them.
+func getNextStates(state string) []string {
+
+	var next []string
+	for _, name := range strings.Split(getStateSetting(state, NEXT_STATES_SETTING), ",") {
+		if strings.TrimSpace(name) != "" {
+			next = append(next, strings.TrimSpace(name))
+		}
+	}
+	return next
+}
+
+// isAllowedTransition reports whether states.yml allows an RFD to move directly from one state to
+// another. A state without a next setting, or one that isn't configured, can move to any other.
+func isAllowedTransition(from string, to string) bool {
+	next := getNextStates(from)
+	return from == to || next == nil || containsString(next, to)
+}
+
+// isReachableState reports whether an RFD can get from one state to another by a series of allowed moves.
+func isReachableState(from string, to string) bool {
+
+	visited := map[string]bool{from: true}
+	queue := []string{from}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		if isAllowedTransition(state, to) {
+			return true
+		}
+		for _, next := range getNextStates(state) {
+			if !visited[next] {
+				visited[next] = true
+				queue = append(queue, next)
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	localConfig "github.com/redazzo/rfd/cmd/rfd/internal/config"
+	"strconv"
+	"strings"
+)
+
+/*
+
+The status of an RFD, as 'rfd status' shows it: its metadata, where its branch has got to, its relations
+to other RFDs, and its unresolved comments. Without an id, it's the RFD whose branch is checked out.
+
+*/
+
+func showStatus(rfdId string) error {
+
+	r, err := git.PlainOpen(".")
+	localConfig.CheckFatal(err)
+
+	if rfdId == "" {
+		head, err := r.Head()
+		if err != nil {
+			return err
+		}
+		isRFDBranch, err := localConfig.IsRFDIDFormat(head.Name().Short())
+		if err != nil || !isRFDBranch {
+			return fmt.Errorf("%s isn't an RFD's branch, give the id of the RFD", head.Name().Short())
+		}
+		rfdId = head.Name().Short()
+	}
+
+	rfds := readWorkingTreeRFDs(r, collectRFDs(r))
+
+	var found *rfd
+	for _, candidate := range rfds {
+		if candidate.ID == rfdId {
+			found = candidate
+		}
+	}
+	if found == nil {
+		return fmt.Errorf("RFD %s wasn't found locally, on the remote, or on the trunk", rfdId)
+	}
+
+	fmt.Println("RFD " + found.ID + ": " + found.Title())
+	fmt.Println()
+	fmt.Println("State:       " + found.State())
+	fmt.Println("Authors:     " + found.Authors())
+
+	branch := found.Branch
+	if found.Merged && found.Branch == getTrunkBranchName(r) {
+		branch += " (merged)"
+	} else if found.Merged {
+		branch += " (merged, with later changes)"
+	} else {
+		branch += " (unmerged)"
+	}
+	if found.WorkingTree {
+		branch += ", checked out"
+	}
+	fmt.Println("Branch:      " + branch)
+
+	queued, err := readPushQueue()
+	if err != nil {
+		return err
+	}
+	if containsString(queued, found.ID) {
+		fmt.Println("             waiting to be pushed by 'rfd sync'")
+	}
+
+	if discussion := metadataString(found.Metadata, "discussion"); discussion != "" {
+		fmt.Println("Discussion:  " + discussion)
+	}
+	if approvals := describeApprovals(r, found); approvals != "" {
+		fmt.Println("Approvals:   " + approvals)
+	}
+
+	relations := getAllRelations(found, rfds)
+	if len(relations) > 0 {
+		fmt.Println()
+		fmt.Println("Relations:")
+		for _, related := range relations {
+			summary := "  " + describeRelation(related)
+			for _, other := range rfds {
+				if other.ID == related.ID {
+					summary += ": " + other.Title() + " (" + other.State() + ")"
+				}
+			}
+			fmt.Println(summary)
+		}
+	}
+
+	threads, err := readCommentThreads(found)
+	if err != nil {
+		return err
+	}
+	unresolved := threads.unresolved()
+
+	fmt.Println()
+	if len(unresolved) == 0 {
+		fmt.Println("No unresolved comments")
+		return nil
+	}
+
+	fmt.Println(strconv.Itoa(len(unresolved)) + " unresolved comment thread(s):")
+	lines := strings.Split(string(found.Content), "\n")
+	for _, thread := range unresolved {
+		summary := "  #" + strconv.Itoa(thread.ID) + "  " + describeThreadAnchor(thread, lines)
+		if len(thread.Comments) > 0 {
+			summary += ": " + thread.Comments[0].Author + ": " + strings.SplitN(thread.Comments[0].Text, "\n", 2)[0]
+		}
+		fmt.Println(summary)
+	}
+
+	return nil
+}
@@ -0,0 +1,444 @@
+package main
+
+import (
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	localConfig "github.com/redazzo/rfd/cmd/rfd/internal/config"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+/*
+
+Syncing with the remote:
+
+1. Fetch from origin, updating the remote-tracking branches, and remove the remote-tracking branches
+   of branches that have been deleted from the remote.
+2. Push each branch queued while offline. If a new RFD's id has been taken in the meantime, either by
+   someone else's branch on the remote or by an RFD merged into the trunk, renumber the RFD to the next
+   free id before pushing it. Existing RFDs keep their ids, and a push origin rejects stays queued.
+3. Fast-forward each local nnnn branch that tracks a remote branch, and report those that can't be
+   fast-forwarded (ahead or diverged), and those whose remote branch has gone, typically because the RFD
+   has been merged. Optionally delete local branches that have been merged, as long as the trunk has
+   everything in the RFD's directory as the branch has it.
+4. Regenerate the index.
+
+*/
+
+func doSync(prune bool) {
+
+	r, err := git.PlainOpen(".")
+	localConfig.CheckFatal(err)
+
+	fmt.Println("Fetching from origin ...")
+	err = localConfig.FetchFromOrigin(r)
+	localConfig.CheckFatal(err)
+
+	err = pruneRemoteTrackingBranches(r)
+	localConfig.CheckFatal(err)
+
+	pushQueuedBranches(r)
+
+	reconcileRFDBranches(r, prune)
+
+	Index()
+	fmt.Println("Index regenerated.")
+}
+
+// pruneRemoteTrackingBranches removes remote-tracking branches whose branch no longer exists on origin.
+func pruneRemoteTrackingBranches(r *git.Repository) error {
+
+	publicKey, err := localConfig.GetPublicKey()
+
+	remote, err := r.Remote("origin")
+	if err != nil {
+		return err
+	}
+
+	refList, err := remote.List(&git.ListOptions{
+		Auth: publicKey,
+	})
+	if err != nil {
+		return err
+	}
+
+	onRemote := make(map[string]bool)
+	for _, ref := range refList {
+		if ref.Name().IsBranch() {
+			onRemote[ref.Name().Short()] = true
+		}
+	}
+
+	refs, err := r.References()
+	if err != nil {
+		return err
+	}
+
+	var stale []plumbing.ReferenceName
+	refs.ForEach(func(ref *plumbing.Reference) error {
+		name := ref.Name().String()
+		prefix := "refs/remotes/origin/"
+		if strings.HasPrefix(name, prefix) && name != prefix+"HEAD" && !onRemote[name[len(prefix):]] {
+			stale = append(stale, ref.Name())
+		}
+		return nil
+	})
+
+	for _, name := range stale {
+		localConfig.Logger.TraceLog("Pruning " + name.String())
+		err = r.Storer.RemoveReference(name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// reconcileRFDBranches brings local RFD branches up to date with their remote branches where that can
+// be done by fast-forwarding, and reports on the rest.
+func reconcileRFDBranches(r *git.Repository, prune bool) {
+
+	repositoryConfig, err := r.Config()
+	localConfig.CheckFatal(err)
+
+	head, err := r.Head()
+	localConfig.CheckFatal(err)
+
+	queued, err := readPushQueue()
+	localConfig.CheckFatal(err)
+
+	trunk := getCommit(r, plumbing.NewRemoteReferenceName("origin", getTrunkBranchName(r)))
+
+	branches, err := r.Branches()
+	localConfig.CheckFatal(err)
+
+	var rfdBranches []*plumbing.Reference
+	branches.ForEach(func(ref *plumbing.Reference) error {
+		isRFDBranch, err := localConfig.IsRFDIDFormat(ref.Name().Short())
+		localConfig.CheckFatal(err)
+		if isRFDBranch && !containsString(queued, ref.Name().Short()) {
+			rfdBranches = append(rfdBranches, ref)
+		}
+		return nil
+	})
+
+	for _, ref := range rfdBranches {
+
+		branch := ref.Name().Short()
+
+		branchConfig, tracked := repositoryConfig.Branches[branch]
+		if !tracked || branchConfig.Remote != "origin" {
+			fmt.Println(branch + ": not tracking a remote branch")
+			continue
+		}
+
+		local, err := r.CommitObject(ref.Hash())
+		localConfig.CheckFatal(err)
+
+		remote := getCommit(r, plumbing.NewRemoteReferenceName("origin", branchConfig.Merge.Short()))
+
+		if remote == nil {
+
+			merged := trunk != nil && (containsCommit(trunk, local) || commitHasDirectory(trunk, branch))
+			if !merged {
+				fmt.Println(branch + ": the remote branch has been deleted, but the RFD hasn't been merged")
+				continue
+			}
+
+			// A squash-merged branch shares no commits with the trunk, and may have been changed since
+			if !containsCommit(trunk, local) && !hasDirectoryOf(trunk, local, branch) {
+				fmt.Println(branch + ": merged, but has changes to the RFD that the trunk doesn't have; not deleted")
+				continue
+			}
+
+			if !prune {
+				fmt.Println(branch + ": merged, and the remote branch has been deleted (use --prune to delete the local branch)")
+				continue
+			}
+
+			if head.Name() == ref.Name() {
+				fmt.Println(branch + ": merged, but not deleted as it is checked out")
+				continue
+			}
+
+			err = deleteBranch(r, branch)
+			localConfig.CheckFatal(err)
+			fmt.Println(branch + ": merged, local branch deleted")
+			continue
+		}
+
+		switch {
+		case local.Hash == remote.Hash:
+			localConfig.Logger.TraceLog(branch + " is up to date")
+
+		case containsCommit(remote, local):
+			if fastForward(r, ref, head, remote) {
+				fmt.Println(branch + ": fast-forwarded to " + remote.Hash.String()[:7])
+			} else {
+				fmt.Println(branch + ": behind the remote, but checked out with local changes; not fast-forwarded")
+			}
+
+		case containsCommit(local, remote):
+			fmt.Println(branch + ": ahead of the remote, push to share your changes")
+
+		default:
+			fmt.Println(branch + ": diverged from the remote, merge or rebase to reconcile")
+		}
+	}
+}
+
+// fastForward moves a branch on to the given commit, updating the working tree if it's checked out. A
+// checked out branch is only fast-forwarded if the working tree is clean.
+func fastForward(r *git.Repository, ref *plumbing.Reference, head *plumbing.Reference, target *object.Commit) bool {
+
+	if head.Name() != ref.Name() {
+		err := r.Storer.SetReference(plumbing.NewHashReference(ref.Name(), target.Hash))
+		localConfig.CheckFatal(err)
+		return true
+	}
+
+	w, err := r.Worktree()
+	localConfig.CheckFatal(err)
+
+	status, err := localConfig.GetWorktreeStatus(w)
+	localConfig.CheckFatal(err)
+	if !status.IsClean() {
+		return false
+	}
+
+	err = w.Reset(&git.ResetOptions{
+		Commit: target.Hash,
+		Mode:   git.HardReset,
+	})
+	localConfig.CheckFatal(err)
+
+	return true
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func pushQueuedBranches(r *git.Repository) {
+
+	queued, err := readQueuedPushes()
+	localConfig.CheckFatal(err)
+
+	var remaining []queuedPush
+
+	for _, push := range queued {
+
+		branch := push.Branch
+		if getCommit(r, plumbing.NewBranchReferenceName(branch)) == nil {
+			fmt.Println("Queued branch " + branch + " no longer exists, removing it from the queue.")
+			continue
+		}
+
+		// Only a new RFD can collide; an existing RFD whose branch has moved on is left to be reconciled
+		if push.New && isRFDIdTaken(r, branch) {
+			renumbered, err := renumberRFD(r, branch)
+			if err != nil {
+				fmt.Println("RFD " + branch + " collides with an RFD on the remote, but couldn't be renumbered: " + err.Error())
+				remaining = append(remaining, push)
+				continue
+			}
+			fmt.Println("RFD " + branch + " collided with an RFD on the remote, and has been renumbered to " + renumbered)
+			branch = renumbered
+		}
+
+		err = localConfig.PushBranchToOrigin(r, branch)
+		if err != nil {
+			if isRemoteReachable(r) {
+				fmt.Println("Origin rejected the push of " + branch + ": " + err.Error() + ". Pull the changes made to it on origin, then run 'rfd sync' again.")
+			} else {
+				fmt.Println("Unable to push " + branch + ": " + err.Error())
+			}
+			remaining = append(remaining, queuedPush{Branch: branch, New: push.New})
+			continue
+		}
+
+		err = setUpstream(r, branch)
+		localConfig.CheckFatal(err)
+
+		fmt.Println("Pushed " + branch)
+	}
+
+	err = writePushQueue(remaining)
+	localConfig.CheckFatal(err)
+}
+
+// isRFDIdTaken reports whether a local, not yet pushed, RFD branch collides with an RFD created
+// elsewhere, as recorded by the remote-tracking branches.
+func isRFDIdTaken(r *git.Repository, branch string) bool {
+
+	remote := getCommit(r, plumbing.NewRemoteReferenceName("origin", branch))
+	if remote != nil {
+		local := getCommit(r, plumbing.NewBranchReferenceName(branch))
+		if local == nil || !containsCommit(local, remote) {
+			return true
+		}
+	}
+
+	trunk := getCommit(r, plumbing.NewRemoteReferenceName("origin", getTrunkBranchName(r)))
+	return commitHasDirectory(trunk, branch)
+}
+
+// renumberRFD moves a local RFD branch, and the RFD directory on it, to the next free id. The renumbered
+// commit is built from the branch's commit, so the current checkout is left alone unless it's of the
+// branch being renumbered, in which case it follows the branch to its new id.
+func renumberRFD(r *git.Repository, oldId string) (string, error) {
+
+	oldBranch := plumbing.NewBranchReferenceName(oldId)
+	parent := getCommit(r, oldBranch)
+
+	checkedOut, err := isCheckedOut(r, oldBranch)
+	if err != nil {
+		return "", err
+	}
+
+	found := readRFDFromCommit(parent, oldId, oldId)
+	if found == nil {
+		return "", fmt.Errorf("RFD %s has no readme on its branch", oldId)
+	}
+
+	newNumber, _, err := getMaxRFDNumber(true)
+	if err != nil {
+		return "", err
+	}
+	newId := formatToNNNN(newNumber + 1)
+	for isRFDIdTaken(r, newId) {
+		newNumber++
+		newId = formatToNNNN(newNumber + 1)
+	}
+
+	localConfig.Logger.TraceLog("Renumbering " + oldId + " to " + newId)
+
+	// Move everything in the RFD's directory, renumbering the readme as it goes
+	parentTree, err := parent.Tree()
+	if err != nil {
+		return "", err
+	}
+	directory, err := parentTree.Tree(oldId)
+	if err != nil {
+		return "", err
+	}
+	files := make(map[string][]byte)
+	err = directory.Files().ForEach(func(file *object.File) error {
+		content, err := file.Contents()
+		if err != nil {
+			return err
+		}
+		files[oldId+"/"+file.Name] = nil
+		files[newId+"/"+file.Name] = []byte(content)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	readme := setFrontMatterField(found.Content, "id", newId)
+	readme = []byte(strings.ReplaceAll(string(readme), "RFD-"+oldId, "RFD-"+newId))
+	files[newId+"/"+strings.TrimPrefix(found.Path, oldId+"/")] = readme
+
+	treeHash, err := buildTree(r, parentTree, files)
+	if err != nil {
+		return "", err
+	}
+	tree, err := r.TreeObject(treeHash)
+	if err != nil {
+		return "", err
+	}
+	files["index.md"], err = indexFromTree(tree)
+	if err != nil {
+		return "", err
+	}
+
+	commit, err := commitFilesToBranch(r, newId, parent, files, "Renumber RFD "+oldId+" to "+newId, nil)
+	if err != nil {
+		return "", err
+	}
+
+	if checkedOut {
+		err = r.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.NewBranchReferenceName(newId)))
+		if err != nil {
+			return "", err
+		}
+		w, err := r.Worktree()
+		if err != nil {
+			return "", err
+		}
+		err = w.Reset(&git.ResetOptions{
+			Commit: commit.Hash,
+			Mode:   git.HardReset,
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	worktree := WORKTREES_DIRECTORY + localConfig.PATH_SEPARATOR + oldId
+	if _, err := os.Stat(worktree); err == nil {
+		err = moveWorktree(oldId, newId)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	err = deleteBranch(r, oldId)
+	if err != nil {
+		return "", err
+	}
+
+	err = setUpstream(r, newId)
+	if err != nil {
+		return "", err
+	}
+
+	return newId, nil
+}
+
+// moveWorktree moves a branch checked out in a linked worktree under WORKTREES_DIRECTORY, by checking
+// the renumbered branch out there and moving the worktree to match. As with addWorktree, this is left
+// to the git command line.
+func moveWorktree(oldId string, newId string) error {
+
+	for _, args := range [][]string{
+		{"-C", WORKTREES_DIRECTORY + "/" + oldId, "checkout", "-q", newId},
+		{"worktree", "move", WORKTREES_DIRECTORY + "/" + oldId, WORKTREES_DIRECTORY + "/" + newId},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		err := cmd.Run()
+		if err != nil {
+			return fmt.Errorf("unable to move the worktree of RFD %s to %s: %v", oldId, newId, err)
+		}
+	}
+
+	return nil
+}
+
+// deleteBranch removes a local branch along with its upstream config.
+func deleteBranch(r *git.Repository, branch string) error {
+
+	err := r.Storer.RemoveReference(plumbing.NewBranchReferenceName(branch))
+	if err != nil {
+		return err
+	}
+
+	err = r.DeleteBranch(branch)
+	if err != nil && err != git.ErrBranchNotFound {
+		return err
+	}
+
+	return nil
+}
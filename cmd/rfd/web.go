@@ -0,0 +1,300 @@
+package main
+
+import (
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	localConfig "github.com/redazzo/rfd/cmd/rfd/internal/config"
+	"github.com/redazzo/rfd/cmd/rfd/internal/notify"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+/*
+
+Creating, editing and transitioning RFDs from the web UI. Each form makes the same branch, commit and push
+as the command line would, authored by the signed in user.
+
+Users are identified in one of two ways, configured in config.yml:
+
+- web-identity-header names a header, such as X-Forwarded-User, set by an authenticating proxy in front of
+  the server. The user's email is taken from web-email-header if set, or from the users file.
+- web-users-file names a YAML file of users with bcrypt hashed passwords, checked with HTTP basic auth:
+
+    users:
+      - username: alice
+        name: Alice Smith
+        email: alice@example.com
+        password: $2y$10$...
+
+If neither is configured, the web UI is read only.
+
+*/
+
+type webUser struct {
+	Username string `yaml:"username"`
+	Name     string `yaml:"name"`
+	Email    string `yaml:"email"`
+	Password string `yaml:"password"`
+}
+
+type webUsers struct {
+	Users []webUser `yaml:"users"`
+}
+
+func isWebEditingEnabled() bool {
+	return localConfig.APP_CONFIG.WebIdentityHeader != "" || localConfig.APP_CONFIG.WebUsersFile != ""
+}
+
+// loadWebUsers reads the users file, if there is one. It's read for each request so that users can be
+// added without restarting the server.
+func loadWebUsers() (*webUsers, error) {
+
+	users := &webUsers{}
+	if localConfig.APP_CONFIG.WebUsersFile == "" {
+		return users, nil
+	}
+
+	content, err := os.ReadFile(localConfig.APP_CONFIG.WebUsersFile)
+	if err != nil {
+		return nil, err
+	}
+
+	err = yaml.Unmarshal(content, users)
+	return users, err
+}
+
+func (users *webUsers) find(username string) *webUser {
+	for i := range users.Users {
+		if users.Users[i].Username == username {
+			return &users.Users[i]
+		}
+	}
+	return nil
+}
+
+// authenticate returns the signature to commit as for the user making a request. If the user can't be
+// identified it writes an error response and returns nil.
+func authenticate(w http.ResponseWriter, req *http.Request) *object.Signature {
+
+	if !isWebEditingEnabled() {
+		http.Error(w, "Editing from the web UI isn't enabled", http.StatusForbidden)
+		return nil
+	}
+
+	// Refuse forms posted from other sites
+	if origin := req.Header.Get("Origin"); origin != "" {
+		parsed, err := url.Parse(origin)
+		if err != nil || parsed.Host != req.Host {
+			http.Error(w, "Cross-origin requests aren't allowed", http.StatusForbidden)
+			return nil
+		}
+	}
+
+	users, err := loadWebUsers()
+	if err != nil {
+		http.Error(w, "Unable to read the users file: "+err.Error(), http.StatusInternalServerError)
+		return nil
+	}
+
+	if header := localConfig.APP_CONFIG.WebIdentityHeader; header != "" {
+		if username := strings.TrimSpace(req.Header.Get(header)); username != "" {
+
+			signature := &object.Signature{Name: username, Email: username, When: time.Now()}
+			if user := users.find(username); user != nil {
+				signature.Name, signature.Email = user.Name, user.Email
+			}
+			if email := req.Header.Get(localConfig.APP_CONFIG.WebEmailHeader); localConfig.APP_CONFIG.WebEmailHeader != "" && email != "" {
+				signature.Email = email
+			}
+
+			return signature
+		}
+	}
+
+	if localConfig.APP_CONFIG.WebUsersFile != "" {
+		username, password, ok := req.BasicAuth()
+		if ok {
+			user := users.find(username)
+			if user != nil && bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) == nil {
+				return &object.Signature{Name: user.Name, Email: user.Email, When: time.Now()}
+			}
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="RFD", charset="UTF-8"`)
+	}
+
+	http.Error(w, "Sign in to make changes", http.StatusUnauthorized)
+	return nil
+}
+
+// serveNew shows the form for a new RFD, and creates it when the form is posted.
+func (s *rfdServer) serveNew(w http.ResponseWriter, req *http.Request) {
+
+	author := authenticate(w, req)
+	if author == nil {
+		return
+	}
+
+	page := &sitePage{
+		Title: "New Request for Discussion",
+		Form:  map[string]string{"authors": author.Name},
+	}
+
+	if req.Method == http.MethodPost {
+
+		title := strings.TrimSpace(req.FormValue("title"))
+		authors := strings.TrimSpace(req.FormValue("authors"))
+		page.Form = map[string]string{"title": title, "authors": authors}
+
+		if title == "" || authors == "" {
+			page.Message = "A title and at least one author are needed."
+			s.writePage(w, http.StatusBadRequest, "new.html", page)
+			return
+		}
+
+		rfdId, err := s.createRFD(title, authors, author)
+		if err != nil {
+			page.Message = "Unable to create the RFD: " + err.Error()
+			s.writePage(w, http.StatusInternalServerError, "new.html", page)
+			return
+		}
+
+		http.Redirect(w, req, "/"+rfdId+"/", http.StatusSeeOther)
+		return
+	}
+
+	s.writePage(w, http.StatusOK, "new.html", page)
+}
+
+// serveEdit shows the form for editing an RFD's readme, and commits it when the form is posted.
+func (s *rfdServer) serveEdit(w http.ResponseWriter, req *http.Request, found *siteRFD) {
+
+	author := authenticate(w, req)
+	if author == nil {
+		return
+	}
+
+	page := &sitePage{
+		Title: "Edit RFD " + found.ID + ": " + found.Title,
+		Root:  "../",
+		RFD:   found,
+		Form:  map[string]string{"readme": string(found.Source.Content)},
+	}
+
+	if req.Method == http.MethodPost {
+
+		// Browsers send the text area with CRLF line endings
+		readme := strings.ReplaceAll(req.FormValue("readme"), "\r\n", "\n")
+		page.Form["readme"] = readme
+
+		err := s.change(func(r *git.Repository) error {
+			return saveRFD(r, found.ID, []byte(readme), author)
+		})
+		if err != nil {
+			page.Message = "Unable to save the RFD: " + err.Error()
+			s.writePage(w, http.StatusConflict, "edit.html", page)
+			return
+		}
+
+		http.Redirect(w, req, "/"+found.ID+"/", http.StatusSeeOther)
+		return
+	}
+
+	s.writePage(w, http.StatusOK, "edit.html", page)
+}
+
+// serveTransition moves an RFD to the state posted.
+func (s *rfdServer) serveTransition(w http.ResponseWriter, req *http.Request, found *siteRFD) {
+
+	if req.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	author := authenticate(w, req)
+	if author == nil {
+		return
+	}
+
+	err := s.change(func(r *git.Repository) error {
+		return transitionRFD(r, found.ID, req.FormValue("state"), author)
+	})
+	if err != nil {
+		s.writePage(w, http.StatusConflict, "rfd.html", &sitePage{
+			Title:   "RFD " + found.ID + ": " + found.Title,
+			Root:    "../",
+			RFD:     found,
+			Message: "Unable to change the state: " + err.Error(),
+		})
+		return
+	}
+
+	http.Redirect(w, req, "/"+found.ID+"/", http.StatusSeeOther)
+}
+
+func (s *rfdServer) createRFD(title string, authors string, author *object.Signature) (string, error) {
+
+	var rfdId string
+
+	err := s.change(func(r *git.Repository) error {
+
+		maxRFDNumber, reachable, err := getMaxRFDNumber(localConfig.APP_CONFIG.Offline)
+		if err != nil {
+			return err
+		}
+		rfdId = formatToNNNN(maxRFDNumber + 1)
+
+		err = runHook(r, PRE_NEW_HOOK, getNewRFDFields(rfdId, title, authors, getDefaultStatus()), author, nil)
+		if err != nil {
+			return err
+		}
+
+		err = earmarkRFD(r, rfdId, title, authors, getDefaultStatus(), "", author)
+		if err != nil {
+			return err
+		}
+
+		err = setUpstream(r, rfdId)
+		if err != nil {
+			return err
+		}
+
+		err = pushOrQueue(r, rfdId, reachable && !localConfig.APP_CONFIG.Offline, true)
+		if err != nil {
+			return err
+		}
+
+		fields := getBranchRFDFields(r, rfdId)
+		runPostHook(r, POST_NEW_HOOK, fields, author, nil)
+		notifyEvent(r, notify.CREATED, fields, author, "")
+		return nil
+	})
+
+	return rfdId, err
+}
+
+// change makes a change to the repository, one at a time, and reloads the RFDs so that it shows at once.
+func (s *rfdServer) change(action func(r *git.Repository) error) error {
+
+	s.git.Lock()
+	r, err := git.PlainOpen(".")
+	if err == nil {
+		err = action(r)
+	}
+	s.git.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	err = s.load()
+	if err != nil {
+		fmt.Println("Unable to reload the RFDs: " + err.Error())
+	}
+	return nil
+}